@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"log"
@@ -42,15 +43,16 @@ type Stats struct {
 
 //All reads all of the records and sends them to a Fields channel.
 //parses the buffer for records then outputs them to cout.
-func All(t *godig.Table, crit string, cout chan Fields) {
+func All(ctx context.Context, t *godig.Table, crit string, cout chan Fields) {
+	ctx, span := godig.Stage(ctx, "All")
+	defer span.End()
 	offset := int32(0)
+	progress := godig.NewProgress()
+	progress.StartTable(t, crit, 0)
 	count := 500
 	for count > 0 {
-		if offset > 0 && offset%5000 == 0 {
-			log.Printf("All: offset %6d\n", offset)
-		}
 		var a []Fields
-		err := t.LeftJoin(offset, count, crit, &a)
+		err := t.LeftJoinContext(ctx, offset, count, crit, &a)
 		if err != nil {
 			log.Fatalf("All: offset %6d %v\n", offset, err)
 			close(cout)
@@ -58,20 +60,23 @@ func All(t *godig.Table, crit string, cout chan Fields) {
 		}
 		count = len(a)
 		if count == 0 {
-			log.Printf("All: offset %6d, done\n", offset)
+			progress.Finish()
 			close(cout)
 			return
 		}
 		for _, r := range a {
 			cout <- r
 		}
+		progress.Add(count)
 		offset = offset + int32(count)
 	}
 	close(cout)
 }
 
 //Store reads stats from a channel and writes them to the CSV file.
-func Store(cin chan Stats, outFile string) error {
+func Store(ctx context.Context, cin chan Stats, outFile string) (err error) {
+	_, span := godig.Stage(ctx, "Store")
+	defer func() { godig.EndStage(span, err) }()
 	f, err := os.Create(outFile)
 	if err != nil {
 		return err
@@ -119,7 +124,9 @@ func Store(cin chan Stats, outFile string) error {
 
 //Use reads Fields records from a channel and accumulates
 //statistical info by email blast.
-func Use(cin chan Fields, cout chan Stats) {
+func Use(ctx context.Context, cin chan Fields, cout chan Stats) {
+	_, span := godig.Stage(ctx, "Use")
+	defer span.End()
 	prevKey := ""
 	var s Stats
 
@@ -165,9 +172,20 @@ func main() {
 		cpath      = kingpin.Flag("login", "YAML file of login credentials for Salsa Classic API").PlaceHolder("FILENAME").Required().String()
 		crit       = kingpin.Flag("criteria", "Search for records matching this criteria").PlaceHolder("CRITERIA").String()
 		apiVerbose = kingpin.Flag("apiVerbose", "Show responses from Salsa.  Can be very noisy.").Bool()
+		tracePath  = kingpin.Flag("trace", "Write OpenTelemetry spans to this file, '-' for stdout").PlaceHolder("FILENAME").String()
 	)
 	kingpin.Parse()
 
+	if len(*tracePath) != 0 {
+		shutdown, err := godig.StartTracing(*tracePath)
+		if err != nil {
+			log.Fatalf("Tracing error: %v\n", err)
+		}
+		defer shutdown(context.Background())
+	}
+	ctx, span := godig.Stage(context.Background(), "blast_donation_report")
+	defer span.End()
+
 	a, err := godig.YAMLAuth(*cpath)
 	if err != nil {
 		log.Fatalf("Authentication error: %+v\n", err)
@@ -199,7 +217,7 @@ func main() {
 		"tag_data(tag.tag=email_blast_KEY)",
 		"email_blast(tag_data.table_KEY=donation_KEY)",
 		"donation"}
-	cond := "tag_data.database_table_KEY=45&condition=tag.prefix=email_blast&donation.RESULT IN (0,-1)"
+	cond := "tag_data.database_table_KEY=45&condition=tag.prefix=email_blast&condition=donation.RESULT IN (0,-1)"
 
 	tableName := strings.Join(clauses, "")
 	t := a.NewTable(tableName)
@@ -212,14 +230,14 @@ func main() {
 	wg.Add(1)
 	go func(cin chan Fields, cout chan Stats, w *sync.WaitGroup) {
 		defer w.Done()
-		Use(cin, cout)
+		Use(ctx, cin, cout)
 	}(cin, cout, &wg)
 	log.Println("Main: Use started")
 
 	wg.Add(1)
 	go func(cout chan Stats, w *sync.WaitGroup) {
 		defer w.Done()
-		err := Store(cout, outFile)
+		err := Store(ctx, cout, outFile)
 		if err != nil {
 			log.Fatalf("Store: %v\n", err)
 		}
@@ -232,7 +250,7 @@ func main() {
 		if len(*crit) != 0 {
 			cond = cond + "&condition=" + *crit
 		}
-		All(&t, cond, cin)
+		All(ctx, &t, cond, cin)
 	}(cin, &wg)
 	log.Println("Main: All started")
 
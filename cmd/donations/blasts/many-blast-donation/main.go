@@ -48,12 +48,10 @@ type FieldMap map[string]*Stats
 //parses the buffer for records then outputs them to cout.
 func All(t *godig.Table, crit string, cout chan Fields) {
 	offset := int32(0)
+	progress := godig.NewProgress()
+	progress.StartTable(t, crit, 0)
 	count := 500
 	for count > 0 {
-		log.Printf("All: %v offset %6d\n", t.Name, offset)
-		if offset > 0 && offset%5000 == 0 {
-			log.Printf("All: %v offset %6d\n", t.Name, offset)
-		}
 		var a []Fields
 		err := t.LeftJoin(offset, count, crit, &a)
 		if err != nil {
@@ -63,13 +61,14 @@ func All(t *godig.Table, crit string, cout chan Fields) {
 		}
 		count = len(a)
 		if count == 0 {
-			log.Printf("All: %v offset %6d, done\n", t.Name, offset)
+			progress.Finish()
 			close(cout)
 			return
 		}
 		for _, r := range a {
 			cout <- r
 		}
+		progress.Add(count)
 		offset = offset + int32(count)
 	}
 }
@@ -1,93 +1,42 @@
 // Cleanup tool.  Accept a date range for donations.  Delete the donations
-// and the supporters that made them.
+// and the supporters that made them.  Everything that depends on those
+// supporters (groups, tags, emails, event signups, etc.) is deleted first.
+// Supporters that have donations outside of the date range are not deleted.
 
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"sync"
-	"time"
+	"os"
 
 	godig "github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-const (
-	//DonationCount is the number of donation deleters to start.
-	DonationCount = 5
-	//SupporterCount is the number of supporter deleters to start.
-	SupporterCount = 5
-	//DonationFlag appears in log lines for donation activity
-	DonationFlag = "d"
-	//SupporterFlag appears in log lines for supporter activity
-	SupporterFlag = "d"
-)
-
-//drive reads donation records that match "criteria" from Salsa Classic.
-//Writes the donation key to "dc" and the supporter key to "sc".  Closes
-//both after all matching donations are read.
-func drive(t *godig.Table, criteria string, dc, sc chan string) {
-	log.Printf("drive: start\n")
-	count := 500
-	offset := int32(0)
-	total := int32(0)
-	for count == 500 {
-		b, err := t.ManyMap(offset, count, criteria)
-		log.Printf("drive: %7d\n", offset)
-		if err != nil {
-			panic(err)
-		}
+//collect reads donation records that match "criteria" from Salsa Classic.
+//Returns the donation keys and the keys of the supporters that made them.
+//Stops with an error if there are more than max donations.
+func collect(t *godig.Table, criteria string, max int) ([]string, []string, error) {
+	log.Printf("collect: start\n")
+	dk := []string{}
+	sk := []string{}
+	err := t.Scan(context.Background(), godig.Query{Criteria: criteria}, func(b []*godig.Record) error {
 		for _, r := range b {
-			dc <- r["donation_KEY"]
-			if len(r["supporter_KEY"]) > 0 {
-				sc <- r["supporter_KEY"]
+			dk = append(dk, r.Get("donation_KEY"))
+			if len(r.Get("supporter_KEY")) > 0 {
+				sk = append(sk, r.Get("supporter_KEY"))
 			}
 		}
-		count = len(b)
-		offset += int32(count)
-		total += int32(count)
-	}
-	close(dc)
-	close(sc)
-	log.Printf("drive: end %d records\n", total)
-}
-
-//whack accepts primary keys from a channel, then uses the table to delete
-//the matching records.  Displays delete results for every record.  Sends
-//a message on the "done" channel when the keys channel is empty.
-func whack(i int, f string, t *godig.Table, c chan string, done chan bool) {
-	log.Printf("whack-%s-%02d: start\n", f, i)
-	for true {
-		k, ok := <-c
-		if !ok {
-			break
-		}
-		var ds godig.DeleteStatus
-		t.Delete(k, &ds)
-		if ds.Result == "error" {
-			for _, m := range ds.Messages {
-				log.Printf("whack-%s-%02d: key %s, %s %s\n", f, i, k, ds.Result, m)
-			}
-		} else {
-			log.Printf("whack-%s-%02d: key %s, %s\n", f, i, k, ds.Result)
+		log.Printf("collect: %7d\n", len(dk))
+		if max > 0 && len(dk) > max {
+			return fmt.Errorf("more than %d donations match", max)
 		}
-	}
-	done <- true
-	log.Printf("whack-%s-%02d: end\n", f, i)
-}
-
-//watch waits for a number of messages on the "done" queue.  Returns after
-//that.  This function makes sure that all channel readers are done before
-//the app terminates.
-func watch(x int, done chan bool) {
-	log.Println("watch: start")
-	for x > 0 {
-		log.Printf("watch: waiting for %d task(s)\n", x)
-		_, _ = <-done
-		x--
-	}
-	log.Println("watch: end")
+		return nil
+	})
+	log.Printf("collect: end %d records\n", len(dk))
+	return dk, sk, err
 }
 
 //main accepts command line arguments then deletes donations that match the
@@ -97,59 +46,81 @@ func main() {
 	sdate := kingpin.Flag("start-date", "First last modified date as YYYY-MM-YY").Default("2021-01-01").String()
 	edate := kingpin.Flag("end-date", "Day after last modified date as YYYY-MM-dd").Default("2021-02-01").String()
 	verbose := kingpin.Flag("verbose", "Lots and *lots* of debug noise.  Not recommended...").Bool()
+	dryRun := kingpin.Flag("dry-run", "Show what would be deleted without deleting anything").Bool()
+	archive := kingpin.Flag("archive", "Save each record to this JSON Lines file before deleting it").PlaceHolder("FILENAME").String()
+	workers := kingpin.Flag("workers", "Number of concurrent deleters").Default("5").Int()
+	max := kingpin.Flag("max", "Refuse to delete more than this many records, zero for no limit").Default("1000").Int()
+	yes := kingpin.Flag("yes", "Delete without asking for confirmation").Bool()
+	audit := kingpin.Flag("audit", "CSV file of delete results").PlaceHolder("FILENAME").Default("delete_donations_audit.csv").String()
+	planPath := kingpin.Flag("plan", "Text file that shows every record that will be deleted").PlaceHolder("FILENAME").Default("delete_donations_plan.txt").String()
+	skipConflicts := kingpin.Flag("skip-conflicts", "Keep supporters that have other donations instead of stopping").Bool()
 	kingpin.Parse()
 	if *edate <= *sdate {
 		log.Fatalf("End date must be after start date!\n")
 	}
 	api, err := godig.YAMLAuth(*cpath)
-	api.Verbose = *verbose
 	if err != nil {
 		log.Fatalf("Authentication error %v\n", err)
 	}
+	api.Verbose = *verbose
+	api.DryRun = *dryRun
+	if len(*archive) != 0 {
+		api.Archive, err = godig.OpenArchive(*archive)
+		if err != nil {
+			log.Fatalf("Archive error %v\n", err)
+		}
+		defer api.Archive.Close()
+	}
 	donation := api.Donation()
 	supporter := api.Supporter()
 	criteria := fmt.Sprintf("Last_Modified>%s&condition=Last_Modified<%s", *sdate, *edate)
 
-	dc := make(chan string, 100)
-	sc := make(chan string, 100)
-	done := make(chan bool, 10)
-	var wg sync.WaitGroup
-
-	// Start donation listeners
-	for i := 0; i < DonationCount; i++ {
-		go (func(i int, wg *sync.WaitGroup, t *godig.Table, c chan string, done chan bool) {
-			wg.Add(1)
-			whack(i, DonationFlag, t, c, done)
-			wg.Done()
-		})(i+1, &wg, &donation, dc, done)
+	// Read everything before deleting anything.  Deleting while reading
+	// moves the offsets and skips records.
+	dk, sk, err := collect(&donation, criteria, *max)
+	if err != nil {
+		log.Fatalf("Collect error %v\n", err)
 	}
 
-	// Start supporter listeners
-	for i := 0; i < SupporterCount; i++ {
-		go (func(i int, wg *sync.WaitGroup, t *godig.Table, c chan string, done chan bool) {
-			wg.Add(1)
-			whack(i, SupporterFlag, t, c, done)
-			wg.Done()
-		})(i+1, &wg, &supporter, sc, done)
+	// Find everything that hangs off of the supporters.  The donations
+	// in the date range are the only activity that can be deleted.
+	p := godig.CascadePlanner{API: api}
+	plan, err := p.Plan(context.Background(), supporter.Name, sk, map[string][]string{donation.Name: dk})
+	if err != nil {
+		log.Fatalf("Plan error %v\n", err)
 	}
+	if len(plan.Conflicts) != 0 {
+		plan.WriteSummary(os.Stdout)
+		if !*skipConflicts {
+			log.Fatalf("Supporters have activity outside of the date range.  Use --skip-conflicts to keep them.\n")
+		}
+		plan.Prune()
+	}
+	f, err := os.Create(*planPath)
+	if err != nil {
+		log.Fatalf("%v, %s\n", err, *planPath)
+	}
+	err = plan.Write(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%v, %s\n", err, *planPath)
+	}
+	log.Printf("Plan in %s\n", *planPath)
 
-	// Start termination listener.
-	go (func(wg *sync.WaitGroup, done chan bool) {
-		wg.Add(1)
-		watch(DonationCount+SupporterCount, done)
-		wg.Done()
-	})(&wg, done)
-
-	// Start driver.
-	go (func(wg *sync.WaitGroup, t *godig.Table, criteria string, dc, sc chan string) {
-		wg.Add(1)
-		drive(t, criteria, dc, sc)
-		wg.Done()
-	})(&wg, &donation, criteria, dc, sc)
-
-	//Classic starts slowly.  Nap a bit before waiting for things to be done.
-	time.Sleep(2 * time.Second)
-
-	// Wait for things to complete.
-	wg.Wait()
+	// Children first, then parents.
+	b := godig.BulkDelete{
+		Sets:      plan.DeleteSets(api),
+		Workers:   *workers,
+		Max:       *max,
+		Yes:       *yes,
+		AuditPath: *audit,
+	}
+	_, err = b.Run(context.Background())
+	if api.DryRun {
+		api.Rehearsal.Write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Delete error %v\n", err)
+	}
+	log.Printf("Done, results in %s\n", *audit)
 }
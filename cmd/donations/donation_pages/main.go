@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"log"
 	"os"
-	"sync"
 
 	godig "github.com/salsalabs/godig/pkg"
+	"github.com/salsalabs/godig/pkg/pipeline"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -19,88 +19,24 @@ type DonatePage struct {
 	DateCreated     string `json:"Date_Created"`
 }
 
-//driver reads donation pages and pushes them onto a channel.
-//The channel is closed at end of data.
-
-func Generate(a *godig.API, c chan DonatePage, initOffset int32) error {
-	t := a.NewTable("donate_page")
-	offset := initOffset
-	if initOffset != int32(0) {
-		log.Printf("Generate: starting read at offset %d\n", initOffset)
-	}
-	count := 500
-	for count > 0 {
-		log.Printf("Generate: %v offset %6d\n", t.Name, offset)
-		if offset > 0 && offset%5000 == 0 {
-			log.Printf("Generate: %v offset %6d\n", t.Name, offset)
-		}
-		var a []DonatePage
-		err := t.Many(offset, count, "", &a)
-		if err != nil {
-			log.Fatalf("Generate: %v offset %6d %v\n", t.Name, offset, err)
-			return err
-		}
-		count = len(a)
-		if count == 0 {
-			log.Printf("Generate: %v offset %6d, done\n", t.Name, offset)
-			return err
-		}
-		for _, r := range a {
-			c <- r
-		}
-		offset = offset + int32(count)
-	}
-	close(c)
-	return nil
+//headers are the columns in donate_pages.csv.
+var headers = []string{
+	"DonatePageKey",
+	"OrganizationKey",
+	"ChapterKey",
+	"ReferenceName",
+	"DateCreated",
 }
 
-//Consume accepts donate page records from a channel and writes
-//them to `donate_pages.csv`.
-func Consume(c chan DonatePage) error {
-	csvFile := "donate_pages.csv"
-	f, err := os.Create(csvFile)
-	if err != nil {
-		log.Fatalf("%v, %v\n", err, csvFile)
-		return err
-	}
-	defer f.Close()
-	writer := csv.NewWriter(f)
-	first := true
-	for {
-		r, okay := <-c
-		if !okay {
-			break
-		}
-		if first {
-			headers := []string{
-				"DonatePageKey",
-				"OrganizationKey",
-				"ChapterKey",
-				"ReferenceName",
-				"DateCreated",
-			}
-			err := writer.Write(headers)
-			if err != nil {
-				log.Fatalf("%v, %v\n", err, csvFile)
-				return err
-			}
-			first = false
-		}
-		row := []string{
-			r.DonatePageKey,
-			r.OrganizationKey,
-			r.ChapterKey,
-			r.ReferenceName,
-			godig.ShortDate(r.DateCreated),
-		}
-		err := writer.Write(row)
-		if err != nil {
-			log.Fatalf("%v, %v\n", err, csvFile)
-			return err
-		}
+//row returns the CSV row for a donate page.
+func row(r DonatePage) []string {
+	return []string{
+		r.DonatePageKey,
+		r.OrganizationKey,
+		r.ChapterKey,
+		r.ReferenceName,
+		godig.ShortDate(r.DateCreated),
 	}
-	writer.Flush()
-	return nil
 }
 
 func main() {
@@ -115,27 +51,36 @@ func main() {
 		log.Fatalf("Authentication error: %+v\n", err)
 	}
 	a.Verbose = *apiVerbose
-	c := make(chan DonatePage, 100)
-	var w sync.WaitGroup
-
-	go func(c chan DonatePage, w *sync.WaitGroup) {
-		defer w.Done()
-		w.Add(1)
-		err := Consume(c)
-		if err != nil {
-			panic(err)
-		}
-	}(c, &w)
-	log.Println("main: Consume started")
-
-	go func(a *godig.API, c chan DonatePage, w *sync.WaitGroup) {
-		defer w.Done()
-		w.Add(1)
-		Generate(a, c, *offset)
-	}(a, c, &w)
+	t := a.NewTable("donate_page")
+	if *offset != int32(0) {
+		log.Printf("main: starting read at offset %d\n", *offset)
+	}
+	total, err := t.CountRecords("")
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	csvFile := "donate_pages.csv"
+	f, err := os.Create(csvFile)
+	if err != nil {
+		log.Fatalf("%v, %v\n", err, csvFile)
+	}
+	defer f.Close()
+	progress := godig.NewProgress()
+	progress.Start(t.Name, total, int(*offset))
 
-	log.Println("main: Generate started")
-	log.Println("main: Waiting for terminations...")
-	w.Wait()
+	p := pipeline.New(context.Background())
+	offsets := pipeline.From(p, pipeline.Offsets(*offset, total))
+	read := pipeline.Page[DonatePage](&t, "")
+	pages := pipeline.Map(p, offsets, func(ctx context.Context, offset int32) ([]DonatePage, error) {
+		a, err := read(ctx, offset)
+		progress.Add(len(a))
+		return a, err
+	})
+	pipeline.To(p, pipeline.Flatten(p, pages), pipeline.WriteRows(f, headers, row))
+	err = p.Wait()
+	progress.Finish()
+	if err != nil {
+		log.Fatalf("%v, %v\n", err, csvFile)
+	}
 	log.Println("main: done")
 }
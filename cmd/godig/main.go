@@ -0,0 +1,13 @@
+//godig is a command line tool for working with the Salsa Classic API.
+//Each task is a subcommand.  The commands live in pkg/cli.
+package main
+
+import (
+	"os"
+
+	"github.com/salsalabs/godig/pkg/cli"
+)
+
+func main() {
+	cli.Main(os.Args[1:])
+}
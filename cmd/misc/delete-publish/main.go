@@ -2,61 +2,61 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-//Fields contains the fields from a publish record
-type Fields struct {
-	PublishKey       string `json:"publish_KEY"`
-	DatabaseTableKEY string `json:"database_table-KEY"`
-	TableKey         string `json:"table_KEY"`
-	TemplateKEY      string `json:"template_KEY"`
-	DateCreated      string `json:"Date_Created"`
-}
-
-//Results are returned from a delete call.
-type Results struct {
-	Object   string
-	Key      string
-	Result   string
-	Messages []string
-}
-
 func main() {
 	cpath := kingpin.Flag("credentials", "YAML file containing credentials for Salsa Classic API").PlaceHolder("FILENAME").Required().String()
 	dtk := kingpin.Flag("database_table_KEY", "database table key").PlaceHolder("DTK").Required().String()
 	tk := kingpin.Flag("table_KEY", "table key").PlaceHolder("TK").Required().String()
+	dryRun := kingpin.Flag("dry-run", "Show what would be deleted without deleting anything").Bool()
+	archive := kingpin.Flag("archive", "Save each record to this JSON Lines file before deleting it").PlaceHolder("FILENAME").String()
+	max := kingpin.Flag("max", "Refuse to delete more than this many records, zero for no limit").Default("1").Int()
+	yes := kingpin.Flag("yes", "Delete without asking for confirmation").Bool()
+	audit := kingpin.Flag("audit", "CSV file of delete results").PlaceHolder("FILENAME").Default("delete_publish_audit.csv").String()
 	kingpin.Parse()
 	api, err := godig.YAMLAuth(*cpath)
 	if err != nil {
 		log.Fatalf("Authentication error %v\n", err)
 	}
-	// Get the publish_KEY for the record.  It's okay if the record does not exist.
+	api.DryRun = *dryRun
+	if len(*archive) != 0 {
+		api.Archive, err = godig.OpenArchive(*archive)
+		if err != nil {
+			log.Fatalf("Archive error %v\n", err)
+		}
+		defer api.Archive.Close()
+	}
+	// It's okay if the record does not exist.
 	t := api.Publish()
 	crit := fmt.Sprintf("database_table_KEY=%v&condition=table_KEY=%v", *dtk, *tk)
 	fmt.Printf("delete-publish, criteria is %v\n", crit)
-	var b []Fields
-	err = t.Many(0, 500, crit, &b)
-	if err != nil {
-		log.Fatalf("Many error %v\n", err)
+	b := godig.BulkDelete{
+		Sets:      []godig.DeleteSet{{Table: &t, Criteria: crit}},
+		Max:       *max,
+		Yes:       *yes,
+		AuditPath: *audit,
 	}
-	if len(b) == 0 {
-		log.Printf("No record match criteria %v\n", crit)
-		return
+	totals, err := b.Run(context.Background())
+	if api.DryRun {
+		api.Rehearsal.Write(os.Stdout)
 	}
-	f := b[0]
-	log.Printf("Match found, %+v\n", f)
-	var r Results
-	err = t.Delete(f.PublishKey, &r)
 	if err != nil {
 		log.Fatalf("Delete error %v\n", err)
 	}
-	if r.Result != "success" {
-		log.Fatalf("Delete error, %v\n", r.Messages)
+	for _, x := range totals {
+		if x.Failed != 0 {
+			log.Fatalf("Delete error, see %s\n", *audit)
+		}
+		if x.Deleted == 0 {
+			log.Printf("No record match criteria %v\n", crit)
+		}
 	}
-	log.Printf("Delete results: %+v\n", r)
+	log.Printf("Delete results in %s\n", *audit)
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/csv"
 	"io"
 	"log"
 	"math"
@@ -9,43 +8,17 @@ import (
 	"strings"
 	"sync"
 
+	godig "github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-//CSVToMap accepts a Reader and returns an array of maps.
-//Many thanks to https://gist.github.com/drernie/5684f9def5bee832ebc50cabb46c377a
-func CSVToMap(reader io.Reader) ([]map[string]string, error) {
-	r := csv.NewReader(reader)
-	rows := []map[string]string{}
-	var header []string
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return rows, err
-		}
-		if header == nil {
-			header = record
-		} else {
-			dict := map[string]string{}
-			for i := range header {
-				dict[header[i]] = record[i]
-			}
-			rows = append(rows, dict)
-		}
-	}
-	return rows, nil
-}
-
 //Filter reads supporter information from a channel.  The email
 //is compared with the previously read record.  If they are "similar"
 //for some values of similarity, then the supporter records are
 //written to the output channel.
-func Filter(s chan map[string]string, t chan map[string]string) {
+func Filter(s chan *godig.Record, t chan *godig.Record) {
 	log.Println("Filter start")
-	var p map[string]string
+	var p *godig.Record
 	for {
 		r, ok := <-s
 		if !ok {
@@ -65,16 +38,20 @@ func Filter(s chan map[string]string, t chan map[string]string) {
 	log.Println("Filter done")
 }
 
-//Pump reads maps from a Reader and writes them to the channel.
+//Pump reads records from a Reader and writes them to the channel.
 //The channel is closed when the reader empties.
-func Pump(r io.Reader, s chan map[string]string) {
+func Pump(r io.Reader, s chan *godig.Record) {
 	log.Println("Pump start")
-	a, err := CSVToMap(r)
+	c, err := godig.NewCSVReader(r, godig.CSVOptions{})
 	if err != nil {
-		log.Fatalf("%v converting CSV to a map", err)
+		log.Fatalf("%v reading CSV", err)
 	}
-	for _, r := range a {
-		s <- r
+	err = c.Each(func(x *godig.Record) error {
+		s <- x
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v reading CSV", err)
 	}
 	close(s)
 	log.Println("Pump done")
@@ -82,35 +59,28 @@ func Pump(r io.Reader, s chan map[string]string) {
 
 //Save reads supporters from a queue and writes them to an output
 //CSV file.
-func Save(fn string, i chan map[string]string) {
+func Save(fn string, i chan *godig.Record) {
 	log.Println("Save start")
 	f, err := os.Create(fn)
 	if err != nil {
 		log.Fatalf("%v on %v", err, fn)
 	}
-	w := csv.NewWriter(f)
-	var h []string
+	// Columns are in input order.
+	w := godig.NewCSVWriter(f)
 	for {
 		r, ok := <-i
 		if !ok {
 			break
 		}
-		if h == nil {
-			for k := range r {
-				h = append(h, k)
-			}
-			w.Write(h)
-		}
-		var a []string
-		for _, s := range h {
-			a = append(a, r[s])
-		}
-		err := w.Write(a)
+		err := w.Write(r)
 		if err != nil {
 			log.Fatalf("%v on %v", err, fn)
 		}
 	}
-	w.Flush()
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("%v on %v", err, fn)
+	}
 	log.Println("Save done")
 }
 
@@ -148,10 +118,10 @@ func MatchPercent(a, b string) float64 {
 //Similar compares two supporter records to determine if they
 //are similar for certain values of similarity.  Returns true
 //if they are similar, false otherwise.
-func Similar(p, r map[string]string) bool {
-	if p["InternalID"] != r["InternalID"] {
-		n1 := Identify(p["Email"])
-		n2 := Identify(r["Email"])
+func Similar(p, r *godig.Record) bool {
+	if p.Get("InternalID") != r.Get("InternalID") {
+		n1 := Identify(p.Get("Email"))
+		n2 := Identify(r.Get("Email"))
 		pc := MatchPercent(n1, n2)
 		return pc > 70.0
 	}
@@ -171,23 +141,23 @@ func main() {
 		log.Fatalf("%v on %v", err, *ipath)
 	}
 	var wg sync.WaitGroup
-	s := make(chan map[string]string, 100)
-	t := make(chan map[string]string, 100)
+	s := make(chan *godig.Record, 100)
+	t := make(chan *godig.Record, 100)
 
-	go func(wg *sync.WaitGroup, s chan map[string]string, t chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, s chan *godig.Record, t chan *godig.Record) {
 		Filter(s, t)
 		wg.Done()
 	}(&wg, s, t)
 
-	go func(wg *sync.WaitGroup, fn string, t chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, fn string, t chan *godig.Record) {
 		Save(fn, t)
 		wg.Done()
 	}(&wg, *opath, t)
 
-	go func(wg *sync.WaitGroup, r io.Reader, s chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, r io.Reader, s chan *godig.Record) {
 		Pump(f, s)
 		wg.Done()
 	}(&wg, f, s)
@@ -2,7 +2,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"strings"
 
 	"github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -11,17 +13,41 @@ import (
 func main() {
 	cpath := kingpin.Flag("credentials", "YAML file containing credentials for Salsa Classic API").PlaceHolder("FILENAME").Required().String()
 	table := kingpin.Flag("table", "table name ([supporter], donation, groups, etc.)").PlaceHolder("TABLE").Default("supporter").String()
-	key := kingpin.Flag("key", "primary key").PlaceHolder("KEY").Required().String()
+	key := kingpin.Flag("key", "primary key, zero to insert").PlaceHolder("KEY").Required().String()
 	cond := kingpin.Flag("conditions", "(Optional) Salsa-formatted API condition").PlaceHolder("CONDITION").String()
+	fields := kingpin.Flag("field", "(Optional) field to save, repeat as needed").PlaceHolder("NAME=VALUE").StringMap()
+	links := kingpin.Flag("link", "(Optional) link to a record in another table, repeat as needed").PlaceHolder("TABLE=KEY").Strings()
+	tags := kingpin.Flag("tag", "(Optional) tag to add to the record, repeat as needed").PlaceHolder("TAG").Strings()
+	dryRun := kingpin.Flag("dry-run", "Show what would be saved without saving anything").Bool()
 	kingpin.Parse()
 	api, err := godig.YAMLAuth(*cpath)
 	if err != nil {
 		log.Fatalf("Authentication error %v\n", err)
 	}
+	api.DryRun = *dryRun
 	t := api.NewTable(*table)
-	_, err = t.Save(*key, *cond)
+	if len(*cond) != 0 {
+		_, err = t.Save(*key, *cond)
+		if err != nil {
+			log.Fatalf("Save error %v\n", err)
+		}
+		log.Printf("Saved")
+		return
+	}
+	if len(*fields) == 0 && len(*links) == 0 && len(*tags) == 0 {
+		log.Fatalf("Nothing to save.  Use --field, --link, --tag or --conditions.\n")
+	}
+	opts := godig.SaveOptions{Tags: *tags}
+	for _, x := range *links {
+		i := strings.Index(x, "=")
+		if i < 1 {
+			log.Fatalf("--link %s: use TABLE=KEY\n", x)
+		}
+		opts.Links = append(opts.Links, godig.Link{Table: strings.TrimSpace(x[:i]), Key: strings.TrimSpace(x[i+1:])})
+	}
+	k, r, err := t.SaveRecordWith(context.Background(), *key, *fields, opts)
 	if err != nil {
 		log.Fatalf("Save error %v\n", err)
 	}
-	log.Printf("Saved")
+	log.Printf("Saved %s key %s, %s %v\n", t.Name, k, r.Result, r.Messages)
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	godig "github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -33,39 +33,12 @@ type TruMail struct {
 	Free        bool   `json:"free"`
 }
 
-//CSVToMap accepts a Reader and returns an array of maps.
-//Many thanks to https://gist.github.com/drernie/5684f9def5bee832ebc50cabb46c377a
-func CSVToMap(reader io.Reader) ([]map[string]string, error) {
-	r := csv.NewReader(reader)
-	rows := []map[string]string{}
-	var header []string
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return rows, err
-		}
-		if header == nil {
-			header = record
-		} else {
-			dict := map[string]string{}
-			for i := range header {
-				dict[header[i]] = record[i]
-			}
-			rows = append(rows, dict)
-		}
-	}
-	return rows, nil
-}
-
 //Lookup sreads supporters from the source channel and looks up the
 //Email address. The supporter record is augmented with the results
 //of the lookup.  Lookup failures append empty fields to the supporter
 //records.  Records are written to the target channel.  The target
 //channel is closed when there is no mmore data on the source channel.
-func Lookup(s chan map[string]string, t chan map[string]string) {
+func Lookup(s chan *godig.Record, t chan *godig.Record) {
 	log.Println("Lookup start")
 	trumail := "https://api.trumail.io/v2/lookups/json?email=%s"
 	c := http.Client{
@@ -76,7 +49,7 @@ func Lookup(s chan map[string]string, t chan map[string]string) {
 		if !ok {
 			break
 		}
-		u := fmt.Sprintf(trumail, r["Email"])
+		u := fmt.Sprintf(trumail, r.Get("Email"))
 		var validFormat bool
 		var deliverable bool
 		var hostExists bool
@@ -106,26 +79,30 @@ func Lookup(s chan map[string]string, t chan map[string]string) {
 		} else {
 			log.Printf("%v on %v", err, u)
 		}
-		r["ValidFormat"] = fmt.Sprintf("%v", validFormat)
-		r["Deliverable"] = fmt.Sprintf("%v", deliverable)
-		r["HostExists"] = fmt.Sprintf("%v", hostExists)
-		log.Printf("Lookup: %-40s %s %s %s\n", r["Email"], r["ValidFormat"], r["Deliverable"], r["HostExists"])
+		r.Set("ValidFormat", fmt.Sprintf("%v", validFormat))
+		r.Set("Deliverable", fmt.Sprintf("%v", deliverable))
+		r.Set("HostExists", fmt.Sprintf("%v", hostExists))
+		log.Printf("Lookup: %-40s %s %s %s\n", r.Get("Email"), r.Get("ValidFormat"), r.Get("Deliverable"), r.Get("HostExists"))
 		t <- r
 	}
 	close(t)
 	log.Println("Lookup start")
 }
 
-//Pump reads maps from a Reader and writes them to the channel.
+//Pump reads records from a Reader and writes them to the channel.
 //The channel is closed when the reader empties.
-func Pump(r io.Reader, s chan map[string]string) {
+func Pump(r io.Reader, s chan *godig.Record) {
 	log.Println("Pump start")
-	a, err := CSVToMap(r)
+	c, err := godig.NewCSVReader(r, godig.CSVOptions{})
 	if err != nil {
-		log.Fatalf("%v converting CSV to a map", err)
+		log.Fatalf("%v reading CSV", err)
 	}
-	for _, r := range a {
-		s <- r
+	err = c.Each(func(x *godig.Record) error {
+		s <- x
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v reading CSV", err)
 	}
 	close(s)
 	log.Println("Pump done")
@@ -133,35 +110,28 @@ func Pump(r io.Reader, s chan map[string]string) {
 
 //Save reads supporters from a queue and writes them to an output
 //CSV file.
-func Save(fn string, i chan map[string]string) {
+func Save(fn string, i chan *godig.Record) {
 	log.Println("Save start")
 	f, err := os.Create(fn)
 	if err != nil {
 		log.Fatalf("%v on %v", err, fn)
 	}
-	w := csv.NewWriter(f)
-	var h []string
+	// Columns are in input order, followed by the lookup results.
+	w := godig.NewCSVWriter(f)
 	for {
 		r, ok := <-i
 		if !ok {
 			break
 		}
-		if h == nil {
-			for k := range r {
-				h = append(h, k)
-			}
-			w.Write(h)
-		}
-		var a []string
-		for _, s := range h {
-			a = append(a, r[s])
-		}
-		err := w.Write(a)
+		err := w.Write(r)
 		if err != nil {
 			log.Fatalf("%v on %v", err, fn)
 		}
 	}
-	w.Flush()
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("%v on %v", err, fn)
+	}
 	log.Println("Save done")
 }
 
@@ -176,23 +146,23 @@ func main() {
 		log.Fatalf("%v on %v", err, *ipath)
 	}
 	var wg sync.WaitGroup
-	s := make(chan map[string]string, 100)
-	t := make(chan map[string]string, 100)
+	s := make(chan *godig.Record, 100)
+	t := make(chan *godig.Record, 100)
 
-	go func(wg *sync.WaitGroup, s chan map[string]string, t chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, s chan *godig.Record, t chan *godig.Record) {
 		Lookup(s, t)
 		wg.Done()
 	}(&wg, s, t)
 
-	go func(wg *sync.WaitGroup, fn string, t chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, fn string, t chan *godig.Record) {
 		Save(fn, t)
 		wg.Done()
 	}(&wg, *opath, t)
 
-	go func(wg *sync.WaitGroup, r io.Reader, s chan map[string]string) {
-		wg.Add(1)
+	wg.Add(1)
+	go func(wg *sync.WaitGroup, r io.Reader, s chan *godig.Record) {
 		Pump(f, s)
 		wg.Done()
 	}(&wg, f, s)
@@ -1,9 +1,10 @@
-//Query for a record, see JSON.
+//Delete every group in the organization.
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"os"
 
 	"github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -11,29 +12,39 @@ import (
 
 func main() {
 	cpath := kingpin.Flag("login", "YAML file containing login credentials for Salsa Classic API").PlaceHolder("FILENAME").Required().String()
+	dryRun := kingpin.Flag("dry-run", "Show what would be deleted without deleting anything").Bool()
+	archive := kingpin.Flag("archive", "Save each record to this JSON Lines file before deleting it").PlaceHolder("FILENAME").String()
+	workers := kingpin.Flag("workers", "Number of concurrent deleters").Default("5").Int()
+	max := kingpin.Flag("max", "Refuse to delete more than this many records, zero for no limit").Default("1000").Int()
+	yes := kingpin.Flag("yes", "Delete without asking for confirmation").Bool()
+	audit := kingpin.Flag("audit", "CSV file of delete results").PlaceHolder("FILENAME").Default("delete_groups_audit.csv").String()
 	kingpin.Parse()
 	api, err := godig.YAMLAuth(*cpath)
 	if err != nil {
 		log.Fatalf("Authentication error %v\n", err)
 	}
-	t := api.NewTable("groups")
-	crit := ""
-	count := 500
-	offset := int32(0)
-	for count > 0 {
-		fmt.Printf("offset %d, count %d\n", offset, count)
-		b, err := t.ManyMap(offset, count, crit)
+	api.DryRun = *dryRun
+	if len(*archive) != 0 {
+		api.Archive, err = godig.OpenArchive(*archive)
 		if err != nil {
-			panic(err)
+			log.Fatalf("Archive error %v\n", err)
 		}
-		for i, r := range b {
-			x := offset + int32(i) + 1
-			fmt.Printf("%5d: %8v %-30v\n", x, r["groups_KEY"], r["Group_Name"])
-			var ds godig.DeleteStatus
-			t.Delete(r["groups_KEY"], &ds)
-			fmt.Printf("%5d: %8v %-30v %+v\n", x, r["groups_KEY"], r["Group_Name"], ds)
-		}
-		count = len(b)
-		offset += int32(count)
+		defer api.Archive.Close()
+	}
+	t := api.Groups()
+	b := godig.BulkDelete{
+		Sets:      []godig.DeleteSet{{Table: &t, All: true}},
+		Workers:   *workers,
+		Max:       *max,
+		Yes:       *yes,
+		AuditPath: *audit,
+	}
+	_, err = b.Run(context.Background())
+	if api.DryRun {
+		api.Rehearsal.Write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Delete error %v\n", err)
 	}
+	log.Printf("Done, results in %s\n", *audit)
 }
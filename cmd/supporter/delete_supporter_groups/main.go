@@ -1,99 +1,50 @@
-//Query for a record, see JSON.
+//Delete every supporter_groups record in the organization.
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
-	"strconv"
-	"sync"
+	"os"
 
 	"github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-const (
-	WhackCount = 10
-	DriveCount = 5
-)
-
-func drive(id int, t *godig.Table, offsets chan int32, c chan string, done chan bool) {
-	fmt.Printf("drive-%02d: start\n", id)
-	for offset := range offsets {
-		b, err := t.ManyMap(offset, 500, "")
-		fmt.Printf("drive-%02d: %7d\n", id, offset)
-		if err != nil {
-			panic(err)
-		}
-		for _, r := range b {
-			c <- r["supporter_groups_KEY"]
-		}
-	}
-	close(c)
-	fmt.Printf("drive-%02d: end", id)
-	done <- true
-}
-
-func whack(id int, t *godig.Table, c chan string, done chan bool) {
-	count := int32(0)
-	fmt.Printf("whack-%02d: start\n", id)
-	for k := range c {
-		var ds godig.DeleteStatus
-		t.Delete(k, &ds)
-		count++
-		if count%1000 == 0 {
-			fmt.Printf("whack-%02d: %7d\n", id, count)
-		}
-	}
-	fmt.Printf("whack-%02d: end", id)
-	done <- true
-}
-func watch(x int, done chan bool) {
-	fmt.Println("watch: start")
-	for x > 0 {
-		fmt.Printf("watch: watiing for %d\n", x)
-		_, _ = <-done
-		x--
-	}
-	fmt.Println("watch: end")
-}
-
 func main() {
 	cpath := kingpin.Flag("login", "YAML file containing login credentials for Salsa Classic API").PlaceHolder("FILENAME").Required().String()
+	dryRun := kingpin.Flag("dry-run", "Show what would be deleted without deleting anything").Bool()
+	archive := kingpin.Flag("archive", "Save each record to this JSON Lines file before deleting it").PlaceHolder("FILENAME").String()
+	workers := kingpin.Flag("workers", "Number of concurrent deleters").Default("10").Int()
+	max := kingpin.Flag("max", "Refuse to delete more than this many records, zero for no limit").Default("1000").Int()
+	yes := kingpin.Flag("yes", "Delete without asking for confirmation").Bool()
+	audit := kingpin.Flag("audit", "CSV file of delete results").PlaceHolder("FILENAME").Default("delete_supporter_groups_audit.csv").String()
 	kingpin.Parse()
 	api, err := godig.YAMLAuth(*cpath)
 	if err != nil {
 		log.Fatalf("Authentication error %v\n", err)
 	}
-	t := api.NewTable("supporter_groups")
-	var wg sync.WaitGroup
-	s, _ := t.Count("")
-	x, _ := strconv.ParseInt(s, 10, 64)
-	limit := int32(x)
-	fmt.Printf("main: processing %8v\n", limit)
-	c := make(chan string, 1000)
-	offsets := make(chan int32, 1000)
-	done := make(chan bool, 20)
-
-	for i := 0; i < 10; i++ {
-		go (func(i int, wg *sync.WaitGroup, t *godig.Table, c chan string, done chan bool) {
-			wg.Add(1)
-			whack(i, t, c, done)
-			wg.Done()
-		})(i+1, &wg, &t, c, done)
+	api.DryRun = *dryRun
+	if len(*archive) != 0 {
+		api.Archive, err = godig.OpenArchive(*archive)
+		if err != nil {
+			log.Fatalf("Archive error %v\n", err)
+		}
+		defer api.Archive.Close()
 	}
-	for i := 0; i < 5; i++ {
-		go (func(i int, wg *sync.WaitGroup, t *godig.Table, offsets chan int32, c chan string, done chan bool) {
-			wg.Add(1)
-			drive(i, t, offsets, c, done)
-			wg.Done()
-		})(i+1, &wg, &t, offsets, c, done)
+	t := api.SupporterGroups()
+	b := godig.BulkDelete{
+		Sets:      []godig.DeleteSet{{Table: &t, All: true}},
+		Workers:   *workers,
+		Max:       *max,
+		Yes:       *yes,
+		AuditPath: *audit,
 	}
-	var j int32
-	for j = 0; j < limit; j += 500 {
-		offsets <- j
-		fmt.Printf("main: %7d pushed\n", j)
+	_, err = b.Run(context.Background())
+	if api.DryRun {
+		api.Rehearsal.Write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Delete error %v\n", err)
 	}
-	fmt.Println("main: waiting")
-	watch(WhackCount+DriveCount, done)
-	wg.Wait()
+	log.Printf("Done, results in %s\n", *audit)
 }
@@ -39,12 +39,10 @@ type Fields struct {
 func All(a *godig.API, cout chan Fields) {
 	t := a.NewTable(tableName)
 	offset := int32(0)
+	progress := godig.NewProgress()
+	progress.StartTable(&t, criteria, 0)
 	count := 500
 	for count > 0 {
-		log.Printf("All: %v offset %6d\n", t.Name, offset)
-		if offset > 0 && offset%5000 == 0 {
-			log.Printf("All: %v offset %6d\n", t.Name, offset)
-		}
 		var a []Fields
 		err := t.LeftJoin(offset, count, criteria, &a)
 		if err != nil {
@@ -53,12 +51,13 @@ func All(a *godig.API, cout chan Fields) {
 		}
 		count = len(a)
 		if count == 0 {
-			log.Printf("All: %v offset %6d, done\n", t.Name, offset)
+			progress.Finish()
 			break
 		}
 		for _, r := range a {
 			cout <- r
 		}
+		progress.Add(count)
 		offset = offset + int32(count)
 	}
 	close(cout)
@@ -1,24 +1,39 @@
 package main
 
 //Application to accept a CSV file of last-name, first-name,
-//the display supporter_KEY, names and email.
+//the display supporter_KEY, names and email.  Names are looked up many
+//at a time.  Names that aren't found are written to names_not_found.csv.
+//Names that match more than one supporter are written to
+//names_multiple.csv as well as names_and_emails.csv.
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	godig "github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-// Supporter holds the data that we want from this supporter.
-type Supporter struct {
-	SupporterKEY string `json:"supporter_KEY"`
-	FirstName    string `json:"First_Name,omitempty"`
-	LastName     string `json:"Last_Name,omitempty"`
-	Email        string `json:"Email,omitEmpty"`
+const outFile = "names_and_emails.csv"
+const notFoundFile = "names_not_found.csv"
+const multipleFile = "names_multiple.csv"
+
+//create creates a CSV file and writes the header row.
+func create(p string, h []string) (*os.File, *csv.Writer) {
+	f, err := os.Create(p)
+	if err != nil {
+		log.Fatalf("%v on %v", err, p)
+	}
+	w := csv.NewWriter(f)
+	err = w.Write(h)
+	if err != nil {
+		log.Fatalf("%s, %s", p, err)
+	}
+	return f, w
 }
 
 //Mainline.  Find supporters and display some info about each.
@@ -26,64 +41,89 @@ func main() {
 	cpath := kingpin.Flag("login", "YAML file containing credentials for Salsa Classic API").Required().String()
 	csvFile := kingpin.Flag("csv-file", "Search for records in this file").Required().String()
 	apiVerbose := kingpin.Flag("apiVerbose", "Show all interactions with the server.  Verrry noisy").Bool()
+	header := kingpin.Flag("header", "The file has a header row with Last_Name and First_Name columns.  Otherwise, they're the first two columns").Bool()
+	encoding := kingpin.Flag("encoding", "Encoding of the CSV file.  Excel often uses windows-1252").Default("utf-8").Enum(godig.CSVEncodings...)
+	workers := kingpin.Flag("workers", "Number of lookups to run at the same time").Default("4").Int()
 	kingpin.Parse()
 
-	a, err := godig.YAMLAuth(*cpath)
+	a, err := godig.YAMLAuth(*cpath, godig.WithConcurrency(*workers))
 	if err != nil {
 		log.Fatalf("Authentication error: %+v\n", err)
 	}
 	a.Verbose = *apiVerbose
-	t := a.Supporter()
 
-	f, err := os.Open(*csvFile)
+	o := godig.CSVOptions{Encoding: *encoding}
+	if !*header {
+		o.Header = []string{"Last_Name", "First_Name"}
+	}
+	r, err := godig.OpenCSV(*csvFile, o)
 	if err != nil {
 		log.Fatalf("%v on %v", err, *csvFile)
 	}
-	defer f.Close()
-	r := csv.NewReader(f)
-	all, err := r.ReadAll()
-
-	f2, err := os.Create("names_and_emails.csv")
-	w := csv.NewWriter(f2)
-	h := []string{
-		"SupporterKEY",
-		"FirstName",
-		"LastName",
-		"Email",
-	}
-	err = w.Write(h)
+	defer r.Close()
+	var keys [][]string
+	err = r.Each(func(row *godig.Record) error {
+		keys = append(keys, []string{row.Get("Last_Name"), row.Get("First_Name")})
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("%s, %s", "names_and_emails.csv", err)
+		log.Fatalf("%v\n", err)
 	}
-	defer w.Flush()
-	var s []Supporter
 
-	// Each row is a string slice of (Last_Name, First_Name)
-	for _, row := range all {
-		lastName := row[0]
-		firstName := row[1]
+	f1, w1 := create(outFile, []string{"SupporterKEY", "FirstName", "LastName", "Email"})
+	defer f1.Close()
+	defer w1.Flush()
+	f2, w2 := create(notFoundFile, []string{"LastName", "FirstName", "Error"})
+	defer f2.Close()
+	defer w2.Flush()
+	f3, w3 := create(multipleFile, []string{"LastName", "FirstName", "Count", "SupporterKEYs"})
+	defer f3.Close()
+	defer w3.Flush()
 
-		criteria := fmt.Sprintf("First_Name=%s&condition=last_Name=%s", firstName, lastName)
-		err = t.Many(int32(0), 500, criteria, &s)
-		if err != nil {
-			log.Fatalf("'%s %s', %v\n", firstName, lastName, err)
+	t := a.Supporter()
+	f := godig.Finder{
+		Table:    &t,
+		Fields:   []string{"Last_Name", "First_Name"},
+		Include:  []string{"supporter_KEY", "Email"},
+		Workers:  *workers,
+		Progress: godig.NewProgress(),
+	}
+	sum, err := f.Run(context.Background(), keys, func(x godig.FindResult) error {
+		lastName, firstName := x.Key[0], x.Key[1]
+		if x.Err != nil || len(x.Records) == 0 {
+			msg := ""
+			if x.Err != nil {
+				msg = x.Err.Error()
+			}
+			return w2.Write([]string{lastName, firstName, msg})
 		}
-		if len(s) == 0 {
-			fmt.Printf("'%s %s' not found\n", firstName, lastName)
-		} else {
-			for _, record := range s {
-				fmt.Printf("'%s %s' %-8s %s\n", firstName, lastName, record.SupporterKEY, record.Email)
-				a := []string{
-					record.SupporterKEY,
-					record.FirstName,
-					record.LastName,
-					record.Email,
-				}
-				err = w.Write(a)
-				if err != nil {
-					log.Fatalf("%s writing to CSV\n", err)
-				}
+		if len(x.Records) > 1 {
+			var k []string
+			for _, record := range x.Records {
+				k = append(k, record.Get("supporter_KEY"))
+			}
+			err := w3.Write([]string{lastName, firstName, fmt.Sprint(len(k)), strings.Join(k, ",")})
+			if err != nil {
+				return err
 			}
 		}
+		for _, record := range x.Records {
+			a := []string{
+				record.Get("supporter_KEY"),
+				record.Get("First_Name"),
+				record.Get("Last_Name"),
+				record.Get("Email"),
+			}
+			err := w1.Write(a)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v\n", err)
 	}
+	log.Printf("%d names, %d found, %d not found, %d with more than one supporter, %d errors\n",
+		sum.Keys, sum.Matched, sum.NoMatch, sum.Multiple, sum.Errors)
 }
@@ -1,28 +1,41 @@
 package main
 
 //Application to accept a CSV file of recurring donation
-//profile IDs and return supporter information.
+//profile IDs and return supporter information.  Profile IDs are looked
+//up many at a time.  Profile IDs that aren't found are written to
+//profiles_not_found.csv.  Profile IDs that match more than one record
+//are written to profiles_multiple.csv as well as
+//profiles_and_supporters.csv.
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	godig "github.com/salsalabs/godig/pkg"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 const outFile = "profiles_and_supporters.csv"
+const notFoundFile = "profiles_not_found.csv"
+const multipleFile = "profiles_multiple.csv"
 const tableName = "recurring_donation(supporter_KEY)supporter"
 
-// supporter holds the data that we want from this supporter.
-type supporter struct {
-	ProfileID    string `json:"PROFILEID,omitempty"`
-	SupporterKEY string `json:"supporter_KEY"`
-	FirstName    string `json:"First_Name,omitempty"`
-	LastName     string `json:"Last_Name,omitempty"`
-	Email        string `json:"Email,omitempty"`
+//create creates a CSV file and writes the header row.
+func create(p string, h []string) (*os.File, *csv.Writer) {
+	f, err := os.Create(p)
+	if err != nil {
+		log.Fatalf("%v on %v", err, p)
+	}
+	w := csv.NewWriter(f)
+	err = w.Write(h)
+	if err != nil {
+		log.Fatalf("%s, %s", p, err)
+	}
+	return f, w
 }
 
 //Mainline.  Find supporters and display some info about each.
@@ -30,69 +43,89 @@ func main() {
 	cpath := kingpin.Flag("login", "YAML file containing credentials for Salsa Classic API").Required().String()
 	csvFile := kingpin.Flag("csv-file", "Search for recurring profiles from this file").Required().String()
 	apiVerbose := kingpin.Flag("apiVerbose", "Show all interactions with the server.  Verrry noisy").Bool()
+	header := kingpin.Flag("header", "The file has a header row with a PROFILEID column.  Otherwise, it's the first column").Bool()
+	encoding := kingpin.Flag("encoding", "Encoding of the CSV file.  Excel often uses windows-1252").Default("utf-8").Enum(godig.CSVEncodings...)
+	workers := kingpin.Flag("workers", "Number of lookups to run at the same time").Default("4").Int()
 	kingpin.Parse()
 
-	a, err := godig.YAMLAuth(*cpath)
+	a, err := godig.YAMLAuth(*cpath, godig.WithConcurrency(*workers))
 	if err != nil {
 		log.Fatalf("Authentication error: %+v\n", err)
 	}
 	a.Verbose = *apiVerbose
-	t := a.NewTable(tableName)
 
-	f, err := os.Open(*csvFile)
-	if err != nil {
-		log.Fatalf("%v on %v", err, *csvFile)
+	o := godig.CSVOptions{Encoding: *encoding}
+	if !*header {
+		o.Header = []string{"PROFILEID"}
 	}
-	defer f.Close()
-	r := csv.NewReader(f)
-	all, err := r.ReadAll()
-
-	f2, err := os.Create(outFile)
+	r, err := godig.OpenCSV(*csvFile, o)
 	if err != nil {
 		log.Fatalf("%v on %v", err, *csvFile)
 	}
-	defer f2.Close()
-
-	w := csv.NewWriter(f2)
-	h := []string{
-		"PROFILEID",
-		"SupporterKEY",
-		"FirstName",
-		"LastName",
-		"Email",
-	}
-	err = w.Write(h)
+	defer r.Close()
+	var keys [][]string
+	err = r.Each(func(row *godig.Record) error {
+		keys = append(keys, []string{row.Get("PROFILEID")})
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("%s, %s", outFile, err)
+		log.Fatalf("%v\n", err)
 	}
-	defer w.Flush()
-	var s []supporter
 
-	// Each row is a string slice of (Last_Name, First_Name)
-	for _, row := range all {
-		profileID := row[0]
+	f1, w1 := create(outFile, []string{"PROFILEID", "SupporterKEY", "FirstName", "LastName", "Email"})
+	defer f1.Close()
+	defer w1.Flush()
+	f2, w2 := create(notFoundFile, []string{"PROFILEID", "Error"})
+	defer f2.Close()
+	defer w2.Flush()
+	f3, w3 := create(multipleFile, []string{"PROFILEID", "Count", "SupporterKEYs"})
+	defer f3.Close()
+	defer w3.Flush()
 
-		criteria := fmt.Sprintf("recurring_donation.PROFILEID=%s", profileID)
-		err = t.LeftJoin(int32(0), 500, criteria, &s)
-		if err != nil {
-			log.Fatalf("'%s', %v\n", profileID, err)
+	t := a.NewTable(tableName)
+	f := godig.Finder{
+		Table:    &t,
+		Fields:   []string{"recurring_donation.PROFILEID"},
+		Workers:  *workers,
+		Progress: godig.NewProgress(),
+	}
+	sum, err := f.Run(context.Background(), keys, func(x godig.FindResult) error {
+		profileID := x.Key[0]
+		if x.Err != nil || len(x.Records) == 0 {
+			msg := ""
+			if x.Err != nil {
+				msg = x.Err.Error()
+			}
+			return w2.Write([]string{profileID, msg})
 		}
-		if len(s) == 0 {
-			log.Printf("'%s', no supporter found\n", profileID)
-		} else {
-			for _, record := range s {
-				a := []string{
-					record.ProfileID,
-					record.SupporterKEY,
-					record.FirstName,
-					record.LastName,
-					record.Email,
-				}
-				err = w.Write(a)
-				if err != nil {
-					log.Fatalf("%s writing to CSV\n", err)
-				}
+		if len(x.Records) > 1 {
+			var k []string
+			for _, record := range x.Records {
+				k = append(k, record.Get("supporter_KEY"))
+			}
+			err := w3.Write([]string{profileID, fmt.Sprint(len(k)), strings.Join(k, ",")})
+			if err != nil {
+				return err
 			}
 		}
+		for _, record := range x.Records {
+			a := []string{
+				record.Get("PROFILEID"),
+				record.Get("supporter_KEY"),
+				record.Get("First_Name"),
+				record.Get("Last_Name"),
+				record.Get("Email"),
+			}
+			err := w1.Write(a)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("%v\n", err)
 	}
+	log.Printf("%d profiles, %d found, %d not found, %d with more than one supporter, %d errors\n",
+		sum.Keys, sum.Matched, sum.NoMatch, sum.Multiple, sum.Errors)
 }
@@ -2,23 +2,81 @@ package godig
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
-//FixCrit Replace spaces and percent signs in the criteria so that Saosa
-//consumes them correctly.
+//FixCrit Replace spaces, percent signs and other URL characters in the
+//criteria so that Saosa consumes them correctly.  An "&" that starts a
+//parameter, like "&condition=", separates conditions.  Any other "&" is
+//part of a value.
 func FixCrit(c string) string {
-	c = strings.Replace(c, "%", "%25", -1)
-	c = strings.Replace(c, " ", "%20", -1)
-	return c
+	var b strings.Builder
+	for i := 0; i < len(c); i++ {
+		switch c[i] {
+		case '%':
+			b.WriteString("%25")
+		case ' ':
+			b.WriteString("%20")
+		case '+':
+			b.WriteString("%2B")
+		case '#':
+			b.WriteString("%23")
+		case '&':
+			if startsParam(c[i+1:]) {
+				b.WriteByte('&')
+			} else {
+				b.WriteString("%26")
+			}
+		default:
+			b.WriteByte(c[i])
+		}
+	}
+	return b.String()
+}
+
+//critParams are the URL parameters that can follow criteria.
+var critParams = []string{"condition", "include", "exclude", "orderBy", "groupBy", "limit", "offset", "tag"}
+
+//startsParam returns true if s starts with a parameter, like
+//"condition=".
+func startsParam(s string) bool {
+	for _, p := range critParams {
+		if strings.HasPrefix(s, p+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+//critValue returns an error for a value that can't be put into criteria.
+//A value with "&" before a parameter, like "a&condition=b", can't be told
+//apart from two conditions.
+func critValue(v string) error {
+	for i, x := range strings.Split(v, "&") {
+		if i > 0 && startsParam(x) {
+			return fmt.Errorf("can't use '%s' in a condition, it contains '&%s'", v, x[:strings.Index(x, "=")+1])
+		}
+	}
+	return nil
+}
+
+//condition returns the criteria as URL parameters.  Criteria that start
+//with "&", like "&include=Email", only have extra parameters.
+func condition(crit string) string {
+	if strings.HasPrefix(crit, "&") {
+		return FixCrit(crit)
+	}
+	return "&condition=" + FixCrit(crit)
 }
 
 //Authenticate and save the cookies for later.
@@ -60,6 +118,20 @@ func (t *Table) Count(c string) (string, error) {
 	return string(body), err
 }
 
+//CountRecords returns the number of records in the table that match the
+//criteria as a number.
+func (t *Table) CountRecords(c string) (int, error) {
+	x, err := t.Count(c)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(x))
+	if err != nil {
+		return 0, fmt.Errorf("count %s: unexpected response '%s'", t.Name, x)
+	}
+	return n, nil
+}
+
 //Credentials retrieves the login credentials from a YAML login file.
 func Credentials(p string) (CredData, error) {
 	var c CredData
@@ -75,10 +147,23 @@ func Credentials(p string) (CredData, error) {
 }
 
 //Delete does a Salsa API /delete.  The caller provides a key. We whack that record.
+//In dry-run mode, the delete is recorded and the target gets a synthetic result.
+//If the API has an Archive, the record is read and archived first.  Nothing
+//is deleted if the record can't be archived.
 func (t *Table) Delete(key string, target interface{}) error {
+	if t.DryRun {
+		return t.rehearseDelete(key, target)
+	}
+	if t.Archive != nil {
+		err := t.archive(key)
+		if err != nil {
+			return err
+		}
+	}
 	u := "https://%s/delete?json=true&object=%s&key=%s"
 	x := fmt.Sprintf(u, t.Host, t.Name, key)
 	resp, body, err := t.Get(x)
+	t.forget()
 	if err == nil {
 		if resp.StatusCode != 200 {
 			return errors.New(resp.Status)
@@ -104,43 +189,75 @@ func (t *Table) Describe() (f FieldList, err error) {
 //Get also adds the cookies that the API needs to prove authentication.
 //Your application would probably be better off using One or Many.
 func (a *API) Get(u string) (*http.Response, []byte, error) {
+	return a.GetContext(context.Background(), u)
+}
+
+//GetContext is Get with a context.  The context can cancel the request
+//and carries the trace that the request's span belongs to.
+func (a *API) GetContext(ctx context.Context, u string) (*http.Response, []byte, error) {
 	var body []byte
 	var resp *http.Response
 	if a.Verbose {
 		fmt.Printf("Get: %v\n", u)
 	}
-	req, err := http.NewRequest("GET", u, nil)
+	if !skipCache(ctx) {
+		if body, ok := a.cached(u); ok {
+			return cachedResponse(u), body, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err == nil {
 		// Salsa's API needs these cookies to verify authentication.
 		for _, c := range a.Cookies {
 			req.AddCookie(c)
 		}
+		done := a.Metrics.begin(req, "", 0)
+		span := startRequest(req, "")
+		req, timed := a.timing(req)
+		defer timed()
 		resp, err = a.Client.Do(req)
 		if err == nil {
 			if resp.StatusCode != 200 {
+				done(resp, 0)
 				m := fmt.Sprintf("invalid response code %v", resp.Status)
 				err = errors.New(m)
+				endRequest(span, resp, 0, err)
 				return resp, body, err
 			}
 			defer resp.Body.Close()
-			body, err = ioutil.ReadAll(resp.Body)
+			body, err = readBody(resp.Body)
 			if a.Verbose {
 				fmt.Printf("Get: %v\n", string(body))
 			}
+			if err == nil && !skipCache(ctx) {
+				a.keep(u, body)
+			}
 		}
+		done(resp, len(body))
+		endRequest(span, resp, len(body), err)
 	}
 	return resp, body, err
 }
 
 //LeftJoinRaw does a left join using Salsa's API and returns a buffer of bytes.
 func (t *Table) LeftJoinRaw(offset int32, count int, crit string) ([]byte, error) {
+	return t.leftJoinRaw(context.Background(), offset, count, crit)
+}
+
+//leftJoinRaw is LeftJoinRaw with a context.
+func (t *Table) leftJoinRaw(ctx context.Context, offset int32, count int, crit string) ([]byte, error) {
+	_, body, err := t.GetContext(ctx, t.leftJoinURL(offset, count, crit))
+	return body, err
+}
+
+//leftJoinURL returns the URL that reads a page of a join.
+func (t *Table) leftJoinURL(offset int32, count int, crit string) string {
 	p := "https://%s/api/getLeftJoin.sjs?json&object=%s&limit=%d,%d"
 	x := fmt.Sprintf(p, t.Host, t.Name, offset, count)
 	if len(crit) != 0 {
-		x = x + "&condition=" + FixCrit(crit)
+		x = x + condition(crit)
 	}
-	_, body, err := t.Get(x)
-	return body, err
+	return x
 }
 
 //LeftJoin reads two or more tables from the database.  The tables are
@@ -157,11 +274,14 @@ func (t *Table) LeftJoinRaw(offset int32, count int, crit string) ([]byte, error
 //you'd like to see.  Be sure to use the form "table.fieldName" in the
 //JSON extensions to assure that the data is retrieved correctly.
 func (t *Table) LeftJoin(offset int32, count int, crit string, target interface{}) error {
-	body, err := t.LeftJoinRaw(offset, count, crit)
-	if err == nil {
-		err = json.Unmarshal(body, &target)
-	}
-	return err
+	return t.LeftJoinContext(context.Background(), offset, count, crit, target)
+}
+
+//LeftJoinContext is LeftJoin with a context.  The context can cancel the
+//request and carries the trace that the request's span belongs to.  The
+//response is decoded as it arrives.
+func (t *Table) LeftJoinContext(ctx context.Context, offset int32, count int, crit string, target interface{}) error {
+	return t.decodeInto(ctx, t.leftJoinURL(offset, count, crit), &target)
 }
 
 //Many reads many records from a table. Reading starts at offset and retrieves
@@ -169,11 +289,14 @@ func (t *Table) LeftJoin(offset int32, count int, crit string, target interface{
 //The target is a slice of records that match the table schema. Many automatically
 //unmarshals from JSON into the target.  An empty target indicates end of data.
 func (t *Table) Many(offset int32, count int, crit string, target interface{}) error {
-	body, err := t.ManyRaw(offset, count, crit)
-	if err == nil {
-		err = json.Unmarshal(body, &target)
-	}
-	return err
+	return t.ManyContext(context.Background(), offset, count, crit, target)
+}
+
+//ManyContext is Many with a context.  The context can cancel the request
+//and carries the trace that the request's span belongs to.  The response
+//is decoded as it arrives.
+func (t *Table) ManyContext(ctx context.Context, offset int32, count int, crit string, target interface{}) error {
+	return t.decodeInto(ctx, t.manyURL(offset, count, crit), &target)
 }
 
 //ManyTagged reads many records from a table. Records share a common tag.
@@ -183,37 +306,55 @@ func (t *Table) Many(offset int32, count int, crit string, target interface{}) e
 //The target is a slice of records that match the table schema. Many automatically
 //unmarshals from JSON into the target.  An empty target indicates end of data.
 func (t *Table) ManyTagged(offset int32, count int, crit string, tag string, target interface{}) error {
-	body, err := t.ManyRawTagged(offset, count, crit, tag)
-	if err == nil {
-		err = json.Unmarshal(body, &target)
-	}
-	return err
+	return t.ManyTaggedContext(context.Background(), offset, count, crit, tag, target)
+}
+
+//ManyTaggedContext is ManyTagged with a context.  The context can cancel
+//the request and carries the trace that the request's span belongs to.
+//The response is decoded as it arrives.
+func (t *Table) ManyTaggedContext(ctx context.Context, offset int32, count int, crit string, tag string, target interface{}) error {
+	return t.decodeInto(ctx, t.taggedURL(offset, count, crit, tag), &target)
 }
 
 //ManyRawTagged reads many records from a table. Records share a common tag.
 // Reading starts at offset and retrieves count records. Salsa will never
 // return more than 500 records, however.
 func (t *Table) ManyRawTagged(offset int32, count int, crit string, tag string) ([]byte, error) {
+	_, body, err := t.Get(t.taggedURL(offset, count, crit, tag))
+	return body, err
+}
+
+//taggedURL returns the URL that reads a page of tagged records.
+func (t *Table) taggedURL(offset int32, count int, crit string, tag string) string {
 	p := "https://%s/api/getTaggedObjects.sjs?json&object=%s&tag=%s&limit=%d,%d"
 	x := fmt.Sprintf(p, t.Host, t.Name, tag, offset, count)
 	if len(crit) != 0 {
-		x = x + "&condition=" + FixCrit(crit)
+		x = x + condition(crit)
 	}
-	_, body, err := t.Get(x)
-	return body, err
+	return x
 }
 
 //ManyRaw reads many records from a table. Reading starts at offset and
 //retrieves count records.   Salsa will never return more than 500 records,
 //however.  The results are unmarshalled data in JSON format.
 func (t *Table) ManyRaw(offset int32, count int, crit string) ([]byte, error) {
+	return t.manyRaw(context.Background(), offset, count, crit)
+}
+
+//manyRaw is ManyRaw with a context.
+func (t *Table) manyRaw(ctx context.Context, offset int32, count int, crit string) ([]byte, error) {
+	_, body, err := t.GetContext(ctx, t.manyURL(offset, count, crit))
+	return body, err
+}
+
+//manyURL returns the URL that reads a page of a table.
+func (t *Table) manyURL(offset int32, count int, crit string) string {
 	p := "https://%s/api/getObjects.sjs?json&object=%s&limit=%d,%d"
 	x := fmt.Sprintf(p, t.Host, t.Name, offset, count)
 	if len(crit) != 0 {
-		x = x + "&condition=" + FixCrit(crit)
+		x = x + condition(crit)
 	}
-	_, body, err := t.Get(x)
-	return body, err
+	return x
 }
 
 //One retrieves a single record using the provided primary key.  The target
@@ -231,10 +372,15 @@ func (t *Table) One(key string, target interface{}) error {
 //OneRaw retrieves a single record using the provided primary key.
 //Returns the buffer retrieved from the URL.
 func (t *Table) OneRaw(key string) ([]byte, error) {
+	return t.oneRaw(context.Background(), key)
+}
+
+//oneRaw does the work for OneRaw.
+func (t *Table) oneRaw(ctx context.Context, key string) ([]byte, error) {
 	p := "https://%s/api/getObject.sjs?json&object=%s&key=%s"
 	x := fmt.Sprintf(p, t.Host, t.Name, key)
 
-	resp, body, err := t.Get(x)
+	resp, body, err := t.GetContext(ctx, x)
 	if err == nil {
 		if resp.StatusCode != 200 {
 			return body, errors.New(resp.Status)
@@ -260,8 +406,17 @@ func (t *Table) Save(key string, s string) ([]byte, error) {
 // "&key=" followed by zero or the primary key
 // and multiple instances of
 // "&FieldName=Value"
-// SaveBulk returns the body of the response and an error
+// SaveBulk returns the body of the response and an error.
+// In dry-run mode, nothing is sent and the body is a synthetic result.
 func (t *Table) SaveBulk(s string) ([]byte, error) {
+	return t.saveBulk(context.Background(), s)
+}
+
+//saveBulk does the work for SaveBulk.  The context can cancel the request.
+func (t *Table) saveBulk(ctx context.Context, s string) ([]byte, error) {
+	if t.DryRun {
+		return t.rehearseSave(s)
+	}
 	u := "https://%s/save"
 	x := fmt.Sprintf(u, t.Host)
 
@@ -269,7 +424,7 @@ func (t *Table) SaveBulk(s string) ([]byte, error) {
 	_, _ = w.WriteString(s)
 	b := bytes.NewReader(w.Bytes())
 	//log.Printf("SaveBulk: writing %s\n", w.String())
-	req, err := http.NewRequest("POST", x, b)
+	req, err := http.NewRequestWithContext(ctx, "POST", x, b)
 	if err != nil {
 		return nil, err
 	}
@@ -281,20 +436,28 @@ func (t *Table) SaveBulk(s string) ([]byte, error) {
 	if t.API.Verbose {
 		fmt.Printf("SaveBulk: %v%v\n", x, w.String())
 	}
+	done := t.Metrics.begin(req, t.Name, w.Len())
+	span := startRequest(req, t.Name)
+	req, timed := t.timing(req)
+	defer timed()
 	resp, err := t.Client.Do(req)
 	var body []byte
 	if err == nil {
 		defer resp.Body.Close()
 		body, err = ioutil.ReadAll(resp.Body)
 	}
+	done(resp, len(body))
+	endRequest(span, resp, len(body), err)
+	t.forget()
 
 	return body, err
 }
 
 //YAMLAuth accepts campaign manager credentials (email, password, host)
-//from a YAML file and authenticates.
-func YAMLAuth(f string) (*API, error) {
-	a := NewAPI()
+//from a YAML file and authenticates.  The options configure the HTTP
+//client.
+func YAMLAuth(f string, opts ...Option) (*API, error) {
+	a := NewAPI(opts...)
 	c, err := Credentials(f)
 	if err == nil {
 		err = a.Authenticate(c)
@@ -0,0 +1,40 @@
+package godig
+
+import "testing"
+
+func TestFixCrit(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Email=bob@example.com", "Email=bob@example.com"},
+		{"Last_Name LIKE Smi%", "Last_Name%20LIKE%20Smi%25"},
+		{"Email=bob+tag@example.com", "Email=bob%2Btag@example.com"},
+		{"Organization=AT&T #1", "Organization=AT%26T%20%231"},
+		{"Zip=12345&condition=Email IS NOT EMPTY", "Zip=12345&condition=Email%20IS%20NOT%20EMPTY"},
+		{"&include=Email&orderBy=Zip", "&include=Email&orderBy=Zip"},
+	}
+	for _, x := range tests {
+		if got := FixCrit(x.in); got != x.want {
+			t.Errorf("FixCrit(%q) = %q, want %q", x.in, got, x.want)
+		}
+	}
+}
+
+func TestCritValue(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"bob+tag@example.com", true},
+		{"AT&T", true},
+		{"R&D&conditions", true},
+		{"x&condition=y", false},
+		{"x&orderBy=y", false},
+	}
+	for _, x := range tests {
+		err := critValue(x.in)
+		if (err == nil) != x.ok {
+			t.Errorf("critValue(%q) = %v, want ok %v", x.in, err, x.ok)
+		}
+	}
+}
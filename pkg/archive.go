@@ -0,0 +1,259 @@
+package godig
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+//ArchivedRecord is a single line in an archive.  Record is the record
+//exactly as Salsa returned it before the delete.
+type ArchivedRecord struct {
+	Object  string          `json:"object"`
+	Key     string          `json:"key"`
+	Deleted string          `json:"deleted"`
+	Record  json.RawMessage `json:"record"`
+}
+
+//Archive is a JSON Lines file of records that were saved just before
+//they were deleted.  Set API.Archive to back up every record that Delete
+//removes.  It's safe to use from many goroutines.
+type Archive struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+//OpenArchive opens an archive file for appending.  The file is created
+//if it does not exist.
+func OpenArchive(p string) (*Archive, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{f: f}, nil
+}
+
+//Append writes a record to the archive.  Each record is written
+//immediately so that a crash doesn't lose backups.
+func (a *Archive) Append(object, key string, record []byte) error {
+	r := ArchivedRecord{
+		Object:  object,
+		Key:     key,
+		Deleted: time.Now().Format(time.RFC3339),
+		Record:  json.RawMessage(strings.TrimSpace(string(record))),
+	}
+	if len(r.Record) == 0 || !json.Valid(r.Record) {
+		return fmt.Errorf("archive %s key %s: not a valid record, '%s'", object, key, string(record))
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(b)
+	return err
+}
+
+//Close closes the archive file.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+//archive reads a record and appends it to the API's archive.  Delete
+//calls this before whacking the record.  The record is read from Salsa,
+//not the cache.
+func (t *Table) archive(key string) error {
+	body, err := t.oneRaw(uncached(context.Background()), key)
+	if err != nil {
+		return fmt.Errorf("archive %s key %s: %v", t.Name, key, err)
+	}
+	return t.Archive.Append(t.Name, key, body)
+}
+
+//ReadArchive reads all of the records in an archive.
+func ReadArchive(r io.Reader) ([]ArchivedRecord, error) {
+	var a []ArchivedRecord
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		b := strings.TrimSpace(s.Text())
+		if len(b) == 0 {
+			continue
+		}
+		var x ArchivedRecord
+		err := json.Unmarshal([]byte(b), &x)
+		if err != nil {
+			return a, fmt.Errorf("line %d: %v", line, err)
+		}
+		a = append(a, x)
+	}
+	return a, s.Err()
+}
+
+//RestoreResult is the outcome of restoring one archived record.
+type RestoreResult struct {
+	Object   string
+	OldKey   string
+	NewKey   string
+	Result   string
+	Messages []string
+}
+
+//Restorer re-inserts archived records using /save.  Parents are
+//restored before their children, so a child that points at a parent that
+//got a new key is changed to point at the new key.
+type Restorer struct {
+	API *API
+	//PreserveKeys asks Salsa to save each record using its original key.
+	//Salsa may assign a new key anyway.  When false, every record is
+	//inserted with a new key.
+	PreserveKeys bool
+	//Progress, if set, reports the records restored.
+	Progress *Progress
+	//Dependents are the foreign keys that point at restored parents.
+	//The default is the package's Dependents.
+	Dependents map[string][]Dependent
+
+	//keys maps each table's archived keys to the keys they were restored
+	//with.
+	keys map[string]map[string]string
+}
+
+//restoreSkip contains fields that Salsa maintains.  They're not restored.
+var restoreSkip = map[string]bool{
+	"object":        true,
+	"key":           true,
+	"Last_Modified": true,
+}
+
+//Restore saves the archived records back into Salsa.  Records are restored
+//in the reverse of the order they were deleted.  That puts parent records
+//(like supporters) back before the records that refer to them.  The
+//callback, if any, sees every result.
+func (r *Restorer) Restore(ctx context.Context, records []ArchivedRecord, fn func(RestoreResult)) (ok int, failed int, err error) {
+	r.Progress.Start("restore", len(records), 0)
+	defer r.Progress.Finish()
+	for i := len(records) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return ok, failed, ctx.Err()
+		}
+		x := records[i]
+		rr := r.restore(ctx, x)
+		if rr.Result == "success" {
+			ok++
+		} else {
+			failed++
+		}
+		if fn != nil {
+			fn(rr)
+		}
+		r.Progress.Add(1)
+	}
+	return ok, failed, nil
+}
+
+//restore saves a single archived record.
+func (r *Restorer) restore(ctx context.Context, x ArchivedRecord) RestoreResult {
+	rr := RestoreResult{Object: x.Object, OldKey: x.Key}
+	m := make(map[string]string)
+	rec := recordFromGJson(gjson.ParseBytes(x.Record))
+	for _, k := range rec.Names() {
+		if restoreSkip[k] || strings.HasPrefix(k, "READONLY_") {
+			continue
+		}
+		m[k] = rec.Get(k)
+	}
+	notes := r.rekey(x.Object, m)
+	key := "0"
+	if r.PreserveKeys {
+		key = x.Key
+	}
+	t := r.API.NewTable(x.Object)
+	k, sr, err := t.SaveRecord(ctx, key, m)
+	rr.NewKey = k
+	rr.Result = sr.Result
+	rr.Messages = sr.Messages
+	if err != nil {
+		rr.Result = "error"
+		if sr.Result != "error" {
+			rr.Messages = append(rr.Messages, err.Error())
+		}
+	}
+	if rr.Result == "success" && len(k) != 0 {
+		if r.keys == nil {
+			r.keys = make(map[string]map[string]string)
+		}
+		if r.keys[x.Object] == nil {
+			r.keys[x.Object] = make(map[string]string)
+		}
+		r.keys[x.Object][x.Key] = k
+	}
+	if r.PreserveKeys && rr.Result == "success" && k != x.Key {
+		rr.Messages = append(rr.Messages, fmt.Sprintf("Salsa assigned a new key, was %s", x.Key))
+	}
+	rr.Messages = append(rr.Messages, notes...)
+	return rr
+}
+
+//newKey returns the key that a table's record was restored with.  Keys
+//of records that weren't restored don't change.
+func (r *Restorer) newKey(table, key string) string {
+	if k, ok := r.keys[table][key]; ok {
+		return k
+	}
+	return key
+}
+
+//rekey changes the fields in a record that point at restored parents to
+//the parents' new keys.  The fields are the parent's primary key, like
+//supporter_KEY, and the foreign keys in Dependents.  Returns a note for
+//each change.
+func (r *Restorer) rekey(object string, m map[string]string) []string {
+	var parents []string
+	for p := range r.keys {
+		parents = append(parents, p)
+	}
+	sort.Strings(parents)
+	deps := r.Dependents
+	if deps == nil {
+		deps = Dependents
+	}
+	var notes []string
+	for _, p := range parents {
+		fields := []string{p + "_KEY"}
+		for _, d := range deps[p] {
+			if d.Table != object || d.ForeignKey == fields[0] {
+				continue
+			}
+			if len(d.DatabaseTableKey) == 0 || m["database_table_KEY"] == d.DatabaseTableKey {
+				fields = append(fields, d.ForeignKey)
+			}
+		}
+		for _, f := range fields {
+			old, ok := m[f]
+			if !ok || f == object+"_KEY" {
+				continue
+			}
+			if k := r.newKey(p, old); k != old {
+				m[f] = k
+				notes = append(notes, fmt.Sprintf("%s changed from %s to %s", f, old, k))
+			}
+		}
+	}
+	return notes
+}
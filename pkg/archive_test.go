@@ -0,0 +1,108 @@
+package godig
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//archived returns an archived record.  The fields are pairs of names and
+//values.
+func archived(object, key string, fields ...string) ArchivedRecord {
+	m := map[string]string{object + "_KEY": key}
+	for i := 0; i+1 < len(fields); i += 2 {
+		m[fields[i]] = fields[i+1]
+	}
+	b, _ := json.Marshal(m)
+	return ArchivedRecord{Object: object, Key: key, Record: b}
+}
+
+func TestRestoreNewKeys(t *testing.T) {
+	// Children are deleted before their parents, so they're first.
+	records := []ArchivedRecord{
+		archived("tag_data", "30", "table_KEY", "20", "database_table_KEY", "45", "tag_KEY", "5"),
+		archived("donation", "20", "supporter_KEY", "10", "amount", "25.00"),
+		archived("tag_data", "31", "table_KEY", "10", "database_table_KEY", "142", "tag_KEY", "5"),
+		archived("supporter_groups", "32", "supporter_KEY", "10", "groups_KEY", "7"),
+		archived("supporter", "10", "Email", "bob@example.com"),
+	}
+	tests := []struct {
+		name     string
+		preserve bool
+		newKeys  bool
+	}{
+		{"new keys", false, false},
+		{"preserve keys, Salsa assigns new ones", true, true},
+	}
+	for _, x := range tests {
+		f, a := newFakeSalsa(t)
+		f.newKeys = x.newKeys
+		r := Restorer{API: a, PreserveKeys: x.preserve}
+		keys := make(map[string]string)
+		ok, failed, err := r.Restore(context.Background(), records, func(rr RestoreResult) {
+			keys[rr.Object+" "+rr.OldKey] = rr.NewKey
+		})
+		if err != nil || ok != len(records) || failed != 0 {
+			t.Fatalf("%s: %d ok, %d failed, %v", x.name, ok, failed, err)
+		}
+		supporter, donation := keys["supporter 10"], keys["donation 20"]
+		if supporter == "10" || donation == "20" {
+			t.Fatalf("%s: keys didn't change, %v", x.name, keys)
+		}
+		checks := []struct {
+			table, key, field, want string
+		}{
+			{"donation", donation, "supporter_KEY", supporter},
+			{"tag_data", keys["tag_data 31"], "table_KEY", supporter},
+			{"tag_data", keys["tag_data 30"], "table_KEY", donation},
+			{"supporter_groups", keys["supporter_groups 32"], "supporter_KEY", supporter},
+			{"supporter_groups", keys["supporter_groups 32"], "groups_KEY", "7"},
+		}
+		for _, c := range checks {
+			got := f.get(c.table, c.key)[c.field]
+			if got != c.want {
+				t.Errorf("%s: %s %s %s is %s, want %s", x.name, c.table, c.key, c.field, got, c.want)
+			}
+		}
+	}
+}
+
+func TestArchiveSkipsCache(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	a.Cache = NewCache("")
+	a.Cache.TTLs["supporter"] = time.Hour
+	p := filepath.Join(t.TempDir(), "archive.jsonl")
+	var err error
+	a.Archive, err = OpenArchive(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put("supporter", "10", "Email", "old@example.com")
+	tb := a.Supporter()
+	if _, err := tb.OneRecord("10"); err != nil {
+		t.Fatal(err)
+	}
+	f.put("supporter", "10", "Email", "new@example.com")
+	var ds DeleteStatus
+	if err := tb.Delete("10", &ds); err != nil {
+		t.Fatal(err)
+	}
+	a.Archive.Close()
+	r, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	records, err := ReadArchive(r)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("%d records, %v", len(records), err)
+	}
+	var m map[string]string
+	json.Unmarshal(records[0].Record, &m)
+	if m["Email"] != "new@example.com" {
+		t.Errorf("archived %s, want new@example.com", m["Email"])
+	}
+}
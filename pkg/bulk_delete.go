@@ -0,0 +1,322 @@
+package godig
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//DeleteSet is a group of records in one table to delete.  Records are
+//either listed in Keys or selected by Criteria.  A set with no keys and
+//no criteria is empty unless All is set.
+type DeleteSet struct {
+	Table *Table
+	//Keys are the primary keys of the records to delete.
+	Keys []string
+	//Criteria selects the records to delete.  Ignored if Keys is set.
+	Criteria string
+	//All selects every record in the table when there's no criteria.
+	All bool
+}
+
+//byKeys returns true if the set's records are listed by key.
+func (s *DeleteSet) byKeys() bool {
+	return s.Keys != nil || len(s.Criteria) == 0 && !s.All
+}
+
+//DeleteResult is the outcome of deleting one record.
+type DeleteResult struct {
+	Table    string
+	Key      string
+	Result   string
+	Messages []string
+}
+
+//DeleteTotals counts delete results for one table.
+type DeleteTotals struct {
+	Table   string
+	Deleted int
+	Failed  int
+}
+
+//BulkDelete safely deletes many records.  Run counts the records first,
+//refuses to delete more than Max, asks for confirmation, deletes using a
+//pool of workers and writes an audit file of every result.
+//
+//Sets are deleted in order.  Put child records (like donations) before
+//the parent records (like supporters) that they refer to.
+type BulkDelete struct {
+	Sets []DeleteSet
+	//Workers is the number of concurrent deleters.
+	Workers int
+	//Max is the largest number of records that can be deleted.  Zero
+	//means no limit.
+	Max int
+	//Yes skips the confirmation prompt.
+	Yes bool
+	//AuditPath is the CSV file that receives every delete result and
+	//the totals.  No audit file is written if it's empty.
+	AuditPath string
+	//In and Out are used for the confirmation prompt.  They default to
+	//stdin and stdout.
+	In  io.Reader
+	Out io.Writer
+	//Progress, if set, reports on collecting keys and deleting records.
+	Progress *Progress
+}
+
+//auditHeaders are the columns in a bulk delete audit file.
+var auditHeaders = []string{"table", "key", "result", "messages"}
+
+//Preview returns the number of records in each set.  Sets selected by
+//criteria are counted with Count.
+func (b *BulkDelete) Preview() ([]int, error) {
+	var a []int
+	for _, s := range b.Sets {
+		if s.byKeys() {
+			a = append(a, len(uniqueKeys(s.Keys)))
+			continue
+		}
+		n, err := s.Table.CountRecords(s.Criteria)
+		if err != nil {
+			return a, err
+		}
+		a = append(a, n)
+	}
+	return a, nil
+}
+
+//Run previews, confirms, deletes and audits.  Returns the totals for
+//each set.  Failed deletes are counted and audited; they don't stop
+//the run.
+func (b *BulkDelete) Run(ctx context.Context) ([]DeleteTotals, error) {
+	if b.Workers < 1 {
+		b.Workers = 1
+	}
+	if b.In == nil {
+		b.In = os.Stdin
+	}
+	if b.Out == nil {
+		b.Out = os.Stdout
+	}
+	counts, err := b.Preview()
+	if err != nil {
+		return nil, err
+	}
+	err = b.checkMax(counts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range b.Sets {
+		err = b.collect(ctx, &b.Sets[i])
+		if err != nil {
+			return nil, err
+		}
+		counts[i] = len(b.Sets[i].Keys)
+	}
+	err = b.checkMax(counts)
+	if err != nil {
+		return nil, err
+	}
+	err = b.confirm(counts)
+	if err != nil {
+		return nil, err
+	}
+
+	var w *csv.Writer
+	if len(b.AuditPath) != 0 {
+		f, err := os.Create(b.AuditPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		w = csv.NewWriter(f)
+		defer w.Flush()
+		w.Write(auditHeaders)
+	}
+
+	var totals []DeleteTotals
+	for _, s := range b.Sets {
+		t := DeleteTotals{Table: s.Table.Name}
+		err := b.DeleteKeys(ctx, s.Table, s.Keys, func(r DeleteResult) {
+			if r.Result == "success" {
+				t.Deleted++
+			} else {
+				t.Failed++
+				log.Printf("BulkDelete: %s key %s, %s %v\n", r.Table, r.Key, r.Result, r.Messages)
+			}
+			if w != nil {
+				w.Write([]string{r.Table, r.Key, r.Result, strings.Join(r.Messages, "; ")})
+			}
+		})
+		totals = append(totals, t)
+		log.Printf("BulkDelete: %s, %d deleted, %d failed\n", t.Table, t.Deleted, t.Failed)
+		if err != nil {
+			return totals, err
+		}
+	}
+	if w != nil {
+		for _, t := range totals {
+			w.Write([]string{t.Table, "TOTAL", "success", strconv.Itoa(t.Deleted)})
+			w.Write([]string{t.Table, "TOTAL", "error", strconv.Itoa(t.Failed)})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	return totals, err
+}
+
+//DeleteKeys deletes records using a pool of workers.  The callback sees
+//each result.  It's called from one goroutine at a time.
+func (b *BulkDelete) DeleteKeys(ctx context.Context, t *Table, keys []string, fn func(DeleteResult)) error {
+	ctx, span := Stage(ctx, "delete "+t.Name,
+		attribute.String("salsa.table", t.Name),
+		attribute.Int("keys", len(keys)))
+	err := b.deleteKeys(ctx, t, keys, fn)
+	EndStage(span, err)
+	return err
+}
+
+//deleteKeys does the work for DeleteKeys.
+func (b *BulkDelete) deleteKeys(ctx context.Context, t *Table, keys []string, fn func(DeleteResult)) error {
+	workers := b.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	in := make(chan string, 100)
+	out := make(chan DeleteResult, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range in {
+				out <- deleteOne(t, k)
+			}
+		}()
+	}
+	go func() {
+		defer close(in)
+		for _, k := range keys {
+			select {
+			case in <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	b.Progress.Start("delete "+t.Name, len(keys), 0)
+	defer b.Progress.Finish()
+	for r := range out {
+		fn(r)
+		b.Progress.Add(1)
+	}
+	return ctx.Err()
+}
+
+//deleteOne deletes a record and returns the result.  Errors from the
+//request are returned as results.
+func deleteOne(t *Table, key string) DeleteResult {
+	r := DeleteResult{Table: t.Name, Key: key}
+	var ds DeleteStatus
+	err := t.Delete(key, &ds)
+	r.Result = ds.Result
+	r.Messages = ds.Messages
+	if err != nil {
+		r.Result = "error"
+		r.Messages = append(r.Messages, err.Error())
+	}
+	if len(r.Result) == 0 {
+		r.Result = "error"
+		r.Messages = append(r.Messages, "no result from Salsa")
+	}
+	return r
+}
+
+//collect reads the keys for a set that's selected by criteria.
+func (b *BulkDelete) collect(ctx context.Context, s *DeleteSet) error {
+	if s.byKeys() {
+		s.Keys = uniqueKeys(s.Keys)
+		return nil
+	}
+	pk := s.Table.Name + "_KEY"
+	s.Keys = []string{}
+	err := s.Table.Scan(ctx, Query{Criteria: s.Criteria, Progress: b.Progress}, func(page []*Record) error {
+		for _, r := range page {
+			if k := r.Get(pk); len(k) != 0 {
+				s.Keys = append(s.Keys, k)
+			}
+		}
+		if b.Max > 0 && len(s.Keys) > b.Max {
+			return fmt.Errorf("%s has more than %d matching records", s.Table.Name, b.Max)
+		}
+		return nil
+	})
+	s.Keys = uniqueKeys(s.Keys)
+	return err
+}
+
+//checkMax returns an error if the total is more than Max.
+func (b *BulkDelete) checkMax(counts []int) error {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if b.Max > 0 && total > b.Max {
+		m := fmt.Sprintf("refusing to delete %d records, the maximum is %d", total, b.Max)
+		return errors.New(m)
+	}
+	return nil
+}
+
+//confirm shows what will be deleted and asks the user to type a phrase
+//to continue.
+func (b *BulkDelete) confirm(counts []int) error {
+	total := 0
+	for i, s := range b.Sets {
+		fmt.Fprintf(b.Out, "%8d %s records will be deleted\n", counts[i], s.Table.Name)
+		total += counts[i]
+	}
+	if total == 0 || b.Yes {
+		return nil
+	}
+	phrase := fmt.Sprintf("DELETE %d", total)
+	fmt.Fprintf(b.Out, "Type '%s' to continue: ", phrase)
+	s, err := bufio.NewReader(b.In).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(s) != phrase {
+		return errors.New("delete not confirmed")
+	}
+	return nil
+}
+
+//uniqueKeys removes empty and duplicate keys, keeping the original order.
+func uniqueKeys(a []string) []string {
+	seen := make(map[string]bool)
+	var b []string
+	for _, k := range a {
+		k = strings.TrimSpace(k)
+		if len(k) == 0 || seen[k] {
+			continue
+		}
+		seen[k] = true
+		b = append(b, k)
+	}
+	return b
+}
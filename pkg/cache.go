@@ -0,0 +1,325 @@
+package godig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//DescribeTTL is how long Describe results are kept by default.  Table
+//structures hardly ever change.
+const DescribeTTL = 24 * time.Hour
+
+//DefaultCacheTTLs are how long responses are kept for tables that change
+//slowly.  Lookups in these tables are usually for names, like a chapter's
+//name or a blast's subject.
+var DefaultCacheTTLs = map[string]time.Duration{
+	"chapter":      time.Hour,
+	"custom_field": time.Hour,
+	"donate_page":  time.Hour,
+	"email_blast":  time.Hour,
+	"groups":       time.Hour,
+	"organization": time.Hour,
+	"tag":          time.Hour,
+}
+
+//cacheable are the endpoints that read.  Responses from anything else,
+//like save and delete, are never cached.
+var cacheable = map[string]bool{
+	"describe2.sjs":        true,
+	"getCount.sjs":         true,
+	"getLeftJoin.sjs":      true,
+	"getObject.sjs":        true,
+	"getObjects.sjs":       true,
+	"getTaggedObjects.sjs": true,
+}
+
+//Cache keeps responses from Salsa in memory and, if Dir is set, on disk.
+//Set API.Cache to use it.  A nil Cache keeps nothing.
+//
+//Responses are keyed by the login, the endpoint and the parameters.  How
+//long a response is kept depends on the table.  Tables without a TTL are
+//not cached.  Saving or deleting a record forgets everything cached for
+//its table.
+type Cache struct {
+	//Dir holds the cached responses.  Responses are only kept in memory
+	//if Dir is empty.
+	Dir string
+	//TTLs are how long responses are kept for each table.
+	TTLs map[string]time.Duration
+	//DescribeTTL is how long Describe results are kept for any table.
+	DescribeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+//cacheEntry is a cached response.  It's also the format of the files in
+//Dir.
+type cacheEntry struct {
+	URL     string    `json:"url"`
+	Group   string    `json:"group"`
+	Expires time.Time `json:"expires"`
+	Body    []byte    `json:"body"`
+}
+
+//NewCache returns a cache that uses the default TTLs and keeps responses
+//in dir.  Use an empty dir to only keep them in memory.
+func NewCache(dir string) *Cache {
+	c := Cache{
+		Dir:         dir,
+		TTLs:        make(map[string]time.Duration),
+		DescribeTTL: DescribeTTL,
+		entries:     make(map[string]cacheEntry),
+	}
+	for k, v := range DefaultCacheTTLs {
+		c.TTLs[k] = v
+	}
+	return &c
+}
+
+//group returns the name that a URL's response is cached under and how
+//long it's kept.  Descriptions are grouped together.  Everything else is
+//grouped by table.  A zero TTL means don't cache.
+func (c *Cache) group(u string) (string, time.Duration) {
+	x, err := url.Parse(u)
+	if err != nil {
+		return "", 0
+	}
+	e, t := endpoint(x)
+	if !cacheable[e] {
+		return "", 0
+	}
+	if e == "describe2.sjs" {
+		return "describe", c.DescribeTTL
+	}
+	return t, c.TTLs[t]
+}
+
+//key returns the key for a URL read with a login.
+func (c *Cache) key(login, u string) string {
+	h := sha256.Sum256([]byte(login + "\n" + u))
+	return hex.EncodeToString(h[:])
+}
+
+//path returns the file that an entry is kept in.
+func (c *Cache) path(group, key string) string {
+	return filepath.Join(c.Dir, url.PathEscape(group), key+".json")
+}
+
+//Get returns the cached response for a URL read with a login.  The
+//boolean is false if there isn't one, or if it has expired.
+func (c *Cache) Get(login, u string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	g, ttl := c.group(u)
+	if ttl <= 0 {
+		return nil, false
+	}
+	k := c.key(login, u)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	x, ok := c.entries[k]
+	if !ok && len(c.Dir) != 0 {
+		x, ok = c.load(g, k)
+	}
+	if !ok || x.URL != u {
+		return nil, false
+	}
+	if time.Now().After(x.Expires) {
+		c.remove(g, k)
+		return nil, false
+	}
+	c.entries[k] = x
+	return x.Body, true
+}
+
+//Put keeps the response for a URL read with a login.  Responses for
+//tables without a TTL are ignored.
+func (c *Cache) Put(login, u string, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	g, ttl := c.group(u)
+	if ttl <= 0 {
+		return nil
+	}
+	k := c.key(login, u)
+	x := cacheEntry{
+		URL:     u,
+		Group:   g,
+		Expires: time.Now().Add(ttl),
+		Body:    body,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[k] = x
+	if len(c.Dir) == 0 {
+		return nil
+	}
+	return c.store(g, k, x)
+}
+
+//Forget removes everything cached for a table.  Describe results are
+//kept.
+func (c *Cache) Forget(table string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, x := range c.entries {
+		if x.Group == table {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.Dir) == 0 {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(c.Dir, url.PathEscape(table)))
+}
+
+//Clear removes everything in the cache.
+func (c *Cache) Clear() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	if len(c.Dir) == 0 {
+		return nil
+	}
+	return os.RemoveAll(c.Dir)
+}
+
+//load reads an entry from disk.
+func (c *Cache) load(group, key string) (cacheEntry, bool) {
+	var x cacheEntry
+	b, err := ioutil.ReadFile(c.path(group, key))
+	if err != nil {
+		return x, false
+	}
+	err = json.Unmarshal(b, &x)
+	return x, err == nil
+}
+
+//store writes an entry to disk.  The file is replaced in one step so
+//that another program using the same cache never reads half of it.
+func (c *Cache) store(group, key string, x cacheEntry) error {
+	p := c.path(group, key)
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), key+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+//remove forgets an entry that has expired.
+func (c *Cache) remove(group, key string) {
+	delete(c.entries, key)
+	if len(c.Dir) != 0 {
+		os.Remove(c.path(group, key))
+	}
+}
+
+//uncachedKey marks a context whose reads skip the cache.
+type uncachedKey struct{}
+
+//uncached returns a context whose reads always go to Salsa.  Reads that
+//decide what to change, or that back up a record before it's deleted,
+//can't use a stale response.  Their responses aren't cached either.
+func uncached(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uncachedKey{}, true)
+}
+
+//skipCache returns true if reads with a context skip the cache.
+func skipCache(ctx context.Context) bool {
+	x, _ := ctx.Value(uncachedKey{}).(bool)
+	return x
+}
+
+//cached returns the cached response for a URL.
+func (a *API) cached(u string) ([]byte, bool) {
+	if a.Cache == nil {
+		return nil, false
+	}
+	g, ttl := a.Cache.group(u)
+	if ttl <= 0 {
+		return nil, false
+	}
+	body, ok := a.Cache.Get(a.CredData.Email, u)
+	a.Metrics.Cached(g, ok)
+	if ok && a.Verbose {
+		fmt.Printf("Cache: %v\n", string(body))
+	}
+	return body, ok
+}
+
+//caching returns true if the response for a URL would be cached.
+func (a *API) caching(u string) bool {
+	if a.Cache == nil {
+		return false
+	}
+	_, ttl := a.Cache.group(u)
+	return ttl > 0
+}
+
+//keep caches the response for a URL.  A cache that can't be written
+//is logged.  The response is still good.
+func (a *API) keep(u string, body []byte) {
+	err := a.Cache.Put(a.CredData.Email, u, body)
+	if err != nil {
+		log.Printf("Cache: %v\n", err)
+	}
+}
+
+//cachedResponse is the response returned with a cached body.
+func cachedResponse(u string) *http.Response {
+	x, _ := url.Parse(u)
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    &http.Request{Method: "GET", URL: x},
+	}
+}
+
+//forget removes the cached responses for a table after a change.
+func (t *Table) forget() {
+	err := t.Cache.Forget(t.Name)
+	if err != nil {
+		log.Printf("Cache: %v\n", err)
+	}
+}
@@ -0,0 +1,372 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//Dependent describes a table whose records point to records in a parent
+//table.
+type Dependent struct {
+	//Table is the dependent table.
+	Table string
+	//ForeignKey is the field in Table that holds the parent's key.
+	ForeignKey string
+	//DatabaseTableKey is used for tables like tag_data that point into
+	//many tables.  Those records use table_KEY for the parent key and
+	//database_table_KEY to identify the parent table.
+	DatabaseTableKey string
+	//Activity marks records that are history, like donations, event
+	//signups and actions.  Parents that have activity outside of the
+	//selected records are conflicts.
+	Activity bool
+	//Unique is the field that a parent can only have once, like the
+	//group in supporter_groups.  Merges delete these records instead of
+	//moving them when the survivor already has one.
+	Unique string
+}
+
+//Salsa lists its tables in database_table.  Records in tables like
+//tag_data point into other tables with the parent table's
+//database_table_KEY.  These are the keys for the tables that Dependents
+//uses.
+const (
+	//SupporterTableKey is supporter's database_table_KEY.
+	SupporterTableKey = "142"
+	//DonationTableKey is donation's database_table_KEY.
+	DonationTableKey = "45"
+)
+
+//Dependents lists the known foreign keys for each parent table.
+var Dependents = map[string][]Dependent{
+	"supporter": {
+		{Table: "donation", ForeignKey: "supporter_KEY", Activity: true},
+		{Table: "recurring_donation", ForeignKey: "supporter_KEY", Activity: true},
+		{Table: "supporter_groups", ForeignKey: "supporter_KEY", Unique: "groups_KEY"},
+		{Table: "tag_data", ForeignKey: "table_KEY", DatabaseTableKey: SupporterTableKey, Unique: "tag_KEY"},
+		{Table: "email", ForeignKey: "supporter_KEY"},
+		{Table: "supporter_event", ForeignKey: "supporter_KEY", Activity: true, Unique: "event_KEY"},
+		{Table: "supporter_action", ForeignKey: "supporter_KEY", Activity: true},
+	},
+	"donation": {
+		{Table: "tag_data", ForeignKey: "table_KEY", DatabaseTableKey: DonationTableKey, Unique: "tag_KEY"},
+	},
+}
+
+//inChunk is the default number of keys in each "IN" condition.  Finder
+//makes smaller chunks when the URL would be too long.
+const inChunk = 100
+
+//CascadeNode is a record in a cascade plan.  Children are the records
+//that depend on it.
+type CascadeNode struct {
+	Table    string
+	Key      string
+	Children []*CascadeNode
+	selected bool
+}
+
+//Conflict is a parent record that can't be deleted because it has
+//activity outside of the selected records.
+type Conflict struct {
+	Table      string
+	Key        string
+	ChildTable string
+	ChildKey   string
+}
+
+//String implements Stringer.
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s has %s %s, which was not selected", c.Table, c.Key, c.ChildTable, c.ChildKey)
+}
+
+//CascadePlan is the full impact of deleting a set of records.  Roots are
+//the records that were asked for.  Their descendants are the records that
+//depend on them.
+type CascadePlan struct {
+	Roots     []*CascadeNode
+	Conflicts []Conflict
+}
+
+//CascadePlanner finds the records that depend on records to be deleted.
+type CascadePlanner struct {
+	API *API
+	//Dependents defaults to the package's Dependents.
+	Dependents map[string][]Dependent
+
+	nodes    map[string]*CascadeNode
+	selected map[string]map[string]bool
+}
+
+//nodeID returns the identity of a record in a plan.
+func nodeID(table, key string) string {
+	return table + ":" + key
+}
+
+//Plan finds every record that depends on the root records.  Selected lists
+//other records, by table, that are part of the same delete.  For example,
+//when deleting donations and the supporters that made them, the supporters
+//are the roots and the donations are selected.  Selected records that don't
+//depend on a root are added as roots.
+//
+//Activity records that are not selected become conflicts.  The plan still
+//contains the parents.  Use Prune to remove them.
+func (p *CascadePlanner) Plan(ctx context.Context, table string, keys []string, selected map[string][]string) (*CascadePlan, error) {
+	if p.Dependents == nil {
+		p.Dependents = Dependents
+	}
+	p.nodes = make(map[string]*CascadeNode)
+	p.selected = make(map[string]map[string]bool)
+	for t, a := range selected {
+		p.selected[t] = make(map[string]bool)
+		for _, k := range a {
+			p.selected[t][k] = true
+		}
+	}
+	plan := &CascadePlan{}
+	level := p.addRoots(plan, table, keys)
+	for {
+		for len(level) != 0 {
+			next, err := p.expand(ctx, plan, level)
+			if err != nil {
+				return plan, err
+			}
+			level = next
+		}
+		// Selected records that didn't show up under a root are roots too.
+		var tables []string
+		for t := range selected {
+			tables = append(tables, t)
+		}
+		sort.Strings(tables)
+		for _, t := range tables {
+			var extra []string
+			for _, k := range selected[t] {
+				if p.nodes[nodeID(t, k)] == nil {
+					extra = append(extra, k)
+				}
+			}
+			level = append(level, p.addRoots(plan, t, extra)...)
+		}
+		if len(level) == 0 {
+			return plan, nil
+		}
+	}
+}
+
+//addRoots adds root records to the plan and returns the new nodes.
+func (p *CascadePlanner) addRoots(plan *CascadePlan, table string, keys []string) []*CascadeNode {
+	var a []*CascadeNode
+	for _, k := range uniqueKeys(keys) {
+		id := nodeID(table, k)
+		if p.nodes[id] != nil {
+			continue
+		}
+		n := &CascadeNode{Table: table, Key: k, selected: true}
+		p.nodes[id] = n
+		plan.Roots = append(plan.Roots, n)
+		a = append(a, n)
+	}
+	return a
+}
+
+//expand finds the children of a level of nodes.  Returns the next level.
+func (p *CascadePlanner) expand(ctx context.Context, plan *CascadePlan, level []*CascadeNode) ([]*CascadeNode, error) {
+	byTable := make(map[string][]*CascadeNode)
+	var tables []string
+	for _, n := range level {
+		if byTable[n.Table] == nil {
+			tables = append(tables, n.Table)
+		}
+		byTable[n.Table] = append(byTable[n.Table], n)
+	}
+	sort.Strings(tables)
+	var next []*CascadeNode
+	for _, table := range tables {
+		parents := make(map[string]*CascadeNode)
+		var keys []string
+		for _, n := range byTable[table] {
+			parents[n.Key] = n
+			keys = append(keys, n.Key)
+		}
+		for _, d := range p.Dependents[table] {
+			err := p.children(ctx, d, keys, func(parentKey, childKey string) {
+				parent := parents[parentKey]
+				if parent == nil {
+					return
+				}
+				id := nodeID(d.Table, childKey)
+				if p.nodes[id] != nil {
+					return
+				}
+				sel := p.selected[d.Table][childKey]
+				if d.Activity && !sel {
+					plan.Conflicts = append(plan.Conflicts, Conflict{
+						Table:      parent.Table,
+						Key:        parent.Key,
+						ChildTable: d.Table,
+						ChildKey:   childKey,
+					})
+					return
+				}
+				c := &CascadeNode{Table: d.Table, Key: childKey, selected: sel}
+				p.nodes[id] = c
+				parent.Children = append(parent.Children, c)
+				next = append(next, c)
+			})
+			if err != nil {
+				return next, err
+			}
+		}
+	}
+	return next, nil
+}
+
+//children reads the dependent records for a list of parent keys.  The
+//function sees the parent key and child key for each dependent record.
+func (p *CascadePlanner) children(ctx context.Context, d Dependent, keys []string, fn func(parentKey, childKey string)) error {
+	t := p.API.NewTable(d.Table)
+	pk := d.Table + "_KEY"
+	f := Finder{Table: &t, Fields: []string{d.ForeignKey}, Include: []string{pk}}
+	if len(d.DatabaseTableKey) != 0 {
+		f.Criteria = "database_table_KEY=" + d.DatabaseTableKey
+	}
+	for _, q := range f.Queries(keys) {
+		err := t.Scan(ctx, q, func(page []*Record) error {
+			for _, r := range page {
+				fn(r.Get(d.ForeignKey), r.Get(pk))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", d.Table, err)
+		}
+	}
+	return nil
+}
+
+//Prune removes conflicting parents from the plan.  Their descendants are
+//not deleted, except for selected records.  Those become roots.
+func (plan *CascadePlan) Prune() {
+	bad := make(map[string]bool)
+	for _, c := range plan.Conflicts {
+		bad[nodeID(c.Table, c.Key)] = true
+	}
+	var roots []*CascadeNode
+	var keep func(n *CascadeNode)
+	keep = func(n *CascadeNode) {
+		if !bad[nodeID(n.Table, n.Key)] {
+			roots = append(roots, n)
+			return
+		}
+		for _, c := range n.Children {
+			if c.selected {
+				keep(c)
+			}
+		}
+	}
+	for _, n := range plan.Roots {
+		keep(n)
+	}
+	plan.Roots = roots
+}
+
+//Counts returns the number of records in the plan for each table.
+func (plan *CascadePlan) Counts() map[string]int {
+	m := make(map[string]int)
+	plan.walk(func(n *CascadeNode, depth int) {
+		m[n.Table]++
+	})
+	return m
+}
+
+//walk visits every node in the plan, parents before children.
+func (plan *CascadePlan) walk(fn func(n *CascadeNode, depth int)) {
+	var visit func(n *CascadeNode, depth int)
+	visit = func(n *CascadeNode, depth int) {
+		fn(n, depth)
+		for _, c := range n.Children {
+			visit(c, depth+1)
+		}
+	}
+	for _, n := range plan.Roots {
+		visit(n, 0)
+	}
+}
+
+//DeleteSets returns the records in the plan as sets for BulkDelete.
+//Children come before parents: the deepest records are first.
+func (plan *CascadePlan) DeleteSets(api *API) []DeleteSet {
+	type group struct {
+		depth int
+		table string
+	}
+	keys := make(map[group][]string)
+	var groups []group
+	plan.walk(func(n *CascadeNode, depth int) {
+		g := group{depth, n.Table}
+		if keys[g] == nil {
+			groups = append(groups, g)
+		}
+		keys[g] = append(keys[g], n.Key)
+	})
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].depth != groups[j].depth {
+			return groups[i].depth > groups[j].depth
+		}
+		return groups[i].table < groups[j].table
+	})
+	var sets []DeleteSet
+	for _, g := range groups {
+		t := api.NewTable(g.table)
+		sets = append(sets, DeleteSet{Table: &t, Keys: keys[g]})
+	}
+	return sets
+}
+
+//Write writes the impact tree, the totals by table and the conflicts.
+func (plan *CascadePlan) Write(w io.Writer) error {
+	var err error
+	out := func(f string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, f, a...)
+		}
+	}
+	plan.walk(func(n *CascadeNode, depth int) {
+		out("%s%s %s\n", strings.Repeat("    ", depth), n.Table, n.Key)
+	})
+	if err == nil {
+		err = plan.WriteSummary(w)
+	}
+	return err
+}
+
+//WriteSummary writes the totals by table and the conflicts.
+func (plan *CascadePlan) WriteSummary(w io.Writer) error {
+	var err error
+	out := func(f string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, f, a...)
+		}
+	}
+	m := plan.Counts()
+	var tables []string
+	for t := range m {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	out("Records in the plan:\n")
+	for _, t := range tables {
+		out("%8d %s\n", m[t], t)
+	}
+	if len(plan.Conflicts) != 0 {
+		out("Conflicts:\n")
+		for _, c := range plan.Conflicts {
+			out("    %v\n", c)
+		}
+	}
+	return err
+}
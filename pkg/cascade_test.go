@@ -0,0 +1,162 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//cascadeSalsa returns a fake Salsa with two supporters, their donations
+//and tags.
+func cascadeSalsa(t *testing.T) (*fakeSalsa, *API) {
+	f, a := newFakeSalsa(t)
+	f.put("supporter", "1")
+	f.put("supporter", "2")
+	f.put("donation", "10", "supporter_KEY", "1")
+	f.put("donation", "11", "supporter_KEY", "2")
+	f.put("tag_data", "20", "table_KEY", "10", "database_table_KEY", DonationTableKey)
+	f.put("tag_data", "21", "table_KEY", "1", "database_table_KEY", SupporterTableKey)
+	// A donation's tag with the same key as a supporter.
+	f.put("tag_data", "22", "table_KEY", "2", "database_table_KEY", DonationTableKey)
+	f.put("supporter_groups", "30", "supporter_KEY", "2", "groups_KEY", "5")
+	return f, a
+}
+
+//deleteSets returns the tables and keys in delete sets.
+func deleteSets(sets []DeleteSet) []string {
+	var a []string
+	for _, s := range sets {
+		a = append(a, s.Table.Name+" "+strings.Join(s.Keys, ","))
+	}
+	return a
+}
+
+func TestCascadePlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		selected  map[string][]string
+		prune     bool
+		conflicts int
+		want      []string
+	}{
+		{
+			name:      "donations not selected",
+			conflicts: 2,
+			want:      []string{"supporter_groups 30", "tag_data 21", "supporter 1,2"},
+		},
+		{
+			name:      "pruned",
+			prune:     true,
+			conflicts: 2,
+			want:      nil,
+		},
+		{
+			name:      "one donation selected",
+			selected:  map[string][]string{"donation": {"10"}},
+			prune:     true,
+			conflicts: 1,
+			want:      []string{"tag_data 20", "donation 10", "tag_data 21", "supporter 1"},
+		},
+		{
+			name:     "both donations selected",
+			selected: map[string][]string{"donation": {"10", "11"}},
+			want:     []string{"tag_data 20", "donation 10,11", "supporter_groups 30", "tag_data 21", "supporter 1,2"},
+		},
+	}
+	for _, x := range tests {
+		_, a := cascadeSalsa(t)
+		p := CascadePlanner{API: a}
+		plan, err := p.Plan(context.Background(), "supporter", []string{"1", "2"}, x.selected)
+		if err != nil {
+			t.Fatalf("%s: %v", x.name, err)
+		}
+		if len(plan.Conflicts) != x.conflicts {
+			t.Errorf("%s: %d conflicts %v, want %d", x.name, len(plan.Conflicts), plan.Conflicts, x.conflicts)
+		}
+		if x.prune {
+			plan.Prune()
+		}
+		got := deleteSets(plan.DeleteSets(a))
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: delete sets %q, want %q", x.name, got, x.want)
+		}
+	}
+}
+
+func TestCascadeActivity(t *testing.T) {
+	tests := []struct {
+		table    string
+		selected map[string][]string
+		want     []string
+	}{
+		{"supporter_event", nil, nil},
+		{"supporter_action", nil, nil},
+		{"supporter_event", map[string][]string{"supporter_event": {"40"}}, []string{"supporter_event 40", "supporter 3"}},
+		{"supporter_action", map[string][]string{"supporter_action": {"40"}}, []string{"supporter_action 40", "supporter 3"}},
+	}
+	for _, x := range tests {
+		f, a := newFakeSalsa(t)
+		f.put("supporter", "3")
+		f.put(x.table, "40", "supporter_KEY", "3")
+		p := CascadePlanner{API: a}
+		plan, err := p.Plan(context.Background(), "supporter", []string{"3"}, x.selected)
+		if err != nil {
+			t.Fatalf("%s: %v", x.table, err)
+		}
+		conflicts := 0
+		if x.selected == nil {
+			conflicts = 1
+		}
+		if len(plan.Conflicts) != conflicts {
+			t.Errorf("%s: conflicts %v, want %d", x.table, plan.Conflicts, conflicts)
+		}
+		plan.Prune()
+		if got := deleteSets(plan.DeleteSets(a)); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: delete sets %q, want %q", x.table, got, x.want)
+		}
+	}
+}
+
+func TestCascadePrunePromotesSelected(t *testing.T) {
+	_, a := cascadeSalsa(t)
+	p := CascadePlanner{API: a}
+	// Donation 11 is selected, but supporter 2 has a group, which is fine,
+	// and supporter 1 has donation 10, which isn't selected.
+	plan, err := p.Plan(context.Background(), "supporter", []string{"1", "2"}, map[string][]string{"donation": {"11"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Prune()
+	got := deleteSets(plan.DeleteSets(a))
+	want := []string{"donation 11", "supporter_groups 30", "supporter 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delete sets %q, want %q", got, want)
+	}
+}
+
+func TestCascadeChunks(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	var keys []string
+	for i := 0; i < 500; i++ {
+		k := fmt.Sprintf("%024d", 100000+i)
+		keys = append(keys, k)
+		f.put("supporter", k)
+		f.put("email", strconv.Itoa(200000+i), "supporter_KEY", k)
+	}
+	p := CascadePlanner{API: a, Dependents: map[string][]Dependent{
+		"supporter": {{Table: "email", ForeignKey: "supporter_KEY"}},
+	}}
+	plan, err := p.Plan(context.Background(), "supporter", keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Counts()["email"]; n != 500 {
+		t.Errorf("%d emails, want 500", n)
+	}
+	if f.longest > MaxURLLength {
+		t.Errorf("longest URL is %d, more than %d", f.longest, MaxURLLength)
+	}
+}
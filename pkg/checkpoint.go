@@ -0,0 +1,123 @@
+package godig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//Checkpoint records the progress of a paginated read so that an
+//interrupted run can resume where it stopped.  Set Query.Checkpoint and
+//Scan saves the checkpoint after each page is handled.  Sinks record
+//their own position before Scan saves.
+//
+//Resuming by offset depends on Salsa returning records in the same
+//order, so use a query with an OrderBy.
+type Checkpoint struct {
+	Table string `json:"table"`
+	//CriteriaHash identifies the criteria, include and order.  A
+	//checkpoint can't be used with a different query.
+	CriteriaHash string `json:"criteria_hash"`
+	//Offset is the number of records that have been handled.
+	Offset int32 `json:"offset"`
+	//LastKey is the primary key of the last record handled.
+	LastKey string `json:"last_key,omitempty"`
+	//LastModified is the Last_Modified of the last record handled by a
+	//sync, which pages by Last_Modified and key instead of by offset.
+	LastModified string `json:"last_modified,omitempty"`
+	//Columns are the columns that a file sink is writing.
+	Columns []string `json:"columns,omitempty"`
+	//Files are the files that a file sink has written.  The last one
+	//may be incomplete.
+	Files []string `json:"files,omitempty"`
+	//FileStart is the offset of the first record in the last file.
+	FileStart int32 `json:"file_start"`
+	//FileRows is the number of records in the last file.
+	FileRows int `json:"file_rows"`
+	//Position is the size of the last file when the checkpoint was saved.
+	//-1 means that the file can't be appended to and must be rewritten.
+	Position int64  `json:"position"`
+	Updated  string `json:"updated"`
+	path     string
+}
+
+//CriteriaHash returns a short hash of a table name and query.
+func CriteriaHash(t *Table, q Query) string {
+	h := sha256.Sum256([]byte(t.Name + "\n" + q.crit()))
+	return hex.EncodeToString(h[:8])
+}
+
+//NewCheckpoint returns an empty checkpoint for a query.  It is saved to
+//the file p.
+func NewCheckpoint(p string, t *Table, q Query) *Checkpoint {
+	return &Checkpoint{Table: t.Name, CriteriaHash: CriteriaHash(t, q), path: p}
+}
+
+//LoadCheckpoint reads a checkpoint from the file p.  A missing file
+//returns an empty checkpoint.  It's an error if the checkpoint is for a
+//different table or query.
+func LoadCheckpoint(p string, t *Table, q Query) (*Checkpoint, error) {
+	c := NewCheckpoint(p, t, q)
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	var x Checkpoint
+	err = json.Unmarshal(b, &x)
+	if err != nil {
+		return c, fmt.Errorf("checkpoint %s: %v", p, err)
+	}
+	if x.Table != c.Table || x.CriteriaHash != c.CriteriaHash {
+		return c, fmt.Errorf("checkpoint %s is for a different table or criteria", p)
+	}
+	x.path = p
+	return &x, nil
+}
+
+//Resuming returns true if the checkpoint has progress to resume from.
+func (c *Checkpoint) Resuming() bool {
+	return c.Offset > 0 || len(c.Files) != 0
+}
+
+//Save writes the checkpoint.  The file is replaced in one step so that a
+//crash can't leave half of a checkpoint.
+func (c *Checkpoint) Save() error {
+	c.Updated = time.Now().Format(time.RFC3339)
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+//Remove deletes the checkpoint file.  Call it when a run finishes.
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name: "cache",
+		Help: "Cached responses.",
+	})
+	Register(Command{
+		Name:      "cache clear",
+		Help:      "Remove everything from the cache.",
+		Configure: configureCacheClear,
+		NoLogin:   true,
+	})
+}
+
+//defaultCacheDir returns ~/.godig/cache.
+func defaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".godig", "cache")
+}
+
+//cache returns the cache for the --cache-dir and --cache-ttl flags.
+//TTLs are "TABLE=DURATION".  A zero duration turns caching off for a
+//table.  "describe" sets the TTL for Describe.
+func cache(dir string, ttls map[string]string) (*godig.Cache, error) {
+	c := godig.NewCache(dir)
+	for k, v := range ttls {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("--cache-ttl %s=%s: %v", k, v, err)
+		}
+		if k == "describe" {
+			c.DescribeTTL = d
+		} else {
+			c.TTLs[k] = d
+		}
+	}
+	return c, nil
+}
+
+//configureCacheClear sets up "cache clear".
+func configureCacheClear(c *kingpin.CmdClause) Runner {
+	return func(e *Env) error {
+		if len(e.CacheDir) == 0 {
+			return nil
+		}
+		err := godig.NewCache(e.CacheDir).Clear()
+		if err == nil {
+			log.Printf("cache: removed %s\n", e.CacheDir)
+		}
+		return err
+	}
+}
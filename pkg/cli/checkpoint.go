@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"log"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+//resumeFlag adds the --resume flag for commands that checkpoint.
+func resumeFlag(c *kingpin.CmdClause) *bool {
+	return c.Flag("resume", "Continue from the checkpoint left by an interrupted run").Bool()
+}
+
+//checkpoint returns the checkpoint for a query.  Resuming reads it from
+//the file p.  Otherwise, it starts empty.
+func checkpoint(p string, resume bool, t *godig.Table, q godig.Query) (*godig.Checkpoint, error) {
+	if !resume {
+		return godig.NewCheckpoint(p, t, q), nil
+	}
+	c, err := godig.LoadCheckpoint(p, t, q)
+	if err != nil {
+		return nil, err
+	}
+	if c.Resuming() {
+		log.Printf("%s: resuming at offset %d, key %s\n", t.Name, c.Offset, c.LastKey)
+	} else {
+		log.Printf("%s: no checkpoint in %s, starting at the beginning\n", t.Name, p)
+	}
+	return c, nil
+}
@@ -0,0 +1,314 @@
+//Package cli is the command line framework behind the godig command.
+//Commands register themselves with Register.  To add commands without
+//forking godig, put them in your own package, register them from an
+//init function, then build a main that imports that package and calls
+//Main.
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+//Runner runs a command.
+type Runner func(e *Env) error
+
+//Command describes a subcommand.
+type Command struct {
+	//Name is the command's name.  Use a space for nested commands, for
+	//example "report blast-donations".
+	Name string
+	//Help is a one-line description.
+	Help string
+	//Configure adds the command's flags and returns the function that
+	//runs the command.  Leave it nil for a command that only holds other
+	//commands, like "report".
+	Configure func(c *kingpin.CmdClause) Runner
+	//NoLogin is set for commands that don't need to authenticate.
+	NoLogin bool
+}
+
+//registry holds the registered commands by name.
+var registry = make(map[string]Command)
+
+//Register adds a command.  Registering the same name twice panics.
+func Register(c Command) {
+	if _, ok := registry[c.Name]; ok {
+		panic(fmt.Sprintf("cli: command %s is already registered", c.Name))
+	}
+	registry[c.Name] = c
+}
+
+//Env is the runtime environment for a command.  It holds the global flags
+//and an authenticated API.
+type Env struct {
+	Context context.Context
+	API     *godig.API
+	//Profile is the YAML login file.
+	Profile string
+	//Output is the output filename.  "-" is stdout.  Empty means that
+	//the command should use its default.
+	Output string
+	//Format is the output format, "csv", "jsonl" or "parquet".
+	Format string
+	//Concurrency is the number of workers that a command should use.
+	Concurrency int
+	DryRun      bool
+	Verbose     bool
+	//Archive is the filename that receives records before they're deleted.
+	Archive string
+	//Progress reports how far along reads and writes are.  It's nil
+	//with --no-progress.
+	Progress *godig.Progress
+	//CacheDir holds cached responses.
+	CacheDir string
+}
+
+//OutputPath returns the output filename, using the default when --output
+//was not provided.
+func (e *Env) OutputPath(def string) string {
+	if len(e.Output) != 0 {
+		return e.Output
+	}
+	return def
+}
+
+//Create opens the output file.  Returns stdout when the output is "-".
+func (e *Env) Create(def string) (io.WriteCloser, string, error) {
+	p := e.OutputPath(def)
+	if p == "-" {
+		return nopCloser{os.Stdout}, p, nil
+	}
+	f, err := os.Create(p)
+	return f, p, err
+}
+
+//nopCloser keeps commands from closing stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+//Close implements io.Closer.
+func (nopCloser) Close() error {
+	return nil
+}
+
+//RowWriter writes rows of strings as CSV or as JSON Lines.  JSON Lines
+//objects use the headers as names, in header order.
+type RowWriter struct {
+	headers []string
+	c       *csv.Writer
+	j       *json.Encoder
+}
+
+//NewRowWriter returns a RowWriter for the environment's format.  CSV
+//output starts with the headers.
+func (e *Env) NewRowWriter(w io.Writer, headers []string) (*RowWriter, error) {
+	r := &RowWriter{headers: headers}
+	switch e.Format {
+	case "jsonl":
+		r.j = json.NewEncoder(w)
+		return r, nil
+	case "", "csv":
+		r.c = csv.NewWriter(w)
+		return r, r.c.Write(headers)
+	}
+	return nil, fmt.Errorf("format %s is not supported here", e.Format)
+}
+
+//Write writes a row.
+func (r *RowWriter) Write(row []string) error {
+	if r.c != nil {
+		return r.c.Write(row)
+	}
+	m := godig.NewRecord()
+	for i, h := range r.headers {
+		if i < len(row) {
+			m.Set(h, row[i])
+		}
+	}
+	return r.j.Encode(m)
+}
+
+//Flush writes buffered rows.
+func (r *RowWriter) Flush() error {
+	if r.c != nil {
+		r.c.Flush()
+		return r.c.Error()
+	}
+	return nil
+}
+
+//ProfilePath finds a login file.  The profile can be a filename or the
+//name of a file in ~/.godig, with or without ".yaml".
+func ProfilePath(p string) (string, error) {
+	if len(p) == 0 {
+		return p, errors.New("no profile, use --profile or set GODIG_PROFILE")
+	}
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p, err
+	}
+	for _, x := range []string{p, p + ".yaml", p + ".yml"} {
+		y := filepath.Join(home, ".godig", x)
+		if _, err := os.Stat(y); err == nil {
+			return y, nil
+		}
+	}
+	return p, fmt.Errorf("can't find profile %s", p)
+}
+
+//Main parses the command line, authenticates and runs the selected
+//command.  Main exits the program on errors.  When the command fails,
+//the archive, the rehearsal, the metrics and the trace are still
+//written first.
+func Main(args []string) {
+	app := kingpin.New("godig", "Dig data out of (and push data into) Salsa Classic.")
+	e := Env{Context: context.Background()}
+	app.Flag("profile", "YAML login file, or the name of a file in ~/.godig").Envar("GODIG_PROFILE").PlaceHolder("PROFILE").StringVar(&e.Profile)
+	login := app.Flag("login", "Same as --profile").Hidden().String()
+	app.Flag("verbose", "Show requests to, and responses from, the server. Can be very noisy.").BoolVar(&e.Verbose)
+	app.Flag("output", "Output file, '-' for stdout.  Each command has a default").Short('o').PlaceHolder("FILENAME").StringVar(&e.Output)
+	app.Flag("format", "Output format.  Parquet is only for export").Default("csv").EnumVar(&e.Format, godig.ExportFormats...)
+	app.Flag("concurrency", "Number of concurrent workers").Default("5").IntVar(&e.Concurrency)
+	app.Flag("dry-run", "Show what would be changed without changing anything").BoolVar(&e.DryRun)
+	app.Flag("archive", "Save each record to this JSON Lines file before deleting it").PlaceHolder("FILENAME").StringVar(&e.Archive)
+	progress := app.Flag("progress", "Show progress.  Use --no-progress to turn it off").Default("true").Bool()
+	metricsAddr := app.Flag("metrics-addr", "Serve Prometheus metrics at /metrics on this address, like ':9100'").PlaceHolder("ADDR").String()
+	metricsFile := app.Flag("metrics-file", "Write Prometheus metrics to this file at exit, for the textfile collector").PlaceHolder("FILENAME").String()
+	timeout := app.Flag("timeout", "Give up on a request to Salsa after this long, like '5m'.  Zero waits forever").Default("5m").Duration()
+	http2 := app.Flag("http2", "Use HTTP/2 when Salsa supports it.  Use --no-http2 to turn it off").Default("true").Bool()
+	useCache := app.Flag("cache", "Cache tables that change slowly.  Cached reads can be stale, so don't use it with commands that change data").Bool()
+	app.Flag("cache-dir", "Directory for cached responses.  Empty keeps them in memory").Default(defaultCacheDir()).StringVar(&e.CacheDir)
+	cacheTTLs := app.Flag("cache-ttl", "How long to cache a table, like 'chapter=2h'.  Use 0 to not cache it.  Repeat for more tables").PlaceHolder("TABLE=DURATION").StringMap()
+	tracePath := app.Flag("trace", "Write OpenTelemetry spans to this file, '-' for stdout").PlaceHolder("FILENAME").String()
+
+	runners := make(map[string]Runner)
+	noLogin := make(map[string]bool)
+	clauses := make(map[string]*kingpin.CmdClause)
+	var names []string
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c := registry[n]
+		cc := clause(app, clauses, strings.Fields(c.Name))
+		if c.Configure != nil {
+			runners[cc.FullCommand()] = c.Configure(cc)
+			noLogin[cc.FullCommand()] = c.NoLogin
+		}
+	}
+
+	cmd := kingpin.MustParse(app.Parse(args))
+	if len(e.Profile) == 0 {
+		e.Profile = *login
+	}
+	if *progress {
+		e.Progress = godig.NewProgress()
+	}
+	if !noLogin[cmd] {
+		p, err := ProfilePath(e.Profile)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		e.API, err = godig.YAMLAuth(p,
+			godig.WithTimeout(*timeout),
+			godig.WithConcurrency(e.Concurrency),
+			godig.WithHTTP2(*http2))
+		if err != nil {
+			log.Fatalf("Authentication error %v\n", err)
+		}
+		e.API.Verbose = e.Verbose
+		e.API.DryRun = e.DryRun
+		if *useCache {
+			e.API.Cache, err = cache(e.CacheDir, *cacheTTLs)
+			if err != nil {
+				log.Fatalf("Cache error %v\n", err)
+			}
+		}
+		if len(*metricsAddr) != 0 || len(*metricsFile) != 0 {
+			e.API.Metrics = godig.NewMetrics()
+		}
+		if len(*metricsAddr) != 0 {
+			err = e.API.Metrics.Serve(*metricsAddr)
+			if err != nil {
+				log.Fatalf("Metrics error %v\n", err)
+			}
+		}
+		if len(e.Archive) != 0 {
+			e.API.Archive, err = godig.OpenArchive(e.Archive)
+			if err != nil {
+				log.Fatalf("Archive error %v\n", err)
+			}
+		}
+	}
+	shutdown := func(context.Context) error { return nil }
+	if len(*tracePath) != 0 {
+		var err error
+		shutdown, err = godig.StartTracing(*tracePath)
+		if err != nil {
+			log.Fatalf("Tracing error %v\n", err)
+		}
+	}
+	ctx, span := godig.Stage(e.Context, "godig "+cmd)
+	e.Context = ctx
+	err := runners[cmd](&e)
+	godig.EndStage(span, err)
+	if e.API != nil && e.API.Archive != nil {
+		if x := e.API.Archive.Close(); x != nil {
+			log.Printf("Archive error %v\n", x)
+		}
+	}
+	if x := shutdown(context.Background()); x != nil {
+		log.Printf("Tracing error %v\n", x)
+	}
+	if e.API != nil && e.API.DryRun {
+		e.API.Rehearsal.Write(os.Stdout)
+	}
+	if e.API != nil && len(*metricsFile) != 0 {
+		if x := e.API.Metrics.WriteTextfile(*metricsFile); x != nil {
+			log.Printf("Metrics error %v\n", x)
+		}
+	}
+	if err != nil {
+		log.Printf("%s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+//clause returns the kingpin command for a name path, creating parent
+//commands as needed.  Help comes from the registry.
+func clause(app *kingpin.Application, clauses map[string]*kingpin.CmdClause, path []string) *kingpin.CmdClause {
+	var parent *kingpin.CmdClause
+	for i := range path {
+		k := strings.Join(path[:i+1], " ")
+		c, ok := clauses[k]
+		if !ok {
+			h := registry[k].Help
+			if parent == nil {
+				c = app.Command(path[i], h)
+			} else {
+				c = parent.Command(path[i], h)
+			}
+			clauses[k] = c
+		}
+		parent = c
+	}
+	return parent
+}
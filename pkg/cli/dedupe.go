@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "dedupe",
+		Help:      "Find supporters that are probably the same person.",
+		Configure: configureDedupe,
+	})
+}
+
+//configureDedupe configures the dedupe command.
+func configureDedupe(c *kingpin.CmdClause) Runner {
+	crit := criteriaFlag(c)
+	minScore := c.Flag("min-score", "Lowest confidence that makes two supporters duplicates, from 0 to 1").Default("0.8").Float64()
+	maxBucket := c.Flag("max-bucket", "Skip buckets with more supporters than this, zero for no limit").Default(strconv.Itoa(godig.DefaultMaxBucket)).Int()
+	weights := c.Flag("weight", "Weight of a rule, like 'email=4'.  Zero turns a rule off.  Repeat for more rules").PlaceHolder("RULE=WEIGHT").StringMap()
+	nicknames := c.Flag("nicknames", "CSV file of more nicknames.  Each row is a group of names for the same person").PlaceHolder("FILENAME").String()
+	activity := c.Flag("activity", "Choose survivors by their latest donation or action.  Use --no-activity to skip reading them").Default("true").Bool()
+	return func(e *Env) error {
+		t := e.API.Supporter()
+		d := godig.NewDeduper(&t)
+		d.Criteria = *crit
+		d.MinScore = *minScore
+		d.MaxBucket = *maxBucket
+		d.Progress = e.Progress
+		if !*activity {
+			d.Activity = nil
+		}
+		err := matchWeights(d.Matcher, *weights)
+		if err != nil {
+			return err
+		}
+		if len(*nicknames) != 0 {
+			x, err := readNicknames(*nicknames)
+			if err != nil {
+				return err
+			}
+			d.Matcher.Nicknames = append(x, d.Matcher.Nicknames...)
+		}
+		clusters, sum, err := d.Run(e.Context)
+		if err != nil {
+			return err
+		}
+
+		w, results, err := e.Create("dedupe_clusters.csv")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		headers := []string{"cluster", "size", "survivor", "supporter_KEY", "First_Name", "Last_Name", "Email", "Phone", "Zip",
+			"Last_Modified", "last_activity", "reasons"}
+		rw, err := e.NewRowWriter(w, headers)
+		if err != nil {
+			return err
+		}
+		for _, c := range clusters {
+			for i, r := range c.Records {
+				survivor := ""
+				reasons := dedupeReasons(c, r.Get("supporter_KEY"))
+				active := ""
+				if !c.Activity[i].IsZero() {
+					active = c.Activity[i].Format("2006-01-02")
+				}
+				if i == c.Survivor {
+					survivor = "yes"
+					reasons = append([]string{"survivor: " + c.Why}, reasons...)
+				}
+				err := rw.Write([]string{strconv.Itoa(c.ID), strconv.Itoa(len(c.Records)), survivor,
+					r.Get("supporter_KEY"), r.Get("First_Name"), r.Get("Last_Name"), r.Get("Email"), r.Get("Phone"), r.Get("Zip"),
+					r.Get("Last_Modified"), active, strings.Join(reasons, " | ")})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = rw.Flush()
+		log.Printf("dedupe: read %d, compared %d pairs in %d buckets, skipped %d large buckets\n",
+			sum.Read, sum.Compared, sum.Buckets, sum.Skipped)
+		log.Printf("dedupe: %d clusters, %d duplicates, report in %s\n", sum.Clusters, sum.Duplicates, results)
+		return err
+	}
+}
+
+//dedupeReasons describes the links from a supporter to the rest of its
+//cluster.
+func dedupeReasons(c godig.DedupeCluster, key string) []string {
+	var a []string
+	for _, l := range c.Links {
+		other := l.B
+		if l.B == key {
+			other = l.A
+		} else if l.A != key {
+			continue
+		}
+		a = append(a, fmt.Sprintf("%s %.3f by %s: %s", other, l.Score, l.Bucket, strings.Join(l.Reasons, "; ")))
+	}
+	return a
+}
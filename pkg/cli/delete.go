@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "delete",
+		Help:      "Delete the records in a table that match the criteria.",
+		Configure: configureDelete,
+	})
+}
+
+//configureDelete configures the delete command.
+func configureDelete(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	crit := criteriaFlag(c)
+	all := c.Flag("all", "Delete every record in the table when there's no criteria").Bool()
+	max := c.Flag("max", "Refuse to delete more than this many records, zero for no limit").Default("1000").Int()
+	yes := c.Flag("yes", "Delete without asking for confirmation").Bool()
+	cascade := c.Flag("cascade", "Also delete the records that depend on the deleted records").Bool()
+	skip := c.Flag("skip-conflicts", "With --cascade, keep records that have activity instead of stopping").Bool()
+	planPath := c.Flag("plan", "With --cascade, text file that shows every record that will be deleted").PlaceHolder("FILENAME").Default("delete_plan.txt").String()
+	return func(e *Env) error {
+		if len(*crit) == 0 && !*all {
+			return errors.New("use --criteria to select records, or --all to delete the whole table")
+		}
+		t := e.API.NewTable(*table)
+		b := godig.BulkDelete{
+			Sets:      []godig.DeleteSet{{Table: &t, Criteria: *crit, All: *all}},
+			Workers:   e.Concurrency,
+			Progress:  e.Progress,
+			Max:       *max,
+			Yes:       *yes,
+			AuditPath: e.OutputPath("delete_audit.csv"),
+		}
+		if *cascade {
+			sets, err := cascadeSets(e, &b, *planPath, *skip)
+			if err != nil {
+				return err
+			}
+			b.Sets = sets
+		}
+		totals, err := b.Run(e.Context)
+		for _, x := range totals {
+			log.Printf("delete: %s, %d deleted, %d failed\n", x.Table, x.Deleted, x.Failed)
+		}
+		log.Printf("delete: audit in %s\n", b.AuditPath)
+		return err
+	}
+}
+
+//cascadeSets reads the keys to delete, plans the cascade and returns the
+//sets to delete, children first.
+func cascadeSets(e *Env, b *godig.BulkDelete, planPath string, skip bool) ([]godig.DeleteSet, error) {
+	s := b.Sets[0]
+	counts, err := b.Preview()
+	if err != nil {
+		return nil, err
+	}
+	if b.Max > 0 && counts[0] > b.Max {
+		return nil, fmt.Errorf("refusing to delete %d records, the maximum is %d", counts[0], b.Max)
+	}
+	pk := s.Table.Name + "_KEY"
+	keys := []string{}
+	err = s.Table.Scan(e.Context, godig.Query{Criteria: s.Criteria, Progress: e.Progress}, func(page []*godig.Record) error {
+		for _, r := range page {
+			keys = append(keys, r.Get(pk))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := godig.CascadePlanner{API: e.API}
+	plan, err := p.Plan(e.Context, s.Table.Name, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Conflicts) != 0 {
+		plan.WriteSummary(os.Stdout)
+		if !skip {
+			return nil, errors.New("records have activity that was not selected, use --skip-conflicts to keep them")
+		}
+		plan.Prune()
+	}
+	f, err := os.Create(planPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = plan.Write(f)
+	log.Printf("delete: plan in %s\n", planPath)
+	return plan.DeleteSets(e.API), err
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "export",
+		Help:      "Write the records in a table or join to CSV, JSON Lines or Parquet.",
+		Configure: configureExport,
+	})
+}
+
+//configureExport configures the export command.
+func configureExport(c *kingpin.CmdClause) Runner {
+	table := c.Flag("table", "table name or join, for example 'supporter' or 'supporter(supporter_KEY)donation'").PlaceHolder("TABLE").Default("supporter").String()
+	crit := criteriaFlag(c)
+	fields := c.Flag("fields", "fields to export, in order.  Repeat or separate with commas.  Default is all fields").PlaceHolder("FIELD").Strings()
+	orderBy := c.Flag("order-by", "sort order, for example 'Date_Created DESC'.  Repeat as needed").PlaceHolder("FIELD").Strings()
+	gz := c.Flag("gzip", "Compress the output with gzip").Bool()
+	split := c.Flag("split", "Start a new output file after this many records").PlaceHolder("ROWS").Int()
+	lookups := c.Flag("lookup", "add fields from another table, like 'email_blast:Subject,Date_Requested'.  Use 'TABLE:KEY:FIELDS' when the key field isn't TABLE_KEY.  Repeat as needed").PlaceHolder("TABLE:FIELDS").Strings()
+	resume := resumeFlag(c)
+	return func(e *Env) error {
+		t := e.API.NewTable(*table)
+		var ls []*godig.Lookup
+		for _, x := range *lookups {
+			l, err := parseLookup(e.API, x)
+			if err != nil {
+				return err
+			}
+			ls = append(ls, l)
+		}
+		x := godig.Exporter{
+			Table:     &t,
+			Query:     godig.Query{Criteria: *crit, OrderBy: *orderBy, Progress: e.Progress},
+			Fields:    splitList(*fields),
+			Format:    e.Format,
+			Gzip:      *gz,
+			SplitRows: *split,
+			Lookups:   ls,
+		}
+		name := t.Name
+		// Resuming needs the same order every time.  Joins don't have a
+		// primary key to sort by, so they're only checkpointed with
+		// --order-by.
+		ordered := len(x.Query.OrderBy) != 0
+		if t.IsJoin() {
+			name = "export"
+			if *resume && !ordered {
+				return fmt.Errorf("--resume needs --order-by to export a join")
+			}
+		} else if !ordered {
+			x.Query.OrderBy = []string{t.Name + "_KEY"}
+			ordered = true
+		}
+		x.Path = e.OutputPath(name + x.Ext())
+		if x.Path != "-" && ordered {
+			var err error
+			x.Query.Checkpoint, err = checkpoint(x.Path+".checkpoint.json", *resume, &t, x.Query)
+			if err != nil {
+				return err
+			}
+		}
+		sum, err := x.Run(e.Context)
+		if err == nil && x.Query.Checkpoint != nil {
+			err = x.Query.Checkpoint.Remove()
+		}
+		log.Printf("export: %d records\n", sum.Rows)
+		if x.Path != "-" {
+			for _, f := range sum.Files {
+				log.Printf("export: wrote %s\n", f)
+			}
+		}
+		return err
+	}
+}
+
+//parseLookup parses a --lookup flag, "TABLE:FIELDS" or "TABLE:KEY:FIELDS".
+//Fields are separated with commas.
+func parseLookup(a *godig.API, s string) (*godig.Lookup, error) {
+	x := strings.Split(s, ":")
+	if len(x) < 2 || len(x) > 3 || len(x[0]) == 0 {
+		return nil, fmt.Errorf("--lookup %s: use TABLE:FIELDS or TABLE:KEY:FIELDS", s)
+	}
+	t := a.NewTable(x[0])
+	fields := splitList(x[len(x)-1:])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("--lookup %s: no fields", s)
+	}
+	l := godig.NewLookup(&t, fields...)
+	if len(x) == 3 && len(x[1]) != 0 {
+		l.Key = x[1]
+	}
+	return l, nil
+}
+
+//splitList splits comma-separated values and trims spaces.
+func splitList(a []string) []string {
+	var b []string
+	for _, s := range a {
+		for _, x := range strings.Split(s, ",") {
+			x = strings.TrimSpace(x)
+			if len(x) != 0 {
+				b = append(b, x)
+			}
+		}
+	}
+	return b
+}
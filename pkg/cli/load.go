@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "load",
+		Help:      "Insert or update records from a CSV or JSON Lines file.",
+		Configure: configureLoad,
+	})
+	Register(Command{
+		Name:      "restore",
+		Help:      "Re-insert records from an archive written while deleting.",
+		Configure: configureRestore,
+	})
+}
+
+//configureLoad configures the load command.
+func configureLoad(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	in := c.Flag("in", "CSV or JSON Lines file to load").PlaceHolder("FILENAME").Required().String()
+	format := c.Flag("in-format", "Input format, csv or jsonl.  Default is from the filename").Enum("csv", "jsonl")
+	mapping := c.Flag("map", "Map an input column to a field, repeat as needed.  Use '-' to skip a column").PlaceHolder("COLUMN=FIELD").StringMap()
+	match := c.Flag("match", "Field used to find existing records when there's no primary key, for example Email").PlaceHolder("FIELD").String()
+	rate := c.Flag("rate", "Maximum requests per second, zero for no limit").Default("10").Float64()
+	retries := c.Flag("retries", "Retries for requests that can't reach Salsa.  Failed inserts are only retried when --match shows that they weren't applied").Default("3").Int()
+	resume := c.Flag("resume", "Skip rows that were loaded by an earlier run, using the results file").Bool()
+	csvOptions := csvFlags(c)
+	return func(e *Env) error {
+		o, err := csvOptions()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		x := *format
+		if len(x) == 0 {
+			x = godig.FormatFromPath(*in)
+		}
+		rows, err := godig.NewRowReaderWith(f, x, o)
+		if err != nil {
+			return err
+		}
+		results := e.OutputPath(*in + ".results.csv")
+		t := e.API.NewTable(*table)
+		l := godig.Loader{
+			Table:       &t,
+			Mapping:     *mapping,
+			MatchField:  *match,
+			Workers:     e.Concurrency,
+			Rate:        *rate,
+			Retries:     *retries,
+			ResultsPath: results,
+			Resume:      *resume,
+			Progress:    e.Progress,
+		}
+		sum, err := l.Run(e.Context, rows)
+		log.Printf("load: read %d, skipped %d, inserted %d, updated %d, errors %d\n",
+			sum.Read, sum.Skipped, sum.Inserted, sum.Updated, sum.Errors)
+		log.Printf("load: results in %s\n", results)
+		return err
+	}
+}
+
+//configureRestore configures the restore command.
+func configureRestore(c *kingpin.CmdClause) Runner {
+	in := c.Flag("in", "Archive file (JSON Lines)").PlaceHolder("FILENAME").Required().String()
+	newKeys := c.Flag("new-keys", "Insert every record with a new key instead of asking Salsa to keep the original key").Bool()
+	return func(e *Env) error {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		records, err := godig.ReadArchive(f)
+		if err != nil {
+			return err
+		}
+		w, results, err := e.Create(*in + ".restored.csv")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		rw, err := e.NewRowWriter(w, []string{"object", "old_key", "new_key", "result", "messages"})
+		if err != nil {
+			return err
+		}
+		defer rw.Flush()
+
+		r := godig.Restorer{API: e.API, PreserveKeys: !*newKeys, Progress: e.Progress}
+		log.Printf("restore: restoring %d records\n", len(records))
+		ok, failed, err := r.Restore(e.Context, records, func(x godig.RestoreResult) {
+			rw.Write([]string{x.Object, x.OldKey, x.NewKey, x.Result, strings.Join(x.Messages, "; ")})
+			if x.Result != "success" {
+				log.Printf("restore: %s key %s, %s %v\n", x.Object, x.OldKey, x.Result, x.Messages)
+			}
+		})
+		log.Printf("restore: %d restored, %d failed, results in %s\n", ok, failed, results)
+		return err
+	}
+}
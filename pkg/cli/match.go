@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "match",
+		Help:      "Find the supporters that look like the rows in a CSV file.",
+		Configure: configureMatch,
+	})
+}
+
+//matchBlocks are the blocks that --block can choose.
+var matchBlocks = []string{"email_domain", "last_name", "zip"}
+
+//configureMatch configures the match command.
+func configureMatch(c *kingpin.CmdClause) Runner {
+	in := c.Flag("in", "CSV file of people to find").PlaceHolder("FILENAME").Required().String()
+	mapping := c.Flag("map", "Map an input column to a supporter field, repeat as needed.  Use '-' to skip a column").PlaceHolder("COLUMN=FIELD").StringMap()
+	minScore := c.Flag("min-score", "Lowest confidence to report, from 0 to 1").Default(fmt.Sprint(godig.DefaultMinScore)).Float64()
+	maxMatches := c.Flag("max-matches", "Most matches to report for each row").Default("5").Int()
+	maxBlock := c.Flag("max-block", "Skip blocks with more candidates than this, zero for no limit").Default(strconv.Itoa(godig.DefaultMaxBlock)).Int()
+	blocks := c.Flag("block", "Read candidates by email_domain, last_name or zip.  Repeat for more.  Default is all three").PlaceHolder("BLOCK").Enums(matchBlocks...)
+	prefix := c.Flag("last-name-prefix", "Letters of the last name that the last_name block uses").Default("3").Int()
+	weights := c.Flag("weight", "Weight of a rule, like 'email=4'.  Zero turns a rule off.  Repeat for more rules").PlaceHolder("RULE=WEIGHT").StringMap()
+	nicknames := c.Flag("nicknames", "CSV file of more nicknames.  Each row is a group of names for the same person").PlaceHolder("FILENAME").String()
+	csvOptions := csvFlags(c)
+	return func(e *Env) error {
+		o, err := csvOptions()
+		if err != nil {
+			return err
+		}
+		o.Map = *mapping
+		t := e.API.Supporter()
+		m := godig.NewMatcher(&t)
+		m.MinScore = *minScore
+		m.MaxMatches = *maxMatches
+		m.MaxBlock = *maxBlock
+		m.Workers = e.Concurrency
+		m.Progress = e.Progress
+		names := *blocks
+		if len(names) == 0 {
+			names = matchBlocks
+		}
+		m.Blocks = nil
+		for _, b := range names {
+			switch b {
+			case "email_domain":
+				m.Blocks = append(m.Blocks, godig.EmailDomainBlock())
+			case "last_name":
+				m.Blocks = append(m.Blocks, godig.LastNameBlock(*prefix))
+			case "zip":
+				m.Blocks = append(m.Blocks, godig.ZipBlock())
+			}
+		}
+		err = matchWeights(m, *weights)
+		if err != nil {
+			return err
+		}
+		if len(*nicknames) != 0 {
+			x, err := readNicknames(*nicknames)
+			if err != nil {
+				return err
+			}
+			m.Nicknames = append(x, m.Nicknames...)
+		}
+
+		r, err := godig.OpenCSV(*in, o)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		var inputs []*godig.Record
+		var lines []int
+		err = r.Each(func(x *godig.Record) error {
+			inputs = append(inputs, x)
+			lines = append(lines, r.Line())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		w, results, err := e.Create(*in + ".matches.csv")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		headers := []string{"line", "in_First_Name", "in_Last_Name", "in_Email",
+			"rank", "confidence", "supporter_KEY", "First_Name", "Last_Name", "Email", "Phone", "Zip", "reasons"}
+		rw, err := e.NewRowWriter(w, headers)
+		if err != nil {
+			return err
+		}
+		defer rw.Flush()
+
+		sum, err := m.Run(e.Context, inputs, func(x godig.MatchResult) error {
+			row := []string{strconv.Itoa(lines[x.Row]), x.Input.Get("First_Name"), x.Input.Get("Last_Name"), x.Input.Get("Email")}
+			if len(x.Matches) == 0 {
+				reason := "no match"
+				switch {
+				case x.Err != nil:
+					reason = x.Err.Error()
+				case len(x.Skipped) != 0:
+					reason = "no match, too many candidates in " + strings.Join(x.Skipped, ", ")
+				}
+				return rw.Write(append(row, "0", "", "", "", "", "", "", "", reason))
+			}
+			for i, y := range x.Matches {
+				c := y.Record
+				err := rw.Write(append(row, strconv.Itoa(i+1), fmt.Sprintf("%.3f", y.Score),
+					c.Get("supporter_KEY"), c.Get("First_Name"), c.Get("Last_Name"), c.Get("Email"),
+					c.Get("Phone"), c.Get("Zip"), strings.Join(y.Reasons, "; ")))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		log.Printf("match: %d rows, %d matched, %d not matched, %d errors\n", sum.Rows, sum.Matched, sum.NoMatch, sum.Errors)
+		log.Printf("match: results in %s\n", results)
+		return err
+	}
+}
+
+//matchWeights sets the weights of the matcher's rules.
+func matchWeights(m *godig.Matcher, weights map[string]string) error {
+	for k, v := range weights {
+		w, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("--weight %s=%s: %v", k, v, err)
+		}
+		found := false
+		for i := range m.Rules {
+			if m.Rules[i].Name == strings.TrimSpace(k) {
+				m.Rules[i].Weight = w
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, r := range m.Rules {
+				names = append(names, r.Name)
+			}
+			return fmt.Errorf("--weight: unknown rule '%s', use one of %s", k, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+//readNicknames reads a CSV file of nickname groups.  The file doesn't
+//have a header row and the rows can have any number of names.
+func readNicknames(p string) ([][]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	a, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	return a, nil
+}
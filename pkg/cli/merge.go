@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "merge",
+		Help:      "Merge duplicate supporters into a survivor.",
+		Configure: configureMerge,
+	})
+	Register(Command{
+		Name:      "unmerge",
+		Help:      "Reverse merges using the undo log.",
+		Configure: configureUnmerge,
+	})
+}
+
+//configureMerge configures the merge command.
+func configureMerge(c *kingpin.CmdClause) Runner {
+	survivor := c.Flag("survivor", "Key of the supporter to keep").PlaceHolder("KEY").String()
+	losers := c.Flag("loser", "Key of a supporter to merge into the survivor, repeat as needed").PlaceHolder("KEY").Strings()
+	in := c.Flag("in", "Cluster report from dedupe.  Each cluster is merged into the row marked as the survivor").PlaceHolder("FILENAME").String()
+	policy := c.Flag("policy", "How fields are copied to the survivor: fill empty fields, take the newest values, or none").Default("fill").Enum(godig.MergePolicies...)
+	fields := c.Flag("field", "Only copy this field to the survivor, repeat as needed").PlaceHolder("FIELD").Strings()
+	planPath := c.Flag("plan", "Text file that shows every change").PlaceHolder("FILENAME").Default("merge_plan.txt").String()
+	undoPath := c.Flag("undo", "JSON Lines file that records every change so that unmerge can reverse it").PlaceHolder("FILENAME").Default("merge_undo.jsonl").String()
+	yes := c.Flag("yes", "Merge without asking for confirmation").Bool()
+	return func(e *Env) error {
+		var merges []godig.Merge
+		switch {
+		case len(*in) != 0:
+			x, err := readClusters(*in)
+			if err != nil {
+				return err
+			}
+			merges = x
+		case len(*survivor) != 0 && len(*losers) != 0:
+			merges = []godig.Merge{{Survivor: *survivor, Losers: *losers}}
+		default:
+			return errors.New("use --survivor and --loser, or --in with a dedupe report")
+		}
+		m := godig.Merger{
+			API:      e.API,
+			Policy:   *policy,
+			Fields:   *fields,
+			UndoPath: *undoPath,
+			Progress: e.Progress,
+		}
+		var plans []*godig.MergePlan
+		steps := 0
+		for _, x := range merges {
+			p, err := m.Plan(e.Context, x)
+			if err != nil {
+				return err
+			}
+			plans = append(plans, p)
+			steps += len(p.Steps)
+		}
+		err := writeMergePlans(*planPath, plans)
+		if err != nil {
+			return err
+		}
+		log.Printf("merge: %d merges, %d changes, plan in %s\n", len(plans), steps, *planPath)
+		if !e.DryRun && !*yes {
+			phrase := fmt.Sprintf("MERGE %d", len(plans))
+			fmt.Printf("Read %s, then type '%s' to continue: ", *planPath, phrase)
+			s, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(s) != phrase {
+				return errors.New("merge not confirmed")
+			}
+		}
+		for i, p := range plans {
+			n, err := m.Execute(e.Context, p)
+			if err != nil {
+				log.Printf("merge: %d of %d merges done, %d of %d changes in this one\n", i, len(plans), n, len(p.Steps))
+				log.Printf("merge: use unmerge --in %s to reverse them\n", *undoPath)
+				return err
+			}
+		}
+		log.Printf("merge: %d merges done, undo log in %s\n", len(plans), *undoPath)
+		return nil
+	}
+}
+
+//writeMergePlans writes the merge plans to a file.
+func writeMergePlans(p string, plans []*godig.MergePlan) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	for _, x := range plans {
+		if err == nil {
+			err = x.Write(f)
+		}
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	return err
+}
+
+//readClusters reads a dedupe cluster report.  Each cluster needs one row
+//with "yes" in the survivor column.  Clusters are merged in file order.
+func readClusters(p string) ([]godig.Merge, error) {
+	r, err := godig.OpenCSV(p, godig.CSVOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var ids []string
+	m := make(map[string]*godig.Merge)
+	err = r.Each(func(x *godig.Record) error {
+		id, key := x.Get("cluster"), strings.TrimSpace(x.Get("supporter_KEY"))
+		if len(id) == 0 || len(key) == 0 {
+			return fmt.Errorf("%s line %d: need cluster and supporter_KEY", p, r.Line())
+		}
+		c := m[id]
+		if c == nil {
+			c = &godig.Merge{}
+			m[id] = c
+			ids = append(ids, id)
+		}
+		if strings.EqualFold(strings.TrimSpace(x.Get("survivor")), "yes") {
+			if len(c.Survivor) != 0 {
+				return fmt.Errorf("%s line %d: cluster %s has more than one survivor", p, r.Line(), id)
+			}
+			c.Survivor = key
+		} else {
+			c.Losers = append(c.Losers, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var a []godig.Merge
+	for _, id := range ids {
+		c := m[id]
+		if len(c.Survivor) == 0 {
+			return nil, fmt.Errorf("%s: cluster %s doesn't have a survivor", p, id)
+		}
+		if len(c.Losers) != 0 {
+			a = append(a, *c)
+		}
+	}
+	return a, nil
+}
+
+//configureUnmerge configures the unmerge command.
+func configureUnmerge(c *kingpin.CmdClause) Runner {
+	in := c.Flag("in", "Undo log written by merge").PlaceHolder("FILENAME").Default("merge_undo.jsonl").String()
+	survivor := c.Flag("survivor", "Only reverse the merges into this supporter").PlaceHolder("KEY").String()
+	return func(e *Env) error {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entries, err := godig.ReadUndoLog(f)
+		if err != nil {
+			return err
+		}
+		if len(*survivor) != 0 {
+			var a []godig.UndoEntry
+			for _, x := range entries {
+				if x.Survivor == *survivor {
+					a = append(a, x)
+				}
+			}
+			entries = a
+		}
+		w, results, err := e.Create(*in + ".undone.csv")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		rw, err := e.NewRowWriter(w, []string{"object", "old_key", "new_key", "result", "messages"})
+		if err != nil {
+			return err
+		}
+		defer rw.Flush()
+
+		m := godig.Merger{API: e.API, Progress: e.Progress}
+		log.Printf("unmerge: reversing %d changes\n", len(entries))
+		ok, failed, err := m.Undo(e.Context, entries, func(x godig.RestoreResult) {
+			rw.Write([]string{x.Object, x.OldKey, x.NewKey, x.Result, strings.Join(x.Messages, "; ")})
+			if x.Result != "success" {
+				log.Printf("unmerge: %s key %s, %s %v\n", x.Object, x.OldKey, x.Result, x.Messages)
+			}
+		})
+		log.Printf("unmerge: %d reversed, %d failed, results in %s\n", ok, failed, results)
+		return err
+	}
+}
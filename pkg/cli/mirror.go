@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "mirror",
+		Help:      "Copy Salsa tables into SQLite, MySQL or Postgres.",
+		Configure: configureMirror,
+	})
+	Register(Command{
+		Name:      "sync",
+		Help:      "Update a mirror with the records that changed since the last sync.",
+		Configure: configureSync,
+	})
+}
+
+//sqlFlags adds the flags that choose a SQL database.
+func sqlFlags(c *kingpin.CmdClause) (driver, dsn *string) {
+	driver = c.Flag("driver", "SQL driver, sqlite3, mysql or postgres.  Default is 'driver' from the profile, then sqlite3").PlaceHolder("DRIVER").String()
+	dsn = c.Flag("dsn", "SQL data source name.  Default is 'dsn' from the profile.  SQLite uses --output, then godig.sqlite3").PlaceHolder("DSN").String()
+	return driver, dsn
+}
+
+//openMirror opens the SQL database from the flags or the profile.
+func openMirror(e *Env, driver, dsn string) (*godig.Mirror, error) {
+	if len(driver) == 0 {
+		driver = e.API.CredData.Driver
+	}
+	if len(dsn) == 0 {
+		dsn = e.API.CredData.DSN
+	}
+	if len(driver) == 0 {
+		driver = "sqlite3"
+	}
+	if len(dsn) == 0 && (driver == "sqlite3" || driver == "sqlite") {
+		dsn = e.OutputPath("godig.sqlite3")
+	}
+	m, err := godig.OpenMirror(driver, dsn)
+	if err == nil {
+		m.Profile = profileName(e.Profile)
+	}
+	return m, err
+}
+
+//profileName returns the name of a profile for watermarks, for example
+//"prod" for "~/.godig/prod.yaml".
+func profileName(p string) string {
+	p = filepath.Base(p)
+	return strings.TrimSuffix(p, filepath.Ext(p))
+}
+
+//configureMirror configures the mirror command.
+func configureMirror(c *kingpin.CmdClause) Runner {
+	tables := c.Flag("table", "table to mirror, repeat as needed").PlaceHolder("TABLE").Default("supporter").Strings()
+	crit := criteriaFlag(c)
+	driver, dsn := sqlFlags(c)
+	resume := resumeFlag(c)
+	return func(e *Env) error {
+		m, err := openMirror(e, *driver, *dsn)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		for _, name := range *tables {
+			t := e.API.NewTable(name)
+			q := godig.Query{Criteria: *crit, OrderBy: []string{name + "_KEY"}, Progress: e.Progress}
+			q.Checkpoint, err = checkpoint("mirror-"+name+".checkpoint.json", *resume, &t, q)
+			if err != nil {
+				return err
+			}
+			n, err := m.Run(e.Context, &t, q, nil)
+			log.Printf("mirror: %s, %d records\n", name, n)
+			if err != nil {
+				return err
+			}
+			err = q.Checkpoint.Remove()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+//configureSync configures the sync command.
+func configureSync(c *kingpin.CmdClause) Runner {
+	tables := c.Flag("table", "table to sync, repeat as needed").PlaceHolder("TABLE").Default("supporter").Strings()
+	crit := criteriaFlag(c)
+	driver, dsn := sqlFlags(c)
+	overlap := c.Flag("overlap", "Read records modified this long before the last sync, too").Default(godig.DefaultOverlap.String()).Duration()
+	every := c.Flag("reconcile-every", "Look for deleted records when the last look was this long ago").Default("24h").Duration()
+	reconcile := c.Flag("reconcile", "Look for deleted records now").Bool()
+	resume := resumeFlag(c)
+	return func(e *Env) error {
+		m, err := openMirror(e, *driver, *dsn)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		s := godig.Syncer{
+			Mirror:         m,
+			Criteria:       *crit,
+			Overlap:        *overlap,
+			ReconcileEvery: *every,
+			Reconcile:      *reconcile,
+			CheckpointDir:  ".",
+			Resume:         *resume,
+			Progress:       e.Progress,
+		}
+		for _, name := range *tables {
+			t := e.API.NewTable(name)
+			r, err := s.Sync(e.Context, &t, nil)
+			if r.Full {
+				log.Printf("sync: %s, %d records, full\n", name, r.Upserted)
+			} else {
+				log.Printf("sync: %s, %d records modified since %s\n", name, r.Upserted, r.Since.Format(time.RFC3339))
+			}
+			if r.Reconciled {
+				log.Printf("sync: %s, %d deleted records removed\n", name, r.Deleted)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name:      "count",
+		Help:      "Count the records in a table that match the criteria.",
+		Configure: configureCount,
+	})
+	Register(Command{
+		Name:      "query",
+		Help:      "Show a single record as JSON.",
+		Configure: configureQuery,
+	})
+	Register(Command{
+		Name:      "describe",
+		Help:      "List the fields in a table.",
+		Configure: configureDescribe,
+	})
+	Register(Command{
+		Name:      "save",
+		Help:      "Insert or update a single record.",
+		Configure: configureSave,
+	})
+}
+
+//tableFlag adds the --table flag that most commands use.
+func tableFlag(c *kingpin.CmdClause) *string {
+	return c.Flag("table", "table name ([supporter], donation, groups, etc.)").PlaceHolder("TABLE").Default("supporter").String()
+}
+
+//csvFlags adds the flags that describe an input CSV file.  The function
+//returns the options after the command line is parsed.
+func csvFlags(c *kingpin.CmdClause) func() (godig.CSVOptions, error) {
+	delimiter := c.Flag("delimiter", "Field separator for CSV input.  Use 'tab' for tab-separated files").Default(",").String()
+	encoding := c.Flag("encoding", "Encoding of CSV input.  Excel often uses windows-1252").Default("utf-8").Enum(godig.CSVEncodings...)
+	strict := c.Flag("strict", "Stop on CSV rows that don't have the same number of fields as the header").Bool()
+	return func() (godig.CSVOptions, error) {
+		o := godig.CSVOptions{Encoding: *encoding, Strict: *strict}
+		d := []rune(*delimiter)
+		switch {
+		case *delimiter == "tab" || *delimiter == `\t`:
+			o.Comma = '\t'
+		case len(d) == 1:
+			o.Comma = d[0]
+		default:
+			return o, fmt.Errorf("--delimiter must be one character, not '%s'", *delimiter)
+		}
+		return o, nil
+	}
+}
+
+//criteriaFlag adds the --criteria flag that most commands use.
+func criteriaFlag(c *kingpin.CmdClause) *string {
+	return c.Flag("criteria", "Salsa-formatted API condition.  Separate conditions with '&condition='").PlaceHolder("CRITERIA").String()
+}
+
+//configureCount configures the count command.
+func configureCount(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	crit := criteriaFlag(c)
+	return func(e *Env) error {
+		t := e.API.NewTable(*table)
+		x, err := t.Count(*crit)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(x))
+		return nil
+	}
+}
+
+//configureQuery configures the query command.
+func configureQuery(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	key := c.Flag("key", "primary key").PlaceHolder("KEY").Required().String()
+	return func(e *Env) error {
+		t := e.API.NewTable(*table)
+		b, err := t.OneRaw(*key)
+		if err != nil {
+			return err
+		}
+		w, _, err := e.Create("-")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		_, err = fmt.Fprintln(w, strings.Replace(string(b), ",", ",\n", -1))
+		return err
+	}
+}
+
+//configureDescribe configures the describe command.
+func configureDescribe(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	return func(e *Env) error {
+		t := e.API.NewTable(*table)
+		f, err := t.Describe()
+		if err != nil {
+			return err
+		}
+		w, _, err := e.Create("-")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		headers := []string{"name", "type", "label", "nullable", "maxlength", "default", "custom"}
+		rw, err := e.NewRowWriter(w, headers)
+		if err != nil {
+			return err
+		}
+		for _, x := range f {
+			rw.Write([]string{x.Name, x.Type, x.Label, x.Nullable, x.MaxLength, x.DefaultValue, x.IsCustom})
+		}
+		return rw.Flush()
+	}
+}
+
+//configureSave configures the save command.
+func configureSave(c *kingpin.CmdClause) Runner {
+	table := tableFlag(c)
+	key := c.Flag("key", "primary key, zero to insert").PlaceHolder("KEY").Default("0").String()
+	fields := c.Flag("field", "field to save, repeat as needed").PlaceHolder("NAME=VALUE").StringMap()
+	links := c.Flag("link", "link to a record in another table, repeat as needed").PlaceHolder("TABLE=KEY").Strings()
+	tags := c.Flag("tag", "tag to add to the record, repeat as needed").PlaceHolder("TAG").Strings()
+	return func(e *Env) error {
+		if len(*fields) == 0 && len(*links) == 0 && len(*tags) == 0 {
+			return fmt.Errorf("nothing to save, use --field, --link or --tag")
+		}
+		t := e.API.NewTable(*table)
+		opts := godig.SaveOptions{Tags: *tags}
+		for _, x := range *links {
+			i := strings.Index(x, "=")
+			if i < 1 {
+				return fmt.Errorf("--link %s: use TABLE=KEY", x)
+			}
+			opts.Links = append(opts.Links, godig.Link{Table: strings.TrimSpace(x[:i]), Key: strings.TrimSpace(x[i+1:])})
+		}
+		k, r, err := t.SaveRecordWith(e.Context, *key, *fields, opts)
+		if err != nil {
+			return err
+		}
+		log.Printf("save: %s key %s, %s %v\n", t.Name, k, r.Result, r.Messages)
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	godig "github.com/salsalabs/godig/pkg"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func init() {
+	Register(Command{
+		Name: "report",
+		Help: "Reports.",
+	})
+	Register(Command{
+		Name:      "report blast-donations",
+		Help:      "Donation statistics for each email blast.",
+		Configure: configureBlastDonations,
+	})
+}
+
+//blastDonation is a donation attributed to an email blast.
+type blastDonation struct {
+	EmailBlastKey string                `json:"email_blast_KEY"`
+	DateRequested *godig.SalsaTimestamp `json:"Date_Requested"`
+	Subject       string                `json:"Subject"`
+	Amount        string                `json:"Amount"`
+}
+
+//blastStats contains an email blast and statistics for its donations.
+type blastStats struct {
+	EmailBlastKey string
+	DateRequested *godig.SalsaTimestamp
+	Subject       string
+	Count         int
+	Min           float64
+	Max           float64
+	Sum           float64
+}
+
+//add accumulates a donation amount.
+func (s *blastStats) add(a float64) {
+	if s.Count == 0 {
+		s.Min = a
+	}
+	s.Count++
+	s.Min = math.Min(s.Min, a)
+	s.Max = math.Max(s.Max, a)
+	s.Sum += a
+}
+
+//row returns the stats as a report row.
+func (s *blastStats) row() []string {
+	d := ""
+	if s.DateRequested != nil {
+		d = s.DateRequested.Time.Format(godig.DateFormat)
+	}
+	return []string{
+		s.EmailBlastKey,
+		d,
+		s.Subject,
+		fmt.Sprintf("%d", s.Count),
+		fmt.Sprintf("%.2f", s.Min),
+		fmt.Sprintf("%.2f", s.Max),
+		fmt.Sprintf("%.2f", s.Sum/float64(s.Count)),
+		fmt.Sprintf("%.2f", s.Sum),
+	}
+}
+
+//configureBlastDonations configures the blast donation report.
+//Attribution is via the "email_blast" tags that Salsa adds for any
+//donation attributable to a blast.
+func configureBlastDonations(c *kingpin.CmdClause) Runner {
+	crit := criteriaFlag(c)
+	return func(e *Env) error {
+		// Joins email blasts to donations through the email_blast tags.
+		// See cmd/donations/blasts/blast_donation_report for the SQL.
+		clauses := []string{"tag(tag_KEY)",
+			"tag_data(tag.tag=email_blast_KEY)",
+			"email_blast(tag_data.table_KEY=donation_KEY)",
+			"donation"}
+		cond := "tag_data.database_table_KEY=" + godig.DonationTableKey + "&condition=tag.prefix=email_blast&condition=donation.RESULT IN (0,-1)"
+		if len(*crit) != 0 {
+			cond = cond + "&condition=" + *crit
+		}
+		t := e.API.NewTable(strings.Join(clauses, ""))
+
+		w, p, err := e.Create("blast_donation_report.csv")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		headers := []string{"EmailBlastKey", "DateRequested", "Subject", "Count", "Min", "Max", "Avg", "Sum"}
+		rw, err := e.NewRowWriter(w, headers)
+		if err != nil {
+			return err
+		}
+
+		// Donations arrive grouped by email blast.
+		var s *blastStats
+		e.Progress.StartTable(&t, cond, 0)
+		offset := int32(0)
+		for {
+			var a []blastDonation
+			err := t.LeftJoinContext(e.Context, offset, godig.PageSize, cond, &a)
+			if err != nil {
+				return err
+			}
+			if len(a) == 0 {
+				break
+			}
+			for _, r := range a {
+				if s == nil || r.EmailBlastKey != s.EmailBlastKey {
+					if s != nil {
+						rw.Write(s.row())
+					}
+					s = &blastStats{
+						EmailBlastKey: r.EmailBlastKey,
+						DateRequested: r.DateRequested,
+						Subject:       r.Subject,
+					}
+				}
+				x, _ := strconv.ParseFloat(r.Amount, 64)
+				s.add(x)
+			}
+			offset += int32(len(a))
+			e.Progress.Add(len(a))
+		}
+		e.Progress.Finish()
+		if s != nil {
+			rw.Write(s.row())
+		}
+		log.Printf("report: results in %s\n", p)
+		return rw.Flush()
+	}
+}
@@ -0,0 +1,139 @@
+package godig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+//ClientOptions configure the HTTP client that NewAPI creates.
+type ClientOptions struct {
+	//Timeout limits a whole request, including reading the response.
+	//Zero means no limit.
+	Timeout time.Duration
+	//DialTimeout limits opening a connection.
+	DialTimeout time.Duration
+	//TLSHandshakeTimeout limits the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	//ResponseHeaderTimeout limits the wait for Salsa to start answering.
+	ResponseHeaderTimeout time.Duration
+	//MaxIdleConnsPerHost is the number of connections kept open for
+	//reuse.  Match it to the number of workers so that they don't open
+	//a new connection for every request.
+	MaxIdleConnsPerHost int
+	//IdleConnTimeout is how long an unused connection is kept.
+	IdleConnTimeout time.Duration
+	//HTTP2 uses HTTP/2 when the server supports it.
+	HTTP2 bool
+	//Compression asks for gzipped responses.  They're decompressed as
+	//they're read.
+	Compression bool
+}
+
+//DefaultClientOptions are the options that NewAPI starts with.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Timeout:               5 * time.Minute,
+		DialTimeout:           30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		HTTP2:                 true,
+		Compression:           true,
+	}
+}
+
+//Option changes the client options.
+type Option func(*ClientOptions)
+
+//WithTimeout limits a whole request.  Zero means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(o *ClientOptions) { o.Timeout = d }
+}
+
+//WithConcurrency keeps enough idle connections open for n workers.
+func WithConcurrency(n int) Option {
+	return func(o *ClientOptions) {
+		if n > 0 {
+			o.MaxIdleConnsPerHost = n
+		}
+	}
+}
+
+//WithHTTP2 turns HTTP/2 on or off.
+func WithHTTP2(on bool) Option {
+	return func(o *ClientOptions) { o.HTTP2 = on }
+}
+
+//WithCompression turns gzipped responses on or off.
+func WithCompression(on bool) Option {
+	return func(o *ClientOptions) { o.Compression = on }
+}
+
+//WithClientOptions replaces all of the options.
+func WithClientOptions(x ClientOptions) Option {
+	return func(o *ClientOptions) { *o = x }
+}
+
+//NewClient returns an HTTP client configured by the options.
+func NewClient(o ClientOptions) *http.Client {
+	d := &net.Dialer{
+		Timeout:   o.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		TLSClientConfig:       &tls.Config{},
+		TLSHandshakeTimeout:   o.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		MaxIdleConns:          o.MaxIdleConnsPerHost * 2,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		ForceAttemptHTTP2:     o.HTTP2,
+		DisableCompression:    !o.Compression,
+	}
+	if !o.HTTP2 {
+		// A non-nil, empty map turns HTTP/2 off.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return &http.Client{Transport: t, Timeout: o.Timeout}
+}
+
+//timing adds an httptrace to a request in verbose mode.  Call the
+//returned function when the response has been read.  It shows how long
+//each part of the request took.
+func (a *API) timing(req *http.Request) (*http.Request, func()) {
+	if !a.Verbose {
+		return req, func() {}
+	}
+	var start, dns, dnsDone, connect, connectDone, tlsStart, tlsDone, first time.Time
+	reused := false
+	t := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dns = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { connect = time.Now() },
+		ConnectDone:          func(string, string, error) { connectDone = time.Now() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		GotConn:              func(i httptrace.GotConnInfo) { reused = i.Reused },
+		GotFirstResponseByte: func() { first = time.Now() },
+	}
+	start = time.Now()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t))
+	return req, func() {
+		ms := func(a, b time.Time) string {
+			if a.IsZero() || b.IsZero() {
+				return "-"
+			}
+			return fmt.Sprintf("%.1fms", float64(b.Sub(a))/float64(time.Millisecond))
+		}
+		fmt.Printf("Timing: dns=%s connect=%s tls=%s ttfb=%s total=%s reused=%v\n",
+			ms(dns, dnsDone), ms(connect, connectDone), ms(tlsStart, tlsDone),
+			ms(start, first), ms(start, time.Now()), reused)
+	}
+}
@@ -0,0 +1,216 @@
+package godig
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+//CSVEncodings are the encodings that a CSVReader can read.
+var CSVEncodings = []string{"utf-8", "windows-1252", "latin1", "utf-16"}
+
+//CSVOptions describe a CSV file.  The zero value reads a UTF-8 file
+//with commas and a header row.
+type CSVOptions struct {
+	//Comma separates fields.  The default is ','.  Use '\t' for
+	//tab-separated files.
+	Comma rune
+	//Encoding is one of CSVEncodings.  The default is UTF-8.  Excel
+	//usually saves CSV files as "windows-1252".
+	Encoding string
+	//Header names the columns of a file that doesn't have a header row.
+	//Leave it empty to read the names from the first row.  Columns
+	//after the named ones are ignored.
+	Header []string
+	//Map renames columns.  The key is the column's name in the file and
+	//the value is the field's name in the record.  Use "-" to skip a
+	//column.
+	Map map[string]string
+	//Strict makes ragged rows an error.  Otherwise, short rows get empty
+	//fields and empty fields past the end of the header row are ignored.
+	//Values past the end of the header row are always an error.
+	Strict bool
+}
+
+//CSVReader reads records from a CSV file, one row at a time.  A UTF-8
+//byte order mark is removed.  Fields are named by the header row and
+//are in header order.  Errors include the line number.
+type CSVReader struct {
+	//Name is used in errors.  OpenCSV sets it to the filename.
+	Name string
+
+	r      *csv.Reader
+	o      CSVOptions
+	header []string
+	fields []string
+	line   int
+	closer io.Closer
+}
+
+//NewCSVReader returns a reader for CSV.  The header row, if any, is read
+//right away.
+func NewCSVReader(r io.Reader, o CSVOptions) (*CSVReader, error) {
+	r, err := decoder(r, o.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	c := CSVReader{Name: "csv", o: o}
+	c.r = csv.NewReader(r)
+	if o.Comma != 0 {
+		c.r.Comma = o.Comma
+	}
+	c.r.FieldsPerRecord = -1
+	c.header = o.Header
+	if len(c.header) == 0 {
+		h, err := c.r.Read()
+		if err == io.EOF {
+			return &c, nil
+		}
+		if err != nil {
+			return nil, c.wrap(err)
+		}
+		c.line, _ = c.r.FieldPos(0)
+		c.header = make([]string, len(h))
+		for i, s := range h {
+			c.header[i] = strings.TrimSpace(s)
+		}
+	}
+	c.fields = make([]string, len(c.header))
+	for i, h := range c.header {
+		c.fields[i] = h
+		if x, ok := o.Map[h]; ok {
+			c.fields[i] = x
+		}
+	}
+	return &c, nil
+}
+
+//OpenCSV opens a CSV file.  Close the reader when you're done.
+func OpenCSV(p string, o CSVOptions) (*CSVReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCSVReader(f, o)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	c.Name = p
+	c.closer = f
+	return c, nil
+}
+
+//Close closes the file opened by OpenCSV.
+func (c *CSVReader) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+//decoder returns a reader that converts an encoding to UTF-8 and removes
+//the byte order mark.
+func decoder(r io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		b := bufio.NewReader(r)
+		if x, err := b.Peek(3); err == nil && string(x) == "\xef\xbb\xbf" {
+			b.Discard(3)
+		}
+		return b, nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder().Reader(r), nil
+	case "latin1", "iso-8859-1":
+		return charmap.ISO8859_1.NewDecoder().Reader(r), nil
+	case "utf-16", "utf16":
+		// Excel's "Unicode text".  The byte order mark says which end
+		// is first.
+		e := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+		return e.NewDecoder().Reader(r), nil
+	}
+	return nil, fmt.Errorf("unknown encoding '%s', use one of %s", encoding, strings.Join(CSVEncodings, ", "))
+}
+
+//wrap adds the name and line number to an error.  Parse errors already
+//have the line number.
+func (c *CSVReader) wrap(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("%s: %v", c.Name, err)
+	}
+	return fmt.Errorf("%s line %d: %v", c.Name, c.line, err)
+}
+
+//Header returns the field names, after mapping, in column order.  Skipped
+//columns are "-".
+func (c *CSVReader) Header() []string {
+	return c.fields
+}
+
+//Line returns the line where the last row started.
+func (c *CSVReader) Line() int {
+	return c.line
+}
+
+//Read returns the next row as a record.  Returns io.EOF at end of data.
+//Blank lines are skipped.
+func (c *CSVReader) Read() (*Record, error) {
+	a, err := c.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, c.wrap(err)
+	}
+	c.line, _ = c.r.FieldPos(0)
+	short := len(a) < len(c.header)
+	long := len(a) > len(c.header) && len(c.o.Header) == 0
+	if c.o.Strict && (short || long) {
+		return nil, c.wrap(fmt.Errorf("%d fields, expected %d", len(a), len(c.header)))
+	}
+	if long {
+		for _, s := range a[len(c.header):] {
+			if len(strings.TrimSpace(s)) != 0 {
+				return nil, c.wrap(fmt.Errorf("%d fields, more than the %d in the header", len(a), len(c.header)))
+			}
+		}
+	}
+	r := NewRecord()
+	for i, f := range c.fields {
+		if f == "-" {
+			continue
+		}
+		v := ""
+		if i < len(a) {
+			v = a[i]
+		}
+		r.Set(f, v)
+	}
+	return r, nil
+}
+
+//Each calls the function with each row.  It stops at end of data or
+//when the function returns an error.
+func (c *CSVReader) Each(fn func(r *Record) error) error {
+	for {
+		r, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(r)
+		if err != nil {
+			return err
+		}
+	}
+}
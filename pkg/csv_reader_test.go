@@ -0,0 +1,114 @@
+package godig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//readCSV returns the rows of a CSV as maps, or the first error.
+func readCSV(s string, o CSVOptions) ([]map[string]string, error) {
+	c, err := NewCSVReader(strings.NewReader(s), o)
+	if err != nil {
+		return nil, err
+	}
+	var a []map[string]string
+	err = c.Each(func(r *Record) error {
+		a = append(a, r.Map())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func TestCSVReader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		o    CSVOptions
+		want []map[string]string
+		err  string
+	}{
+		{"plain", "First_Name,Email\nBob,bob@x.org\n", CSVOptions{},
+			[]map[string]string{{"First_Name": "Bob", "Email": "bob@x.org"}}, ""},
+		{"bom", "\xef\xbb\xbfFirst_Name, Email \nBob,bob@x.org\n", CSVOptions{},
+			[]map[string]string{{"First_Name": "Bob", "Email": "bob@x.org"}}, ""},
+		{"short row", "a,b,c\n1,2\n", CSVOptions{},
+			[]map[string]string{{"a": "1", "b": "2", "c": ""}}, ""},
+		{"long empty row", "a,b\n1,2,,\n", CSVOptions{},
+			[]map[string]string{{"a": "1", "b": "2"}}, ""},
+		{"long row", "a,b\n1,2\n3,4,5\n", CSVOptions{}, nil, "csv line 3: 3 fields, more than the 2 in the header"},
+		{"strict short", "a,b,c\n1,2\n", CSVOptions{Strict: true}, nil, "csv line 2: 2 fields, expected 3"},
+		{"strict long", "a,b\n1,2,\n", CSVOptions{Strict: true}, nil, "csv line 2: 3 fields, expected 2"},
+		{"tabs", "a\tb\n1,5\t2\n", CSVOptions{Comma: '\t'},
+			[]map[string]string{{"a": "1,5", "b": "2"}}, ""},
+		{"windows-1252", "Last_Name\nNu\xf1ez\n", CSVOptions{Encoding: "windows-1252"},
+			[]map[string]string{{"Last_Name": "Nuñez"}}, ""},
+		{"latin1", "Last_Name\nM\xfcller\n", CSVOptions{Encoding: "latin1"},
+			[]map[string]string{{"Last_Name": "Müller"}}, ""},
+		{"utf-16", "\xff\xfea\x00\n\x00\xe9\x00\n\x00", CSVOptions{Encoding: "utf-16"},
+			[]map[string]string{{"a": "é"}}, ""},
+		{"header", "1,2,3\n", CSVOptions{Header: []string{"a", "b"}},
+			[]map[string]string{{"a": "1", "b": "2"}}, ""},
+		{"map", "Email,Notes\nbob@x.org,hi\n", CSVOptions{Map: map[string]string{"Email": "email", "Notes": "-"}},
+			[]map[string]string{{"email": "bob@x.org"}}, ""},
+		{"blank lines", "a\n1\n\n2\n", CSVOptions{},
+			[]map[string]string{{"a": "1"}, {"a": "2"}}, ""},
+		{"empty", "", CSVOptions{}, nil, ""},
+		{"bad encoding", "a\n", CSVOptions{Encoding: "ebcdic"}, nil,
+			"unknown encoding 'ebcdic', use one of utf-8, windows-1252, latin1, utf-16"},
+	}
+	for _, x := range tests {
+		got, err := readCSV(x.in, x.o)
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if msg != x.err {
+			t.Errorf("%s: error %q, want %q", x.name, msg, x.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: %v, want %v", x.name, got, x.want)
+		}
+	}
+}
+
+func TestCSVReaderLine(t *testing.T) {
+	c, err := NewCSVReader(strings.NewReader("a,b\n1,\"two\nlines\"\n3,4\n"), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []int
+	c.Each(func(r *Record) error {
+		lines = append(lines, c.Line())
+		return nil
+	})
+	if !reflect.DeepEqual(lines, []int{2, 4}) {
+		t.Errorf("lines %v, want [2 4]", lines)
+	}
+	if h := c.Header(); !reflect.DeepEqual(h, []string{"a", "b"}) {
+		t.Errorf("header %v", h)
+	}
+}
+
+func TestOpenCSV(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "people.csv")
+	err := os.WriteFile(p, []byte("a,b\n1,2,3\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCSV(p, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Read()
+	if err == nil || !strings.HasPrefix(err.Error(), p+" line 2:") {
+		t.Errorf("error %v doesn't name the file and line", err)
+	}
+}
@@ -0,0 +1,394 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//DedupeFields are the supporter fields that a Deduper reads.
+var DedupeFields = append(append([]string{}, MatchFields...), "Date_Created", "Last_Modified")
+
+//DefaultMaxBucket is the largest bucket that NewDeduper compares.
+const DefaultMaxBucket = 50
+
+//DedupeActivity is a table of a supporter's activity and the field with
+//its date.
+type DedupeActivity struct {
+	Table string
+	Field string
+}
+
+//DefaultDedupeActivity is donations and actions.
+var DefaultDedupeActivity = []DedupeActivity{
+	{Table: "donation", Field: "Transaction_Date"},
+	{Table: "supporter_action", Field: "Date_Created"},
+}
+
+//Deduper finds supporters that are probably the same person.  It reads
+//every supporter that matches the criteria and puts them in buckets by
+//normalized email, by phone number and by name and zip code.  The
+//supporters in each bucket are scored against each other with a
+//Matcher's rules.  Pairs that score at least MinScore are joined into
+//clusters.
+type Deduper struct {
+	Table    *Table
+	Criteria string
+	//Include limits the fields that Salsa returns.
+	Include []string
+	//Matcher has the rules and nicknames that score pairs.  Its blocks
+	//aren't used.
+	Matcher *Matcher
+	//MinScore is the lowest confidence that joins a pair.
+	MinScore float64
+	//MaxBucket skips buckets with more supporters than this.  A phone
+	//number shared by a whole office isn't useful.
+	MaxBucket int
+	//Activity is read for the supporters in clusters.  The survivor is
+	//the one with the latest activity.  Empty doesn't read activity.
+	Activity []DedupeActivity
+	//Progress, if set, counts the supporters that are read.
+	Progress *Progress
+}
+
+//DedupeLink is a pair of supporters that joined a cluster.
+type DedupeLink struct {
+	A, B string
+	//Bucket is how the pair was found, like "email:bob@example.com".
+	Bucket  string
+	Score   float64
+	Reasons []string
+}
+
+//DedupeCluster is a group of supporters that are probably the same
+//person.
+type DedupeCluster struct {
+	ID      int
+	Records []*Record
+	//Activity is the date of each record's latest activity.  It's zero
+	//for records without any.
+	Activity []time.Time
+	//Survivor is the index of the record to keep.
+	Survivor int
+	//Why explains the choice of survivor.
+	Why   string
+	Links []DedupeLink
+}
+
+//DedupeSummary counts what a Deduper did.
+type DedupeSummary struct {
+	Read       int
+	Buckets    int
+	Skipped    int
+	Compared   int
+	Clusters   int
+	Duplicates int
+}
+
+//NewDeduper returns a Deduper for supporters with the default rules.
+func NewDeduper(t *Table) *Deduper {
+	m := NewMatcher(t)
+	return &Deduper{
+		Table:     t,
+		Include:   DedupeFields,
+		Matcher:   m,
+		MinScore:  0.8,
+		MaxBucket: DefaultMaxBucket,
+		Activity:  DefaultDedupeActivity,
+	}
+}
+
+//Run reads the supporters and returns the clusters, largest first.
+//Supporters without duplicates aren't returned.
+//
+//Deduping is traced as a "dedupe" stage.
+func (d *Deduper) Run(ctx context.Context) ([]DedupeCluster, DedupeSummary, error) {
+	ctx, span := Stage(ctx, "dedupe "+d.Table.Name,
+		attribute.String("salsa.table", d.Table.Name),
+		attribute.String("salsa.criteria", d.Criteria))
+	clusters, sum, err := d.run(ctx)
+	EndStage(span, err)
+	return clusters, sum, err
+}
+
+//run does the work for Run.
+func (d *Deduper) run(ctx context.Context) ([]DedupeCluster, DedupeSummary, error) {
+	var sum DedupeSummary
+	pk := d.Table.Name + "_KEY"
+	var records []*Record
+	buckets := make(map[string][]int)
+	q := Query{
+		Criteria: d.Criteria,
+		Include:  d.Include,
+		OrderBy:  []string{pk},
+		Progress: d.Progress,
+	}
+	err := d.Table.Scan(ctx, q, func(page []*Record) error {
+		for _, r := range page {
+			i := len(records)
+			records = append(records, r)
+			for _, k := range DedupeBuckets(r) {
+				buckets[k] = append(buckets[k], i)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, sum, err
+	}
+	sum.Read = len(records)
+
+	// Buckets are compared in order so that runs are repeatable.
+	var names []string
+	for k, a := range buckets {
+		if len(a) > 1 {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	u := newUnionFind(len(records))
+	var links []DedupeLink
+	for _, k := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, sum, err
+		}
+		a := buckets[k]
+		if d.MaxBucket > 0 && len(a) > d.MaxBucket {
+			sum.Skipped++
+			continue
+		}
+		sum.Buckets++
+		for i := 0; i < len(a); i++ {
+			for j := i + 1; j < len(a); j++ {
+				if u.find(a[i]) == u.find(a[j]) {
+					continue
+				}
+				sum.Compared++
+				x, y := records[a[i]], records[a[j]]
+				m := d.Matcher.Score(x, y)
+				if m.Score < d.MinScore {
+					continue
+				}
+				u.union(a[i], a[j])
+				links = append(links, DedupeLink{A: x.Get(pk), B: y.Get(pk), Bucket: k, Score: m.Score, Reasons: m.Reasons})
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	var keys []string
+	for i := range records {
+		root := u.find(i)
+		groups[root] = append(groups[root], i)
+	}
+	for _, g := range groups {
+		if len(g) > 1 {
+			for _, i := range g {
+				keys = append(keys, records[i].Get(pk))
+			}
+		}
+	}
+	activity, err := d.activity(ctx, keys)
+	if err != nil {
+		return nil, sum, err
+	}
+	index := make(map[string]int)
+	var clusters []DedupeCluster
+	for _, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		c := DedupeCluster{}
+		for _, i := range g {
+			k := records[i].Get(pk)
+			index[k] = len(clusters)
+			c.Records = append(c.Records, records[i])
+			c.Activity = append(c.Activity, activity[k])
+		}
+		c.Survivor, c.Why = Survivor(c.Records, pk, d.Include, c.Activity)
+		clusters = append(clusters, c)
+		sum.Duplicates += len(g) - 1
+	}
+	for _, l := range links {
+		i := index[l.A]
+		clusters[i].Links = append(clusters[i].Links, l)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		a, b := clusters[i], clusters[j]
+		if len(a.Records) != len(b.Records) {
+			return len(a.Records) > len(b.Records)
+		}
+		return a.Records[0].Get(pk) < b.Records[0].Get(pk)
+	})
+	for i := range clusters {
+		clusters[i].ID = i + 1
+	}
+	sum.Clusters = len(clusters)
+	return clusters, sum, nil
+}
+
+//activity returns the date of the latest activity for each supporter.
+func (d *Deduper) activity(ctx context.Context, keys []string) (map[string]time.Time, error) {
+	m := make(map[string]time.Time)
+	pk := d.Table.Name + "_KEY"
+	for _, x := range d.Activity {
+		t := d.Table.API.NewTable(x.Table)
+		f := Finder{Table: &t, Fields: []string{pk}, Include: []string{x.Field}}
+		for _, q := range f.Queries(keys) {
+			err := t.Scan(ctx, q, func(page []*Record) error {
+				for _, r := range page {
+					w, err := ParseTime(r.Get(x.Field))
+					k := r.Get(pk)
+					if err == nil && w.After(m[k]) {
+						m[k] = w
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return m, fmt.Errorf("dedupe: reading %s: %v", x.Table, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+//DedupeBuckets returns the buckets that a supporter goes in: normalized
+//email, each ten-digit phone number, and first name, last name and zip
+//code.
+func DedupeBuckets(r *Record) []string {
+	var a []string
+	if x := NormalizeEmail(r.Get("Email")); len(x) != 0 {
+		a = append(a, "email:"+x)
+	}
+	seen := make(map[string]bool)
+	for _, x := range phones(r) {
+		if len(x) == 10 && !seen[x] {
+			seen[x] = true
+			a = append(a, "phone:"+x)
+		}
+	}
+	first, last := NormalizeName(r.Get("First_Name")), NormalizeName(r.Get("Last_Name"))
+	zip := NormalizeZip(r.Get("Zip"))
+	if len(first) != 0 && len(last) != 0 && len(zip) != 0 {
+		a = append(a, "name:"+first+" "+last+" "+zip)
+	}
+	return a
+}
+
+//Survivor returns the index of the record to keep from a cluster, and
+//why.  Activity has the date of each record's latest activity, like a
+//donation, and can be empty.  The survivor has the latest activity.  Ties
+//go to the record with the most fields filled in, then to the most
+//recently modified record, then to the oldest key.
+func Survivor(records []*Record, pk string, fields []string, activity []time.Time) (int, string) {
+	type rank struct {
+		active   time.Time
+		n        int
+		modified time.Time
+		key      string
+	}
+	ranks := make([]rank, len(records))
+	for i, r := range records {
+		ranks[i].n = completeness(r, fields)
+		ranks[i].modified, _ = ParseTime(r.Get("Last_Modified"))
+		ranks[i].key = r.Get(pk)
+		if i < len(activity) {
+			ranks[i].active = activity[i]
+		}
+	}
+	better := func(a, b rank) bool {
+		switch {
+		case !a.active.Equal(b.active):
+			return a.active.After(b.active)
+		case a.n != b.n:
+			return a.n > b.n
+		case !a.modified.Equal(b.modified):
+			return a.modified.After(b.modified)
+		}
+		return olderKey(a.key, b.key)
+	}
+	best := 0
+	for i := range ranks {
+		if better(ranks[i], ranks[best]) {
+			best = i
+		}
+	}
+	x := ranks[best]
+	var why []string
+	if !x.active.IsZero() {
+		why = append(why, "active "+x.active.Format("2006-01-02"))
+	}
+	why = append(why, fmt.Sprintf("%d of %d fields", x.n, len(fields)))
+	if !x.modified.IsZero() {
+		why = append(why, "modified "+x.modified.Format("2006-01-02"))
+	}
+	return best, strings.Join(why, ", ")
+}
+
+//olderKey returns true if key a is older than key b.  Salsa's keys are
+//numbers that grow.
+func olderKey(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return x < y
+}
+
+//completeness returns the number of fields that aren't empty.
+func completeness(r *Record, fields []string) int {
+	n := 0
+	for _, f := range fields {
+		if len(strings.TrimSpace(r.Get(f))) != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+//unionFind is a disjoint set forest with path compression and union by
+//rank.
+type unionFind struct {
+	parent []int
+	rank   []int
+}
+
+//newUnionFind returns n sets of one.
+func newUnionFind(n int) *unionFind {
+	u := unionFind{parent: make([]int, n), rank: make([]int, n)}
+	for i := range u.parent {
+		u.parent[i] = i
+	}
+	return &u
+}
+
+//find returns the root of the set that i is in.
+func (u *unionFind) find(i int) int {
+	for u.parent[i] != i {
+		u.parent[i] = u.parent[u.parent[i]]
+		i = u.parent[i]
+	}
+	return i
+}
+
+//union joins the sets that i and j are in.
+func (u *unionFind) union(i, j int) {
+	a, b := u.find(i), u.find(j)
+	if a == b {
+		return
+	}
+	if u.rank[a] < u.rank[b] {
+		a, b = b, a
+	}
+	u.parent[b] = a
+	if u.rank[a] == u.rank[b] {
+		u.rank[a]++
+	}
+}
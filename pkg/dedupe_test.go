@@ -0,0 +1,120 @@
+package godig
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDedupeBuckets(t *testing.T) {
+	tests := []struct {
+		in   *Record
+		want []string
+	}{
+		{matchRecord("Email", "Bob.Smith+x@Gmail.com"), []string{"email:bobsmith@gmail.com"}},
+		{matchRecord("Phone", "(555) 123-4567", "Cell_Phone", "1-555-123-4567", "Work_Phone", "123-4567"),
+			[]string{"phone:5551234567"}},
+		{matchRecord("First_Name", "José", "Last_Name", "O'Brien", "Zip", "12345-6789"), []string{"name:jose obrien 12345"}},
+		{matchRecord("First_Name", "Bob", "Last_Name", "Smith"), nil},
+		{matchRecord("Email", "not an email"), nil},
+	}
+	for _, x := range tests {
+		if got := DedupeBuckets(x.in); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("DedupeBuckets(%v) = %q, want %q", x.in.Map(), got, x.want)
+		}
+	}
+}
+
+func TestUnionFind(t *testing.T) {
+	u := newUnionFind(6)
+	u.union(0, 1)
+	u.union(2, 3)
+	u.union(1, 3)
+	u.union(4, 4)
+	for _, i := range []int{1, 2, 3} {
+		if u.find(i) != u.find(0) {
+			t.Errorf("%d isn't with 0", i)
+		}
+	}
+	for _, i := range []int{4, 5} {
+		if u.find(i) == u.find(0) || u.find(i) != i {
+			t.Errorf("%d was joined", i)
+		}
+	}
+}
+
+func TestSurvivor(t *testing.T) {
+	fields := []string{"First_Name", "Last_Name", "Email", "Phone"}
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	full := func(key, modified string) *Record {
+		return matchRecord("supporter_KEY", key, "First_Name", "Bob", "Last_Name", "Smith", "Email", "b@x.org",
+			"Phone", "5551234567", "Last_Modified", modified)
+	}
+	sparse := func(key, modified string) *Record {
+		return matchRecord("supporter_KEY", key, "First_Name", "Bob", "Last_Modified", modified)
+	}
+	tests := []struct {
+		name     string
+		records  []*Record
+		activity []time.Time
+		want     int
+		why      string
+	}{
+		{"activity wins", []*Record{full("1", ""), sparse("2", "")}, []time.Time{day(1), day(2)}, 1,
+			"active 2024-01-02, 1 of 4 fields"},
+		{"no activity", []*Record{sparse("1", ""), full("2", "")}, nil, 1, "4 of 4 fields"},
+		{"more fields", []*Record{sparse("1", ""), full("2", "")}, []time.Time{day(3), day(3)}, 1,
+			"active 2024-01-03, 4 of 4 fields"},
+		{"newer", []*Record{full("1", "2023-01-01 10:00:00"), full("2", "2023-06-01 10:00:00")}, nil, 1,
+			"4 of 4 fields, modified 2023-06-01"},
+		{"oldest key", []*Record{full("20", ""), full("9", ""), full("100", "")}, nil, 1, "4 of 4 fields"},
+	}
+	for _, x := range tests {
+		got, why := Survivor(x.records, "supporter_KEY", fields, x.activity)
+		if got != x.want || why != x.why {
+			t.Errorf("%s: %d %q, want %d %q", x.name, got, why, x.want, x.why)
+		}
+	}
+}
+
+func TestDeduperRun(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.put("supporter", "1", "First_Name", "Robert", "Last_Name", "Smith", "Email", "bob.smith@gmail.com")
+	f.put("supporter", "2", "First_Name", "Bob", "Last_Name", "Smith", "Email", "bobsmith+news@gmail.com", "Zip", "12345")
+	f.put("supporter", "3", "First_Name", "Bobby", "Last_Name", "Smith", "Phone", "555-123-4567", "Zip", "12345")
+	f.put("supporter", "4", "First_Name", "Robert", "Last_Name", "Smith", "Phone", "5551234567", "Email", "rs@example.com", "Zip", "12345")
+	f.put("supporter", "5", "First_Name", "Amy", "Last_Name", "Jones", "Zip", "12345")
+	f.put("supporter", "6", "First_Name", "Amy", "Last_Name", "Jones", "Zip", "12345")
+	f.put("supporter", "7", "Last_Name", "Smith", "Zip", "12345")
+	f.put("donation", "100", "supporter_KEY", "3", "Transaction_Date", "2024-03-01 10:00:00")
+	f.put("supporter_action", "200", "supporter_KEY", "5", "Date_Created", "2022-01-01 10:00:00")
+	tb := a.Supporter()
+	d := NewDeduper(&tb)
+	clusters, sum, err := d.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	var survivors []string
+	for _, c := range clusters {
+		var keys []string
+		for _, r := range c.Records {
+			keys = append(keys, r.Get("supporter_KEY"))
+		}
+		got = append(got, keys)
+		survivors = append(survivors, c.Records[c.Survivor].Get("supporter_KEY"))
+	}
+	want := [][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clusters %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(survivors, []string{"2", "3", "5"}) {
+		t.Errorf("survivors %v, want [2 3 5]", survivors)
+	}
+	if sum.Read != 7 || sum.Clusters != 3 || sum.Duplicates != 3 {
+		t.Errorf("summary %+v", sum)
+	}
+}
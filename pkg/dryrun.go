@@ -0,0 +1,145 @@
+package godig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//dryRunMessage appears in the messages of synthetic results.
+const dryRunMessage = "dry run, nothing changed"
+
+//Action is a change that a dry run would have made.
+type Action struct {
+	Op     string
+	Table  string
+	Key    string
+	Fields map[string]string
+}
+
+//Rehearsal records the changes that would have been made while an API
+//is in dry-run mode.  It's safe to use from many goroutines.
+type Rehearsal struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+//Actions returns a copy of the recorded actions.
+func (r *Rehearsal) Actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a := make([]Action, len(r.actions))
+	copy(a, r.actions)
+	return a
+}
+
+//Counts returns the number of actions by operation and table, for
+//example "delete supporter".
+func (r *Rehearsal) Counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]int)
+	for _, a := range r.actions {
+		m[a.Op+" "+a.Table]++
+	}
+	return m
+}
+
+//Write writes a summary of the rehearsal counts.
+func (r *Rehearsal) Write(w io.Writer) error {
+	m := r.Counts()
+	var a []string
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	if len(a) == 0 {
+		_, err := fmt.Fprintln(w, "Dry run: no changes would have been made")
+		return err
+	}
+	_, err := fmt.Fprintln(w, "Dry run: these changes would have been made")
+	for _, k := range a {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%8d %s\n", m[k], k)
+		}
+	}
+	return err
+}
+
+//record adds an action to the rehearsal.
+func (r *Rehearsal) record(a Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, a)
+}
+
+//rehearse logs and records a change instead of making it.
+func (a *API) rehearse(x Action) {
+	if len(x.Fields) == 0 {
+		log.Printf("Dry run: %s %s key %s\n", x.Op, x.Table, x.Key)
+	} else {
+		log.Printf("Dry run: %s %s key %s %v\n", x.Op, x.Table, x.Key, x.Fields)
+	}
+	if a.Rehearsal != nil {
+		a.Rehearsal.record(x)
+	}
+}
+
+//rehearseDelete handles Delete in dry-run mode.  The target gets a
+//synthetic successful result.
+func (t *Table) rehearseDelete(key string, target interface{}) error {
+	t.rehearse(Action{Op: "delete", Table: t.Name, Key: key})
+	r := DeleteStatus{
+		Object:   t.Name,
+		Key:      key,
+		Result:   "success",
+		Messages: []string{dryRunMessage},
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target)
+}
+
+//rehearseSave handles SaveBulk in dry-run mode.  The body is decoded to
+//find the table, key and fields.  Returns a synthetic successful result.
+func (t *Table) rehearseSave(s string) ([]byte, error) {
+	v, err := url.ParseQuery(strings.TrimPrefix(s, "&"))
+	if err != nil {
+		return nil, err
+	}
+	x := Action{
+		Op:     "save",
+		Table:  v.Get("object"),
+		Key:    v.Get("key"),
+		Fields: make(map[string]string),
+	}
+	if len(x.Table) == 0 {
+		x.Table = t.Name
+	}
+	if len(x.Key) == 0 {
+		x.Key = "0"
+	}
+	if x.Key == "0" {
+		x.Op = "insert"
+	}
+	for k := range v {
+		if k != "object" && k != "key" {
+			x.Fields[k] = strings.Join(v[k], ",")
+		}
+	}
+	t.rehearse(x)
+	r := []Results{{
+		Object:   x.Table,
+		Key:      x.Key,
+		Result:   "success",
+		Messages: []string{dryRunMessage},
+	}}
+	return json.Marshal(r)
+}
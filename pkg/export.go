@@ -0,0 +1,502 @@
+package godig
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//ExportFormats are the formats that Exporter can write.
+var ExportFormats = []string{"csv", "jsonl", "parquet"}
+
+//Exporter writes every record that matches a query to CSV, JSON Lines
+//or Parquet.  Pages are written as they arrive, so memory use doesn't
+//grow with the size of the table.
+type Exporter struct {
+	Table *Table
+	Query Query
+	//Fields are the columns, in order.  Empty means every field, in the
+	//order that Describe returns them.  Joins can't be described, so an
+	//empty list uses the fields in the first record, in Salsa's order.
+	Fields []string
+	//Format is "csv", "jsonl" or "parquet".
+	Format string
+	//Gzip compresses CSV and JSON Lines output.  Parquet output uses
+	//gzip for its column chunks.
+	Gzip bool
+	//SplitRows starts a new output file after this many records.  Zero
+	//writes one file.  Split files are named like "base-0001.csv".
+	SplitRows int
+	//Path is the output filename.  "-" writes to Stdout.
+	Path string
+	//Stdout receives the output when Path is "-".  Default is os.Stdout.
+	Stdout io.Writer
+	//Lookups add fields from other tables to each record.  The fields
+	//go after the table's fields unless they're in Fields.
+	Lookups []*Lookup
+}
+
+//ExportSummary describes the files written by an export.
+type ExportSummary struct {
+	Rows  int
+	Files []string
+}
+
+//Ext returns the filename extension for the exporter's format and
+//compression, for example ".csv.gz".
+func (x *Exporter) Ext() string {
+	e := "." + x.Format
+	if x.Gzip && x.Format != "parquet" {
+		e = e + ".gz"
+	}
+	return e
+}
+
+//Run reads every matching record and writes it.  Run stops at end of
+//data, on an error or when the context is cancelled.
+//
+//If the query has a checkpoint, the export's files are recorded in it
+//after each page.  A checkpoint with progress resumes the export.  CSV
+//and JSON Lines files are cut back to the last checkpoint and appended
+//to.  Parquet files can't be appended to, so the last one is rewritten.
+func (x *Exporter) Run(ctx context.Context) (ExportSummary, error) {
+	ctx, span := Stage(ctx, "export "+x.Table.Name,
+		attribute.String("salsa.table", x.Table.Name),
+		attribute.String("export.format", x.Format))
+	sum, err := x.run(ctx)
+	EndStage(span, err)
+	return sum, err
+}
+
+//run does the work for Run.
+func (x *Exporter) run(ctx context.Context) (ExportSummary, error) {
+	var sum ExportSummary
+	cp := x.Query.Checkpoint
+	if x.Path == "-" && x.SplitRows > 0 {
+		return sum, errors.New("can't split output written to stdout")
+	}
+	if x.Path == "-" && cp != nil {
+		return sum, errors.New("can't checkpoint output written to stdout")
+	}
+	resume := cp != nil && cp.Resuming()
+	columns := x.Fields
+	if resume && len(cp.Columns) != 0 {
+		columns = cp.Columns
+	}
+	if len(columns) == 0 && !x.Table.IsJoin() {
+		f, err := x.Table.Describe()
+		if err != nil {
+			return sum, err
+		}
+		for _, d := range f {
+			columns = append(columns, d.Name)
+		}
+	}
+	if len(x.Fields) == 0 && len(columns) != 0 {
+		for _, l := range x.Lookups {
+			columns = append(columns, l.Names()...)
+		}
+	}
+	q := x.Query
+	if len(x.Fields) != 0 {
+		q.Include = x.include()
+	}
+
+	var w exportWriter
+	defer func() {
+		if w != nil {
+			w.Close()
+		}
+	}()
+	// n counts the records in the current file.  start is the offset of
+	// the file's first record.  pos is the offset of the next record.
+	n := 0
+	start := q.Offset
+	pos := q.Offset
+	if resume {
+		sum.Files = append(sum.Files, cp.Files...)
+		if k := len(sum.Files); k != 0 {
+			if cp.Position < 0 {
+				cp.Offset = cp.FileStart
+				sum.Files = sum.Files[:k-1]
+			} else {
+				var err error
+				w, err = x.create(sum.Files[k-1], columns, cp.Position)
+				if err != nil {
+					return sum, err
+				}
+				n = cp.FileRows
+				start = cp.FileStart
+			}
+		}
+		pos = cp.Offset
+		sum.Rows = int(cp.Offset)
+	}
+	err := x.Table.Scan(ctx, q, func(page []*Record) error {
+		for _, l := range x.Lookups {
+			err := l.Enrich(ctx, page)
+			if err != nil {
+				return err
+			}
+		}
+		if len(columns) == 0 {
+			columns = append(columns, page[0].Names()...)
+		}
+		for _, r := range page {
+			if w == nil || x.SplitRows > 0 && n == x.SplitRows {
+				if w != nil {
+					err := w.Close()
+					w = nil
+					if err != nil {
+						return err
+					}
+				}
+				p := x.Path
+				if x.SplitRows > 0 {
+					p = splitName(p, len(sum.Files)+1)
+				}
+				var err error
+				w, err = x.create(p, columns, 0)
+				if err != nil {
+					return err
+				}
+				sum.Files = append(sum.Files, p)
+				n = 0
+				start = pos
+			}
+			err := w.Write(r.Values(columns))
+			if err != nil {
+				return err
+			}
+			n++
+			pos++
+			sum.Rows++
+		}
+		if cp != nil {
+			size, err := w.Sync()
+			if err != nil {
+				return err
+			}
+			cp.Columns = columns
+			cp.Files = sum.Files
+			cp.FileStart = start
+			cp.FileRows = n
+			cp.Position = size
+		}
+		return nil
+	})
+	if err == nil && w == nil && len(sum.Files) == 0 && len(columns) != 0 {
+		// Nothing matched.  Write an empty file so that the output exists.
+		p := x.Path
+		if x.SplitRows > 0 {
+			p = splitName(p, 1)
+		}
+		w, err = x.create(p, columns, 0)
+		sum.Files = append(sum.Files, p)
+	}
+	if err == nil && w != nil {
+		err = w.Close()
+		w = nil
+	}
+	return sum, err
+}
+
+//include returns the fields to read from Salsa.  Those are the fields
+//to export, without the ones that lookups add, and with the lookups'
+//keys.
+func (x *Exporter) include() []string {
+	added := make(map[string]bool)
+	for _, l := range x.Lookups {
+		for _, n := range l.Names() {
+			added[n] = true
+		}
+	}
+	var a []string
+	have := make(map[string]bool)
+	for _, f := range x.Fields {
+		if !added[f] {
+			a = append(a, f)
+			have[f] = true
+		}
+	}
+	for _, l := range x.Lookups {
+		if !have[l.Key] {
+			a = append(a, l.Key)
+			have[l.Key] = true
+		}
+	}
+	return a
+}
+
+//create opens an output file and returns a writer for the exporter's
+//format.  If size is more than zero, the file is cut back to that size
+//and appended to.  Otherwise, the file is created and starts with a
+//header.
+func (x *Exporter) create(p string, columns []string, size int64) (exportWriter, error) {
+	var f io.WriteCloser
+	switch {
+	case p == "-":
+		f = nopWriteCloser{x.Stdout}
+		if x.Stdout == nil {
+			f = nopWriteCloser{os.Stdout}
+		}
+	case size > 0:
+		g, err := os.OpenFile(p, os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = g.Truncate(size)
+		if err == nil {
+			_, err = g.Seek(size, io.SeekStart)
+		}
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		f = g
+	default:
+		g, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		f = g
+	}
+	switch x.Format {
+	case "parquet":
+		return newParquetWriter(f, columns, x.Gzip)
+	case "jsonl":
+		return &jsonlWriter{t: newTextFile(f, size, x.Gzip), columns: columns}, nil
+	case "csv", "":
+		t := newTextFile(f, size, x.Gzip)
+		c := &csvWriter{w: csv.NewWriter(t.writer()), t: t}
+		if size > 0 {
+			return c, nil
+		}
+		return c, c.w.Write(columns)
+	}
+	f.Close()
+	return nil, fmt.Errorf("unknown output format '%s'", x.Format)
+}
+
+//splitName returns the name of the nth split file, for example
+//"supporter-0001.csv.gz" for "supporter.csv.gz".
+func splitName(p string, n int) string {
+	d, b := filepath.Split(p)
+	ext := ""
+	if i := strings.Index(b, "."); i > 0 {
+		b, ext = b[:i], b[i:]
+	}
+	return fmt.Sprintf("%s%s-%04d%s", d, b, n, ext)
+}
+
+//exportWriter writes rows to one output file.
+type exportWriter interface {
+	Write(row []string) error
+	//Sync writes buffered rows and returns the size of the file.  -1
+	//means that the file can't be appended to.
+	Sync() (int64, error)
+	Close() error
+}
+
+//nopWriteCloser keeps the exporter from closing stdout.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+//Close implements io.Closer.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+//countWriter counts the bytes written.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+//Write implements io.Writer.
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+//textFile is an output file for CSV or JSON Lines.  Compressed files are
+//written as a series of gzip members, one for each sync, so that an
+//export can be resumed by appending to them.
+type textFile struct {
+	f io.WriteCloser
+	c *countWriter
+	z *gzip.Writer
+	b *bufio.Writer
+}
+
+//newTextFile returns a textFile that starts at size bytes.
+func newTextFile(f io.WriteCloser, size int64, gz bool) *textFile {
+	t := &textFile{f: f, c: &countWriter{w: f, n: size}}
+	if gz {
+		t.z = gzip.NewWriter(t.c)
+		t.b = bufio.NewWriter(t.z)
+	} else {
+		t.b = bufio.NewWriter(t.c)
+	}
+	return t
+}
+
+//writer returns the writer for rows.
+func (t *textFile) writer() io.Writer {
+	return t.b
+}
+
+//sync writes buffered data and returns the size of the file.
+func (t *textFile) sync() (int64, error) {
+	err := t.b.Flush()
+	if err != nil {
+		return 0, err
+	}
+	if t.z != nil {
+		err = t.z.Close()
+		if err != nil {
+			return 0, err
+		}
+		t.z.Reset(t.c)
+	}
+	return t.c.n, nil
+}
+
+//close writes buffered data and closes the file.
+func (t *textFile) close() error {
+	err := t.b.Flush()
+	if t.z != nil {
+		if e := t.z.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := t.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+//csvWriter writes rows as CSV.
+type csvWriter struct {
+	w *csv.Writer
+	t *textFile
+}
+
+//Write implements exportWriter.
+func (c *csvWriter) Write(row []string) error {
+	return c.w.Write(row)
+}
+
+//Sync implements exportWriter.
+func (c *csvWriter) Sync() (int64, error) {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return 0, err
+	}
+	return c.t.sync()
+}
+
+//Close implements exportWriter.
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	err := c.w.Error()
+	if e := c.t.close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+//jsonlWriter writes rows as JSON objects, one per line.  Fields are
+//written in column order.
+type jsonlWriter struct {
+	t       *textFile
+	columns []string
+}
+
+//Write implements exportWriter.
+func (j *jsonlWriter) Write(row []string) error {
+	r := NewRecord()
+	for i, c := range j.columns {
+		r.Set(c, row[i])
+	}
+	b, err := r.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = j.t.writer().Write(b)
+	return err
+}
+
+//Sync implements exportWriter.
+func (j *jsonlWriter) Sync() (int64, error) {
+	return j.t.sync()
+}
+
+//Close implements exportWriter.
+func (j *jsonlWriter) Close() error {
+	return j.t.close()
+}
+
+//parquetWriter writes rows to a Parquet file.  Every column is an
+//optional UTF-8 string, since that's what Salsa returns.
+type parquetWriter struct {
+	w *writer.CSVWriter
+	f io.Closer
+}
+
+//newParquetWriter returns a Parquet writer for the columns.
+func newParquetWriter(f io.WriteCloser, columns []string, gz bool) (*parquetWriter, error) {
+	var md []string
+	for _, c := range columns {
+		md = append(md, fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", c))
+	}
+	w, err := writer.NewCSVWriter(md, writerfile.NewWriterFile(f), 1)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+	if gz {
+		w.CompressionType = parquet.CompressionCodec_GZIP
+	}
+	return &parquetWriter{w: w, f: f}, nil
+}
+
+//Write implements exportWriter.
+func (p *parquetWriter) Write(row []string) error {
+	a := make([]*string, len(row))
+	for i := range row {
+		a[i] = &row[i]
+	}
+	return p.w.WriteString(a)
+}
+
+//Sync implements exportWriter.  Parquet files are written when they're
+//closed, so they can't be appended to.
+func (p *parquetWriter) Sync() (int64, error) {
+	return -1, nil
+}
+
+//Close implements exportWriter.
+func (p *parquetWriter) Close() error {
+	err := p.w.WriteStop()
+	if e := p.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
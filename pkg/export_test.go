@@ -0,0 +1,134 @@
+package godig
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//readExport returns the contents of export files, uncompressed.
+func readExport(t *testing.T, files []string, gz bool) []string {
+	var a []string
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if gz {
+			z, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = z
+		}
+		b, err := ioutil.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		a = append(a, string(b))
+	}
+	return a
+}
+
+func TestExportResume(t *testing.T) {
+	tests := []struct {
+		format string
+		gzip   bool
+		split  int
+	}{
+		{"csv", true, 0},
+		{"csv", true, 300},
+		{"csv", false, 300},
+		{"jsonl", false, 0},
+		{"jsonl", false, 300},
+		{"jsonl", true, 300},
+	}
+	for _, x := range tests {
+		for _, fail := range []string{"read", "checkpoint"} {
+			name := fmt.Sprintf("%s gzip %v split %d, %s fails", x.format, x.gzip, x.split, fail)
+			exportResume(t, name, x.format, x.gzip, x.split, fail)
+		}
+	}
+}
+
+//exportResume interrupts an export on its third page and resumes it.
+//Either the page can't be read, or it's written but the checkpoint can't
+//be saved.  The resumed files must be the same as an export that wasn't
+//interrupted.  That export runs last so that it sees the same records.
+func exportResume(t *testing.T, name, format string, gz bool, split int, fail string) {
+	f, a := newFakeSalsa(t)
+	for i := 1; i <= 2*PageSize+300; i++ {
+		f.put("supporter", fmt.Sprint(i), "Email", fmt.Sprintf("s%d@example.com", i), "First_Name", "Bob, \"Jr\"")
+	}
+	tb := a.Supporter()
+	q := Query{OrderBy: []string{"supporter_KEY"}}
+	run := func(p string, cp *Checkpoint) (ExportSummary, error) {
+		q := q
+		q.Checkpoint = cp
+		e := Exporter{Table: &tb, Query: q, Fields: []string{"supporter_KEY", "Email", "First_Name"},
+			Format: format, Gzip: gz, SplitRows: split, Path: p}
+		return e.Run(context.Background())
+	}
+	dir := t.TempDir()
+	p := filepath.Join(dir, "resumed."+format)
+	cpDir := filepath.Join(dir, "checkpoint")
+	cpPath := filepath.Join(cpDir, "resumed.checkpoint.json")
+	if err := os.Mkdir(cpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var saved []byte
+	f.broken = func(r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Query().Get("limit"), fmt.Sprint(2*PageSize)+",") {
+			return false
+		}
+		if fail == "read" {
+			return true
+		}
+		// The page is read and written, but the checkpoint after it
+		// can't be saved.
+		saved, _ = ioutil.ReadFile(cpPath)
+		os.RemoveAll(cpDir)
+		return false
+	}
+	if _, err := run(p, NewCheckpoint(cpPath, &tb, q)); err == nil {
+		t.Fatalf("%s: export didn't fail", name)
+	}
+	f.broken = nil
+	if fail == "checkpoint" {
+		os.Mkdir(cpDir, 0755)
+		if err := ioutil.WriteFile(cpPath, saved, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The third page changes before the export is resumed.  Anything
+	// written past the checkpoint has to go.
+	f.put("supporter", fmt.Sprint(2*PageSize+1), "Email", "x@example.com")
+	cp, err := LoadCheckpoint(cpPath, &tb, q)
+	if err != nil || cp.Offset != 2*PageSize {
+		t.Fatalf("%s: checkpoint at %d, %v", name, cp.Offset, err)
+	}
+	resumed, err := run(p, cp)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	whole, err := run(filepath.Join(dir, "whole."+format), nil)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if resumed.Rows != whole.Rows || len(resumed.Files) != len(whole.Files) {
+		t.Fatalf("%s: resumed %d rows in %v, want %d in %v", name, resumed.Rows, resumed.Files, whole.Rows, whole.Files)
+	}
+	if got, want := readExport(t, resumed.Files, gz), readExport(t, whole.Files, gz); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: resumed export is different", name)
+	}
+}
@@ -0,0 +1,330 @@
+package godig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//MaxURLLength is the longest URL that Finder sends.  Salsa's servers
+//refuse longer ones.
+const MaxURLLength = 2000
+
+//Finder looks up records by the values of one or more fields, many
+//values at a time.  Values are put into "IN" conditions, a chunk of keys
+//at a time, instead of reading once for each key.  Chunks are read at
+//the same time.
+//
+//A key is a list of values, one for each field.  Keys with more than
+//one field, like a last name and a first name, are read with an "IN"
+//condition for each field.  The records that come back are matched to
+//the keys here.  Matching ignores case and spaces at either end.
+type Finder struct {
+	Table *Table
+	//Fields are compared with the keys' values.  Joins use
+	//"table.Field" names.
+	Fields []string
+	//Include limits the fields that Salsa returns.  Empty returns all
+	//fields.  The Fields are always returned.
+	Include []string
+	//Criteria are added to each read.
+	Criteria string
+	//Workers is the number of chunks read at the same time.
+	Workers int
+	//MaxValues is the largest number of keys in a chunk.  The default is
+	//100.
+	MaxValues int
+	//MaxURL is the longest URL for a chunk.  The default is MaxURLLength.
+	MaxURL int
+	//Progress, if set, counts the keys that have been looked up.
+	Progress *Progress
+}
+
+//FindResult is what a Finder found for a key.
+type FindResult struct {
+	Key     []string
+	Records []*Record
+	//Err is set if the key couldn't be looked up.
+	Err error
+}
+
+//FindSummary counts the keys that a Finder looked up.
+type FindSummary struct {
+	Keys     int
+	Matched  int
+	NoMatch  int
+	Multiple int
+	Errors   int
+}
+
+//Run looks up the keys.  The function sees the result for each key, in
+//the order that they're found.  It's never called by more than one
+//goroutine at a time.  Run stops on read errors, when the function
+//returns an error or when the context is cancelled.
+//
+//The lookup is traced as a "find" stage.  Each chunk's reads are spans
+//inside it.
+func (f *Finder) Run(ctx context.Context, keys [][]string, fn func(r FindResult) error) (FindSummary, error) {
+	ctx, span := Stage(ctx, "find "+f.Table.Name,
+		attribute.String("salsa.table", f.Table.Name),
+		attribute.StringSlice("find.fields", f.Fields),
+		attribute.Int("find.keys", len(keys)))
+	sum, err := f.run(ctx, keys, fn)
+	EndStage(span, err)
+	return sum, err
+}
+
+//run does the work for Run.
+func (f *Finder) run(ctx context.Context, keys [][]string, fn func(r FindResult) error) (FindSummary, error) {
+	var sum FindSummary
+	if len(f.Fields) == 0 {
+		return sum, errors.New("find: no fields")
+	}
+	f.Progress.Start(f.Table.Name, len(keys), 0)
+	defer f.Progress.Finish()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var first error
+	report := func(r FindResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first != nil {
+			return
+		}
+		sum.Keys++
+		switch {
+		case r.Err != nil:
+			sum.Errors++
+		case len(r.Records) == 0:
+			sum.NoMatch++
+		case len(r.Records) == 1:
+			sum.Matched++
+		default:
+			sum.Multiple++
+		}
+		f.Progress.Add(1)
+		if err := fn(r); err != nil {
+			first = err
+			cancel()
+		}
+	}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first == nil {
+			first = err
+			cancel()
+		}
+	}
+
+	chunks := make(chan [][]string)
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				err := f.chunk(ctx, c, report)
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	var simple [][]string
+	for _, k := range keys {
+		if len(k) != len(f.Fields) {
+			report(FindResult{Key: k, Err: fmt.Errorf("%d values, expected %d", len(k), len(f.Fields))})
+			continue
+		}
+		if err := findable(k); err != nil {
+			report(FindResult{Key: k, Err: err})
+			continue
+		}
+		simple = append(simple, k)
+	}
+	for _, c := range f.chunks(simple) {
+		select {
+		case chunks <- c:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if first == nil {
+		first = ctx.Err()
+	}
+	return sum, first
+}
+
+//findable returns an error for values that can't be put into a URL as a
+//condition.  Empty values can't be told apart in an "IN" list.  FixCrit
+//encodes everything else except an "&" that starts a parameter.
+func findable(k []string) error {
+	for _, v := range k {
+		if len(strings.TrimSpace(v)) == 0 {
+			return errors.New("empty value")
+		}
+		if err := critValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//chunks splits keys into chunks that fit in a URL.  Keys with a comma
+//can't go in an "IN" condition, so they get a chunk of their own.
+func (f *Finder) chunks(keys [][]string) [][][]string {
+	max := f.MaxValues
+	if max <= 0 {
+		max = inChunk
+	}
+	maxURL := f.MaxURL
+	if maxURL <= 0 {
+		maxURL = MaxURLLength
+	}
+	var a [][][]string
+	var c [][]string
+	for _, k := range keys {
+		if strings.Contains(strings.Join(k, ""), ",") {
+			a = append(a, [][]string{k})
+			continue
+		}
+		if len(c) != 0 && (len(c) == max || len(f.url(append(c, k))) > maxURL) {
+			a = append(a, c)
+			c = nil
+		}
+		c = append(c, k)
+	}
+	if len(c) != 0 {
+		a = append(a, c)
+	}
+	return a
+}
+
+//criteria returns the condition that reads the records for a chunk of
+//keys.
+func (f *Finder) criteria(keys [][]string) string {
+	var a []string
+	for i, n := range f.Fields {
+		var values []string
+		seen := make(map[string]bool)
+		for _, k := range keys {
+			v := strings.TrimSpace(k[i])
+			if !seen[strings.ToLower(v)] {
+				seen[strings.ToLower(v)] = true
+				values = append(values, v)
+			}
+		}
+		if len(values) == 1 {
+			a = append(a, n+"="+values[0])
+		} else {
+			a = append(a, n+" IN "+strings.Join(values, ","))
+		}
+	}
+	if len(f.Criteria) != 0 {
+		a = append(a, f.Criteria)
+	}
+	return strings.Join(a, "&condition=")
+}
+
+//Queries returns a query for each chunk of values of the first field.
+//Each query reads the records for its chunk.  Use it to read the records
+//for a lot of keys, like the children of many parents.  Empty values and
+//values that can't be put into criteria are skipped.
+func (f *Finder) Queries(values []string) []Query {
+	var keys [][]string
+	for _, v := range values {
+		k := []string{v}
+		if findable(k) == nil {
+			keys = append(keys, k)
+		}
+	}
+	var a []Query
+	for _, c := range f.chunks(keys) {
+		a = append(a, f.query(c))
+	}
+	return a
+}
+
+//query returns the query for a chunk of keys.
+func (f *Finder) query(keys [][]string) Query {
+	q := Query{Criteria: f.criteria(keys)}
+	if len(f.Include) != 0 {
+		q.Include = append(q.Include, f.Include...)
+		for _, n := range f.Fields {
+			q.Include = append(q.Include, n)
+		}
+	}
+	return q
+}
+
+//url returns the URL that reads the first page for a chunk of keys.
+func (f *Finder) url(keys [][]string) string {
+	q := f.query(keys)
+	if f.Table.IsJoin() {
+		return f.Table.leftJoinURL(0, PageSize, q.crit())
+	}
+	return f.Table.manyURL(0, PageSize, q.crit())
+}
+
+//chunk reads the records for a chunk of keys and reports what matched
+//each key.
+func (f *Finder) chunk(ctx context.Context, keys [][]string, report func(FindResult)) error {
+	q := f.query(keys)
+	matches := make(map[string][]*Record)
+	for offset := int32(0); ; {
+		page, err := f.Table.Page(ctx, q, offset)
+		if err != nil {
+			return fmt.Errorf("find %s: %v", f.Table.Name, err)
+		}
+		for _, r := range page {
+			k := f.recordKey(r)
+			matches[k] = append(matches[k], r)
+		}
+		if len(page) < PageSize {
+			break
+		}
+		offset += int32(len(page))
+	}
+	for _, k := range keys {
+		report(FindResult{Key: k, Records: matches[findKey(k)]})
+	}
+	return nil
+}
+
+//recordKey returns the key that a record matches.
+func (f *Finder) recordKey(r *Record) string {
+	a := make([]string, len(f.Fields))
+	for i, n := range f.Fields {
+		v, ok := r.Lookup(n)
+		if !ok {
+			// Joins return fields without the table name.
+			v = r.Get(n[strings.LastIndex(n, ".")+1:])
+		}
+		a[i] = v
+	}
+	return findKey(a)
+}
+
+//findKey returns a key's values as a map key.
+func findKey(k []string) string {
+	a := make([]string, len(k))
+	for i, v := range k {
+		a[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return strings.Join(a, "\x00")
+}
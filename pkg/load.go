@@ -0,0 +1,602 @@
+package godig
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//LoadRow is a single row read from an input file.  Line is the line in
+//the input file where the row starts.  A CSV header is line one.  It's
+//used to report results and to resume.
+type LoadRow struct {
+	Line   int
+	Fields map[string]string
+}
+
+//RowReader returns rows from an input file.  Next returns io.EOF at
+//end of data.
+type RowReader interface {
+	Next() (LoadRow, error)
+}
+
+//NewRowReader returns a RowReader for the provided format.  Formats are
+//"csv" and "jsonl".  CSV files must have a header row.
+func NewRowReader(r io.Reader, format string) (RowReader, error) {
+	return NewRowReaderWith(r, format, CSVOptions{})
+}
+
+//NewRowReaderWith is NewRowReader with options for CSV files.
+func NewRowReaderWith(r io.Reader, format string, o CSVOptions) (RowReader, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		c, err := NewCSVReader(r, o)
+		if err != nil {
+			return nil, err
+		}
+		return &csvRows{r: c}, nil
+	case "jsonl", "json":
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &jsonRows{s: s}, nil
+	}
+	return nil, fmt.Errorf("unknown input format '%s'", format)
+}
+
+//FormatFromPath returns the input format implied by a filename.
+func FormatFromPath(p string) string {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".jsonl", ".json", ".ndjson":
+		return "jsonl"
+	}
+	return "csv"
+}
+
+//csvRows reads rows from a CSV file.
+type csvRows struct {
+	r *CSVReader
+}
+
+//Next implements RowReader.
+func (c *csvRows) Next() (LoadRow, error) {
+	var row LoadRow
+	x, err := c.r.Read()
+	if err != nil {
+		return row, err
+	}
+	row.Line = c.r.Line()
+	row.Fields = x.Map()
+	return row, nil
+}
+
+//jsonRows reads rows from a JSON Lines file.  Each line is an object.
+//Non-string values are converted to strings.
+type jsonRows struct {
+	s    *bufio.Scanner
+	line int
+}
+
+//Next implements RowReader.
+func (j *jsonRows) Next() (LoadRow, error) {
+	var row LoadRow
+	for j.s.Scan() {
+		j.line++
+		b := strings.TrimSpace(j.s.Text())
+		if len(b) == 0 {
+			continue
+		}
+		var m map[string]interface{}
+		err := json.Unmarshal([]byte(b), &m)
+		if err != nil {
+			return row, fmt.Errorf("line %d: %v", j.line, err)
+		}
+		row.Line = j.line
+		row.Fields = make(map[string]string)
+		for k, v := range m {
+			switch x := v.(type) {
+			case nil:
+				row.Fields[k] = ""
+			case string:
+				row.Fields[k] = x
+			case float64:
+				row.Fields[k] = strconv.FormatFloat(x, 'f', -1, 64)
+			case bool:
+				row.Fields[k] = "0"
+				if x {
+					row.Fields[k] = "1"
+				}
+			default:
+				row.Fields[k] = fmt.Sprintf("%v", x)
+			}
+		}
+		return row, nil
+	}
+	err := j.s.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	return row, err
+}
+
+//LoadResult is the outcome of loading one row.
+type LoadResult struct {
+	Line     int
+	Action   string
+	Key      string
+	Result   string
+	Messages []string
+}
+
+//LoadSummary contains the totals for a load.
+type LoadSummary struct {
+	Read     int
+	Skipped  int
+	Inserted int
+	Updated  int
+	Errors   int
+}
+
+//Loader inserts or updates records in a Salsa table from rows in an input
+//file.  Rows that contain a primary key update that record.  Rows without
+//a primary key are matched on MatchField.  Rows that don't match are
+//inserted.
+type Loader struct {
+	Table *Table
+	//Mapping converts input column names to Salsa field names.  Columns
+	//that are not in the mapping are loaded using the column name.  Map a
+	//column to "-" to ignore it.
+	Mapping map[string]string
+	//MatchField is the natural key used to find existing records, for
+	//example "Email" for supporters.  Leave it empty to only match on
+	//the primary key.
+	MatchField string
+	//Workers is the number of concurrent savers.
+	Workers int
+	//Rate is the maximum number of API requests per second.  Zero means
+	//no limit.
+	Rate float64
+	//Retries is the number of times to retry a request that fails to
+	//reach Salsa.  Results with an error from Salsa are not retried.  An
+	//insert that fails may have been applied, so it's only retried when
+	//MatchField shows that it wasn't.
+	Retries int
+	//ResultsPath is the CSV file where per-row results are written.
+	ResultsPath string
+	//Resume skips rows that were loaded successfully by an earlier run.
+	//The earlier results are read from ResultsPath.
+	Resume bool
+	//Progress, if set, reports the rows loaded.  The total isn't known
+	//until the input has been read.
+	Progress *Progress
+
+	fields map[string]string
+	tick   *time.Ticker
+}
+
+//loadHeaders are the columns in a load results file.
+var loadHeaders = []string{"line", "action", "key", "result", "messages"}
+
+//Validate checks the mapping against the table's fields.  Unknown fields
+//are an error.  The table is only described the first time.
+func (l *Loader) Validate(header []string) error {
+	if l.fields == nil {
+		f, err := l.Table.Describe()
+		if err != nil {
+			return err
+		}
+		l.fields = make(map[string]string)
+		for _, x := range f {
+			l.fields[strings.ToLower(x.Name)] = x.Name
+		}
+		pk := l.Table.Name + "_KEY"
+		l.fields[strings.ToLower(pk)] = pk
+	}
+	bad := l.unknown(header)
+	if len(l.MatchField) != 0 {
+		if _, ok := l.fields[strings.ToLower(l.MatchField)]; !ok {
+			bad = append(bad, l.MatchField+" (match field)")
+		}
+	}
+	if len(bad) != 0 {
+		m := fmt.Sprintf("%s does not have these fields: %s", l.Table.Name, strings.Join(bad, ", "))
+		return errors.New(m)
+	}
+	return nil
+}
+
+//unknown returns the fields for input columns that the table doesn't
+//have.
+func (l *Loader) unknown(columns []string) []string {
+	var bad []string
+	for _, h := range columns {
+		n := l.field(h)
+		if n == "-" {
+			continue
+		}
+		if _, ok := l.fields[strings.ToLower(n)]; !ok {
+			bad = append(bad, fmt.Sprintf("%s (from column %s)", n, h))
+		}
+	}
+	sort.Strings(bad)
+	return bad
+}
+
+//field returns the Salsa field name for an input column.
+func (l *Loader) field(column string) string {
+	if n, ok := l.Mapping[column]; ok {
+		return n
+	}
+	return column
+}
+
+//record converts an input row to a record of Salsa field names.  Field
+//names use the case from Describe.
+func (l *Loader) record(row LoadRow) map[string]string {
+	m := make(map[string]string)
+	for k, v := range row.Fields {
+		n := l.field(k)
+		if n == "-" {
+			continue
+		}
+		if x, ok := l.fields[strings.ToLower(n)]; ok {
+			n = x
+		}
+		m[n] = v
+	}
+	return m
+}
+
+//Run reads rows, validates them against Describe, then inserts or updates
+//them using a pool of workers.  Results are written to ResultsPath.
+func (l *Loader) Run(ctx context.Context, rows RowReader) (LoadSummary, error) {
+	ctx, span := Stage(ctx, "load "+l.Table.Name,
+		attribute.String("salsa.table", l.Table.Name))
+	sum, err := l.run(ctx, rows)
+	EndStage(span, err)
+	return sum, err
+}
+
+//run does the work for Run.
+func (l *Loader) run(ctx context.Context, rows RowReader) (LoadSummary, error) {
+	var sum LoadSummary
+	if l.Workers < 1 {
+		l.Workers = 1
+	}
+	done, err := l.completed()
+	if err != nil {
+		return sum, err
+	}
+	if l.Rate > 0 {
+		l.tick = time.NewTicker(time.Duration(float64(time.Second) / l.Rate))
+		defer l.tick.Stop()
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if l.Resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(l.ResultsPath, flags, 0644)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		w.Write(loadHeaders)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in := make(chan LoadRow, 100)
+	out := make(chan LoadResult, 100)
+	// Workers stop sending when results can't be written anymore.  They
+	// don't stop when the context is cancelled.  The rows that they were
+	// saving still need results so that a resumed load skips them.
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < l.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range in {
+				r := l.loadRow(ctx, row)
+				select {
+				case out <- r:
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	// The reader's counts are only read after it's done.
+	var read, skipped int
+	var readErr error
+	reading := make(chan struct{})
+	go func() {
+		defer close(reading)
+		defer close(in)
+		validated := false
+		for {
+			row, err := rows.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				cancel()
+				return
+			}
+			if !validated {
+				var h []string
+				for k := range row.Fields {
+					h = append(h, k)
+				}
+				err = l.Validate(h)
+				if err != nil {
+					readErr = err
+					cancel()
+					return
+				}
+				validated = true
+			}
+			read++
+			if done[row.Line] {
+				skipped++
+				continue
+			}
+			select {
+			case in <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	finish := func() {
+		<-reading
+		sum.Read, sum.Skipped = read, skipped
+	}
+
+	l.Progress.Start("load "+l.Table.Name, -1, 0)
+	defer l.Progress.Finish()
+	for r := range out {
+		l.Progress.Add(1)
+		switch {
+		case r.Result == "error":
+			sum.Errors++
+		case r.Action == "insert":
+			sum.Inserted++
+		default:
+			sum.Updated++
+		}
+		a := []string{
+			strconv.Itoa(r.Line),
+			r.Action,
+			r.Key,
+			r.Result,
+			strings.Join(r.Messages, "; "),
+		}
+		err := w.Write(a)
+		if err != nil {
+			cancel()
+			close(quit)
+			for range out {
+			}
+			finish()
+			return sum, err
+		}
+		w.Flush()
+	}
+	w.Flush()
+	finish()
+	if readErr != nil {
+		return sum, readErr
+	}
+	return sum, w.Error()
+}
+
+//completed reads an earlier results file and returns the lines that
+//were loaded successfully.  Returns an empty map unless Resume is set.
+func (l *Loader) completed() (map[int]bool, error) {
+	done := make(map[int]bool)
+	if !l.Resume {
+		return done, nil
+	}
+	f, err := os.Open(l.ResultsPath)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return done, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		a, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return done, fmt.Errorf("%v reading %s", err, l.ResultsPath)
+		}
+		if len(a) < 4 || a[0] == loadHeaders[0] {
+			continue
+		}
+		n, err := strconv.Atoi(a[0])
+		if err != nil {
+			continue
+		}
+		// A later success for the same line wins.  So does a later error.
+		done[n] = a[3] == "success"
+	}
+	for k, v := range done {
+		if !v {
+			delete(done, k)
+		}
+	}
+	log.Printf("Loader: resuming, %d rows already loaded\n", len(done))
+	return done, nil
+}
+
+//loadRow matches a row to an existing record, then saves it.  Rows
+//can have different fields in JSON Lines files, so each row's fields are
+//checked.
+func (l *Loader) loadRow(ctx context.Context, row LoadRow) LoadResult {
+	r := LoadResult{Line: row.Line, Action: "update"}
+	var h []string
+	for k := range row.Fields {
+		h = append(h, k)
+	}
+	if bad := l.unknown(h); len(bad) != 0 {
+		r.Result = "error"
+		r.Messages = []string{fmt.Sprintf("%s does not have these fields: %s", l.Table.Name, strings.Join(bad, ", "))}
+		return r
+	}
+	m := l.record(row)
+	pk := l.Table.Name + "_KEY"
+	key := strings.TrimSpace(m[pk])
+	if len(key) == 0 || key == "0" {
+		k, err := l.match(ctx, m)
+		if err != nil {
+			r.Result = "error"
+			r.Messages = []string{err.Error()}
+			return r
+		}
+		key = k
+	}
+	if key == "0" {
+		r.Action = "insert"
+	}
+	var sr Results
+	var err error
+	for i := 0; i <= l.Retries; i++ {
+		if i > 0 && key == "0" {
+			// The insert may have been applied before it failed.
+			k, e := l.rematch(ctx, m)
+			if e != nil {
+				err = fmt.Errorf("%v, %v", err, e)
+				break
+			}
+			if k != "0" {
+				key = k
+				r.Action = "update"
+			}
+		}
+		err = l.wait(ctx, i)
+		if err != nil {
+			break
+		}
+		if i > 0 {
+			l.Table.Metrics.Retry("save", l.Table.Name)
+		}
+		r.Key, sr, err = l.Table.SaveRecord(ctx, key, m)
+		if err == nil || sr.Result == "error" {
+			break
+		}
+	}
+	r.Messages = sr.Messages
+	r.Result = sr.Result
+	if err != nil {
+		r.Result = "error"
+		if sr.Result != "error" {
+			r.Messages = append(r.Messages, err.Error())
+		}
+	}
+	if len(r.Key) == 0 {
+		r.Key = key
+	}
+	return r
+}
+
+//rematch looks for a record that a failed insert may have created.
+//Returns "0" if there isn't one.  Inserts can't be retried without
+//MatchField, or when the row doesn't have a value for it.
+func (l *Loader) rematch(ctx context.Context, m map[string]string) (string, error) {
+	if len(l.MatchField) == 0 || len(strings.TrimSpace(m[l.fields[strings.ToLower(l.MatchField)]])) == 0 {
+		return "", errors.New("insert failed and may have been applied, not retried")
+	}
+	return l.match(ctx, m)
+}
+
+//match finds the key of an existing record using MatchField.  Returns
+//"0" when there's no match.  More than one match is an error, and so is
+//a value that can't be put into criteria.
+func (l *Loader) match(ctx context.Context, m map[string]string) (string, error) {
+	if len(l.MatchField) == 0 {
+		return "0", nil
+	}
+	n := l.fields[strings.ToLower(l.MatchField)]
+	v := strings.TrimSpace(m[n])
+	if len(v) == 0 {
+		return "0", nil
+	}
+	if err := critValue(v); err != nil {
+		return "", err
+	}
+	crit := fmt.Sprintf("%s=%s", n, v)
+	var a []*Record
+	var err error
+	for i := 0; i <= l.Retries; i++ {
+		err = l.wait(ctx, i)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			l.Table.Metrics.Retry("getObjects.sjs", l.Table.Name)
+		}
+		a, err = l.Table.manyRecords(ctx, 0, 2, crit)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	switch len(a) {
+	case 0:
+		return "0", nil
+	case 1:
+		return a[0].Get(l.Table.Name + "_KEY"), nil
+	}
+	return "", fmt.Errorf("more than one %s matches %s", l.Table.Name, crit)
+}
+
+//wait enforces the rate limit.  Retries also back off by a second for
+//each attempt.
+func (l *Loader) wait(ctx context.Context, attempt int) error {
+	if attempt > 0 {
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.tick == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.tick.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
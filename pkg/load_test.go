@@ -0,0 +1,105 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//sliceRows is a RowReader for rows in memory.
+type sliceRows struct {
+	rows []LoadRow
+}
+
+//Next implements RowReader.
+func (s *sliceRows) Next() (LoadRow, error) {
+	if len(s.rows) == 0 {
+		return LoadRow{}, io.EOF
+	}
+	r := s.rows[0]
+	s.rows = s.rows[1:]
+	return r, nil
+}
+
+//loadRows returns rows with emails, starting on line two.
+func loadRows(n int) *sliceRows {
+	s := &sliceRows{}
+	for i := 0; i < n; i++ {
+		s.rows = append(s.rows, LoadRow{Line: i + 2, Fields: map[string]string{"Email": fmt.Sprintf("s%d@example.com", i)}})
+	}
+	return s
+}
+
+func TestLoaderRun(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.fields["supporter"] = supporterFields
+	f.put("supporter", "5", "Email", "old@example.com")
+	tb := a.Supporter()
+	p := filepath.Join(t.TempDir(), "results.csv")
+	rows := loadRows(2)
+	rows.rows = append(rows.rows, LoadRow{Line: 4, Fields: map[string]string{"supporter_KEY": "5", "Email": "new@example.com"}})
+	l := Loader{Table: &tb, Workers: 2, ResultsPath: p}
+	sum, err := l.Run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (LoadSummary{Read: 3, Inserted: 2, Updated: 1}) {
+		t.Errorf("summary %+v", sum)
+	}
+	if got := f.get("supporter", "5")["Email"]; got != "new@example.com" {
+		t.Errorf("supporter 5 has %s", got)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 4 {
+		t.Errorf("results have %d lines, want 4\n%s", n, b)
+	}
+
+	l = Loader{Table: &tb, ResultsPath: p, Resume: true}
+	sum, err = l.Run(context.Background(), loadRows(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (LoadSummary{Read: 4, Skipped: 3, Inserted: 1}) {
+		t.Errorf("resumed summary %+v", sum)
+	}
+}
+
+//loaderGoroutines returns the number of goroutines started by a Loader.
+func loaderGoroutines() int {
+	b := make([]byte, 1<<20)
+	b = b[:runtime.Stack(b, true)]
+	return strings.Count(string(b), "(*Loader).run.func")
+}
+
+func TestLoaderStopsWhenResultsFail(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("needs /dev/full")
+	}
+	f, a := newFakeSalsa(t)
+	f.fields["supporter"] = supporterFields
+	tb := a.Supporter()
+	l := Loader{Table: &tb, Workers: 4, ResultsPath: "/dev/full"}
+	sum, err := l.Run(context.Background(), loadRows(400))
+	if err == nil {
+		t.Fatal("results were written to a full device")
+	}
+	if sum.Read == 0 {
+		t.Errorf("summary %+v", sum)
+	}
+	for i := 0; loaderGoroutines() != 0; i++ {
+		if i == 100 {
+			t.Fatalf("%d loader goroutines are still running", loaderGoroutines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,217 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//Lookup decorates records with fields from another table.  It's a join
+//done here instead of in Salsa.  Use it to add a name to records that
+//only have a key, like a blast's subject to donations, instead of a
+//getLeftJoin chain.
+//
+//Keys are collected from each batch of records.  Keys that haven't been
+//seen are read with "IN" conditions, a chunk at a time.  Rows are kept,
+//so each key is only read once.  Use an API with a Cache to keep them
+//between runs.
+type Lookup struct {
+	//Table is the table that has the fields, like "email_blast".
+	Table *Table
+	//Key is the field in the records that holds the lookup value.  The
+	//default is Table's primary key, like "email_blast_KEY".
+	Key string
+	//Match is the field in Table that Key is compared with.  The default
+	//is Table's primary key.  The first row that matches is used.
+	Match string
+	//Fields are copied from Table's row into each record.
+	Fields []string
+	//Prefix goes in front of the copied fields' names.  NewLookup uses
+	//the table's name and a dot, like "email_blast.Subject".
+	Prefix string
+	//ChunkSize is the largest number of keys in each "IN" condition, up
+	//to PageSize.  Chunks are smaller when the URL would be too long.
+	ChunkSize int
+
+	mu   sync.Mutex
+	rows map[string]*Record
+}
+
+//NewLookup returns a lookup that copies fields from a table's rows into
+//records with the table's primary key.
+func NewLookup(t *Table, fields ...string) *Lookup {
+	return &Lookup{
+		Table:     t,
+		Key:       t.Name + "_KEY",
+		Match:     t.Name + "_KEY",
+		Fields:    fields,
+		Prefix:    t.Name + ".",
+		ChunkSize: inChunk,
+		rows:      make(map[string]*Record),
+	}
+}
+
+//Names returns the names of the fields that the lookup adds.
+func (l *Lookup) Names() []string {
+	a := make([]string, len(l.Fields))
+	for i, f := range l.Fields {
+		a[i] = l.Prefix + f
+	}
+	return a
+}
+
+//Enrich adds the lookup's fields to a batch of records.  Records with a
+//key that isn't in Table get empty fields.  Enrich is safe to call from
+//more than one goroutine.
+//
+//The lookup is traced as a "lookup" stage.  Reads are spans inside it.
+func (l *Lookup) Enrich(ctx context.Context, page []*Record) error {
+	ctx, span := Stage(ctx, "lookup "+l.Table.Name,
+		attribute.String("salsa.table", l.Table.Name),
+		attribute.Int("lookup.records", len(page)))
+	err := l.enrich(ctx, page)
+	EndStage(span, err)
+	return err
+}
+
+//enrich does the work for Enrich.
+func (l *Lookup) enrich(ctx context.Context, page []*Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rows == nil {
+		l.rows = make(map[string]*Record)
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, r := range page {
+		k := r.Get(l.Key)
+		if _, ok := l.rows[k]; ok || seen[k] || !usableKey(k) {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	err := l.fetch(ctx, keys)
+	if err != nil {
+		return err
+	}
+	names := l.Names()
+	for _, r := range page {
+		x := l.rows[r.Get(l.Key)]
+		for i, f := range l.Fields {
+			v := ""
+			if x != nil {
+				v = x.Get(f)
+			}
+			r.Set(names[i], v)
+		}
+	}
+	return nil
+}
+
+//usableKey returns false for keys that can't match anything.
+func usableKey(k string) bool {
+	k = strings.TrimSpace(k)
+	return len(k) != 0 && k != "0" && !strings.ContainsAny(k, ",()")
+}
+
+//fetch reads the rows for keys, a chunk at a time.  Keys without a row
+//are remembered so that they're not read again.
+func (l *Lookup) fetch(ctx context.Context, keys []string) error {
+	n := l.ChunkSize
+	if n <= 0 || n > PageSize {
+		n = inChunk
+	}
+	for _, k := range keys {
+		l.rows[k] = nil
+	}
+	f := Finder{Table: l.Table, Fields: []string{l.Match}, Include: l.Fields, MaxValues: n}
+	for _, q := range f.Queries(keys) {
+		// A short page is the last one, so most chunks are one read.
+		for offset := int32(0); ; {
+			page, err := l.Table.Page(ctx, q, offset)
+			if err != nil {
+				return fmt.Errorf("lookup %s: %v", l.Table.Name, err)
+			}
+			for _, r := range page {
+				k := r.Get(l.Match)
+				if x, ok := l.rows[k]; ok && x == nil {
+					l.rows[k] = r
+				}
+			}
+			if len(page) < PageSize {
+				break
+			}
+			offset += int32(len(page))
+		}
+	}
+	return nil
+}
+
+//Pipe is Enrich as a stage between two channels.  Records are collected
+//into batches of up to size records, enriched and sent on.  A batch is
+//also sent when no more records are waiting, so a slow source doesn't
+//hold records back.  Pipe closes out when in is closed or on an error.
+func (l *Lookup) Pipe(ctx context.Context, in <-chan *Record, out chan<- *Record, size int) error {
+	defer close(out)
+	if size <= 0 {
+		size = PageSize
+	}
+	send := func(a []*Record) error {
+		err := l.Enrich(ctx, a)
+		if err != nil {
+			return err
+		}
+		for _, r := range a {
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	var a []*Record
+	for {
+		var r *Record
+		var ok bool
+		if len(a) == 0 {
+			select {
+			case r, ok = <-in:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else {
+			select {
+			case r, ok = <-in:
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				// Nothing is waiting.  Send what we have.
+				err := send(a)
+				if err != nil {
+					return err
+				}
+				a = nil
+				continue
+			}
+		}
+		if !ok {
+			if len(a) != 0 {
+				return send(a)
+			}
+			return nil
+		}
+		a = append(a, r)
+		if len(a) == size {
+			err := send(a)
+			if err != nil {
+				return err
+			}
+			a = nil
+		}
+	}
+}
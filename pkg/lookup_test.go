@@ -0,0 +1,46 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestLookupEnrich(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	for i := 1; i <= 300; i++ {
+		f.put("email_blast", fmt.Sprint(i), "Subject", fmt.Sprintf("Blast %d", i))
+	}
+	tb := a.NewTable("email_blast")
+	l := NewLookup(&tb, "Subject")
+	var page []*Record
+	for _, k := range []string{"1", "150", "300", "999", "0", "", "1"} {
+		r := NewRecord()
+		r.Set("email_blast_KEY", k)
+		page = append(page, r)
+	}
+	for i := 1; i <= 250; i++ {
+		r := NewRecord()
+		r.Set("email_blast_KEY", fmt.Sprint(i))
+		page = append(page, r)
+	}
+	if err := l.Enrich(context.Background(), page); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Blast 1", "Blast 150", "Blast 300", "", "", "", "Blast 1"}
+	for i, w := range want {
+		if got := page[i].Get("email_blast.Subject"); got != w {
+			t.Errorf("key %s: %q, want %q", page[i].Get("email_blast_KEY"), got, w)
+		}
+	}
+	reads := f.gets["/api/getObjects.sjs"]
+	if reads != 3 {
+		t.Errorf("%d reads, want 3", reads)
+	}
+	if err := l.Enrich(context.Background(), page[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if f.gets["/api/getObjects.sjs"] != reads {
+		t.Errorf("keys were read again")
+	}
+}
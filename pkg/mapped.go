@@ -1,70 +1,112 @@
 package godig
 
 import (
+	"context"
+
 	"github.com/tidwall/gjson"
 )
 
 //OneMap retrieves a single record using the provided primary key.  The
 //returned record is a map of names and values.  Everything is a string.
+//Use OneRecord to keep the order of the fields.
 func (t *Table) OneMap(key string) (map[string]string, error) {
 	var b map[string]string
-	body, err := t.OneRaw(key)
-	if err != nil {
-		return b, err
+	r, err := t.OneRecord(key)
+	if r != nil {
+		b = r.Map()
 	}
-	r := gjson.ParseBytes(body)
-	b = unpackGJsonMap(r)
 	return b, err
 }
 
+//OneRecord retrieves a single record using the provided primary key.
+//The record's fields are in the order that Salsa returned them.
+func (t *Table) OneRecord(key string) (*Record, error) {
+	return t.oneRecord(context.Background(), key)
+}
+
+//oneRecord does the work for OneRecord.
+func (t *Table) oneRecord(ctx context.Context, key string) (*Record, error) {
+	body, err := t.oneRaw(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	t.Metrics.Decoded(t.Name, 1)
+	return recordFromGJson(gjson.ParseBytes(body)), nil
+}
+
 //ManyMap returns an array of records.  Each record is a map of field names
 // and values. An empty array indicates end of data.
 func (t *Table) ManyMap(offset int32, count int, crit string) ([]map[string]string, error) {
-	var a []map[string]string
-	body, err := t.ManyRaw(offset, count, crit)
-	if err != nil {
-		return a, err
-	}
-	a = unpackGJsonArray(body)
-	return a, nil
+	a, err := t.ManyRecords(offset, count, crit)
+	return recordMaps(a), err
+}
+
+//ManyRecords returns an array of records with fields in the order that
+//Salsa returned them.  An empty array indicates end of data.
+func (t *Table) ManyRecords(offset int32, count int, crit string) ([]*Record, error) {
+	return t.manyRecords(context.Background(), offset, count, crit)
+}
+
+//manyRecords is ManyRecords with a context.  Records are decoded as the
+//response arrives.
+func (t *Table) manyRecords(ctx context.Context, offset int32, count int, crit string) ([]*Record, error) {
+	return t.collectRecords(ctx, t.manyURL(offset, count, crit))
 }
 
 //ManyMapTagged returns an array of records that have a common tag.  Each
 // record is a map of field names and values. An empty array indicates end of data.
 func (t *Table) ManyMapTagged(offset int32, count int, crit string, tag string) ([]map[string]string, error) {
-	var a []map[string]string
+	a, err := t.ManyRecordsTagged(offset, count, crit, tag)
+	return recordMaps(a), err
+}
+
+//ManyRecordsTagged returns an array of records that have a common tag.
+//Fields are in the order that Salsa returned them.  An empty array
+//indicates end of data.
+func (t *Table) ManyRecordsTagged(offset int32, count int, crit string, tag string) ([]*Record, error) {
+	var a []*Record
 	body, err := t.ManyRawTagged(offset, count, crit, tag)
 	if err != nil {
 		return a, err
 	}
 	a = unpackGJsonArray(body)
+	t.Metrics.Decoded(t.Name, len(a))
 	return a, nil
 }
 
 //LeftJoinMap reads from Salsa and returns an array of maps. The results are
 //unmarshalled using gjson. Each map containsa single record.
 func (t *Table) LeftJoinMap(offset int32, count int, crit string) ([]map[string]string, error) {
-	var a []map[string]string
-	body, err := t.LeftJoinRaw(offset, count, crit)
-	a = unpackGJsonArray(body)
-	return a, err
+	a, err := t.LeftJoinRecords(offset, count, crit)
+	return recordMaps(a), err
+}
+
+//LeftJoinRecords reads from Salsa and returns an array of records with
+//fields in the order that Salsa returned them.
+func (t *Table) LeftJoinRecords(offset int32, count int, crit string) ([]*Record, error) {
+	return t.leftJoinRecords(context.Background(), offset, count, crit)
 }
 
-func unpackGJsonMap(r gjson.Result) map[string]string {
-	b := make(map[string]string, 0)
-	r.ForEach(func(key, value gjson.Result) bool {
-		b[key.String()] = value.String()
-		return true
-	})
+//leftJoinRecords is LeftJoinRecords with a context.  Records are
+//decoded as the response arrives.
+func (t *Table) leftJoinRecords(ctx context.Context, offset int32, count int, crit string) ([]*Record, error) {
+	return t.collectRecords(ctx, t.leftJoinURL(offset, count, crit))
+}
+
+//recordMaps converts records to maps.
+func recordMaps(a []*Record) []map[string]string {
+	b := make([]map[string]string, 0, len(a))
+	for _, r := range a {
+		b = append(b, r.Map())
+	}
 	return b
 }
 
-func unpackGJsonArray(body []byte) []map[string]string {
-	a := make([]map[string]string, 0)
+func unpackGJsonArray(body []byte) []*Record {
+	a := make([]*Record, 0)
 	f := gjson.ParseBytes(body).Array()
 	for _, r := range f {
-		b := unpackGJsonMap(r)
-		a = append(a, b)
+		a = append(a, recordFromGJson(r))
 	}
 	return a
 }
@@ -0,0 +1,562 @@
+package godig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//MatchFields are the supporter fields that a Matcher reads.
+var MatchFields = []string{
+	"supporter_KEY", "First_Name", "Last_Name", "Email",
+	"Phone", "Cell_Phone", "Work_Phone", "Street", "City", "State", "Zip",
+}
+
+//MatchRule scores how well a candidate matches an input record.
+type MatchRule struct {
+	Name string
+	//Weight is how much the rule counts toward the confidence.  Zero
+	//turns the rule off.
+	Weight float64
+	//Score returns a score from 0 to 1 and the reason for it.  ok is
+	//false when either record doesn't have what the rule needs.  Rules
+	//that don't apply don't count.
+	Score func(m *Matcher, in, c *Record) (score float64, reason string, ok bool)
+}
+
+//MatchBlock reads the candidates for an input record, like the
+//supporters with the same zip code.  Blocks keep a Matcher from reading
+//the whole supporter table.
+type MatchBlock struct {
+	Name string
+	//Criteria returns the condition that reads the candidates.  Returns
+	//an empty string if the input doesn't have what the block needs.
+	Criteria func(in *Record) string
+}
+
+//Match is a candidate that matched an input record.
+type Match struct {
+	Record *Record
+	//Score is the confidence, from 0 to 1.
+	Score float64
+	//Reasons explain the score, one for each rule that applied.
+	Reasons []string
+}
+
+//MatchResult is what a Matcher found for an input record.
+type MatchResult struct {
+	//Row is the input's index, starting at zero.
+	Row   int
+	Input *Record
+	//Matches are best first.
+	Matches []Match
+	//Skipped are the blocks that had more than MaxBlock candidates.
+	Skipped []string
+	Err     error
+}
+
+//MatchSummary counts the input records that a Matcher handled.
+type MatchSummary struct {
+	Rows    int
+	Matched int
+	NoMatch int
+	Errors  int
+}
+
+//Matcher finds the supporters that look like input records, such as the
+//rows of a spreadsheet.  Candidates are read with the blocks and scored
+//with the rules.  The confidence is the weighted average of the rules
+//that apply.  Blocks are read once and shared by the inputs that need
+//them.
+type Matcher struct {
+	Table  *Table
+	Rules  []MatchRule
+	Blocks []MatchBlock
+	//Identifiers are sets of rules that tell people apart.  A candidate
+	//only matches when every rule in one of the sets applies.  A zip
+	//code, or a last name and a zip code, isn't enough.  Empty means
+	//any rule is enough.
+	Identifiers [][]string
+	//Nicknames are groups of names that belong to the same person.
+	Nicknames [][]string
+	//MinScore is the lowest confidence that counts as a match.
+	MinScore float64
+	//MaxMatches is the largest number of matches for an input.
+	MaxMatches int
+	//MaxBlock skips blocks with more candidates than this.  A block of
+	//everyone at gmail.com isn't useful.
+	MaxBlock int
+	//Include limits the fields that Salsa returns.
+	Include []string
+	Workers int
+	//Progress, if set, counts the input records.
+	Progress *Progress
+
+	mu     sync.Mutex
+	blocks map[string]*block
+	nicks  map[string][]int
+}
+
+//block is the candidates read for a block's criteria.
+type block struct {
+	once    sync.Once
+	records []*Record
+	skipped bool
+	err     error
+}
+
+//DefaultMinScore is the lowest confidence that NewMatcher reports.
+const DefaultMinScore = 0.7
+
+//DefaultMaxBlock is the largest block that NewMatcher reads.
+const DefaultMaxBlock = 5000
+
+//NewMatcher returns a Matcher for supporters with the default rules,
+//blocks and nicknames.
+func NewMatcher(t *Table) *Matcher {
+	return &Matcher{
+		Table:       t,
+		Rules:       DefaultMatchRules(),
+		Blocks:      DefaultMatchBlocks(),
+		Identifiers: DefaultIdentifiers(),
+		Nicknames:   DefaultNicknames,
+		MinScore:    DefaultMinScore,
+		MaxMatches:  5,
+		MaxBlock:    DefaultMaxBlock,
+		Include:     MatchFields,
+		Workers:     1,
+	}
+}
+
+//DefaultIdentifiers returns the identifiers for email, phone, and first
+//and last name.
+func DefaultIdentifiers() [][]string {
+	return [][]string{
+		{"email"},
+		{"phone"},
+		{"first_name", "last_name"},
+	}
+}
+
+//DefaultMatchRules returns the rules for email, names, phone, street
+//address and zip code.
+func DefaultMatchRules() []MatchRule {
+	return []MatchRule{
+		{Name: "email", Weight: 4, Score: emailScore},
+		{Name: "first_name", Weight: 2, Score: firstNameScore},
+		{Name: "last_name", Weight: 3, Score: lastNameScore},
+		{Name: "phone", Weight: 3, Score: phoneScore},
+		{Name: "street", Weight: 2, Score: streetScore},
+		{Name: "zip", Weight: 1, Score: zipScore},
+	}
+}
+
+//DefaultMatchBlocks returns blocks for the email domain, the first three
+//letters of the last name and the zip code.
+func DefaultMatchBlocks() []MatchBlock {
+	return []MatchBlock{
+		EmailDomainBlock(),
+		LastNameBlock(3),
+		ZipBlock(),
+	}
+}
+
+//EmailDomainBlock reads supporters with the same email domain.
+func EmailDomainBlock() MatchBlock {
+	return MatchBlock{
+		Name: "email_domain",
+		Criteria: func(in *Record) string {
+			d := EmailDomain(in.Get("Email"))
+			if len(d) == 0 || !blockable(d) {
+				return ""
+			}
+			return "Email LIKE %@" + d
+		},
+	}
+}
+
+//LastNameBlock reads supporters whose last names start with the same n
+//letters.
+func LastNameBlock(n int) MatchBlock {
+	return MatchBlock{
+		Name: "last_name",
+		Criteria: func(in *Record) string {
+			x := []rune(strings.TrimSpace(in.Get("Last_Name")))
+			if len(x) == 0 {
+				return ""
+			}
+			if len(x) > n {
+				x = x[:n]
+			}
+			if !blockable(string(x)) {
+				return ""
+			}
+			return "Last_Name LIKE " + string(x) + "%"
+		},
+	}
+}
+
+//ZipBlock reads supporters with the same zip code.
+func ZipBlock() MatchBlock {
+	return MatchBlock{
+		Name: "zip",
+		Criteria: func(in *Record) string {
+			z := NormalizeZip(in.Get("Zip"))
+			if len(z) == 0 || !blockable(z) {
+				return ""
+			}
+			return "Zip LIKE " + z + "%"
+		},
+	}
+}
+
+//blockable returns true if a value can be put into a LIKE condition.
+//FixCrit encodes the value, but a "%" would be a wildcard.
+func blockable(s string) bool {
+	return !strings.Contains(s, "%") && critValue(s) == nil
+}
+
+//Run matches the input records.  The function sees the result for each
+//input, in the order that they're finished.  It's never called by more
+//than one goroutine at a time.  Run stops when the function returns an
+//error or when the context is cancelled.  Errors reading a block only
+//affect the inputs that need it.
+//
+//Matching is traced as a "match" stage.
+func (m *Matcher) Run(ctx context.Context, inputs []*Record, fn func(r MatchResult) error) (MatchSummary, error) {
+	ctx, span := Stage(ctx, "match "+m.Table.Name,
+		attribute.String("salsa.table", m.Table.Name),
+		attribute.Int("match.inputs", len(inputs)))
+	sum, err := m.run(ctx, inputs, fn)
+	EndStage(span, err)
+	return sum, err
+}
+
+//run does the work for Run.
+func (m *Matcher) run(ctx context.Context, inputs []*Record, fn func(r MatchResult) error) (MatchSummary, error) {
+	var sum MatchSummary
+	if len(m.Blocks) == 0 {
+		return sum, errors.New("match: no blocks")
+	}
+	m.Progress.Start("match "+m.Table.Name, len(inputs), 0)
+	defer m.Progress.Finish()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var first error
+	rows := make(chan int)
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				r := m.Match(ctx, inputs[i])
+				r.Row = i
+				mu.Lock()
+				if first == nil {
+					sum.Rows++
+					switch {
+					case r.Err != nil:
+						sum.Errors++
+					case len(r.Matches) == 0:
+						sum.NoMatch++
+					default:
+						sum.Matched++
+					}
+					m.Progress.Add(1)
+					if err := fn(r); err != nil {
+						first = err
+						cancel()
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range inputs {
+		select {
+		case rows <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(rows)
+	wg.Wait()
+	if first == nil {
+		first = ctx.Err()
+	}
+	return sum, first
+}
+
+//Match returns the candidates that match one input record.
+func (m *Matcher) Match(ctx context.Context, in *Record) MatchResult {
+	x := MatchResult{Input: in}
+	seen := make(map[string]bool)
+	var candidates []*Record
+	for _, b := range m.Blocks {
+		crit := b.Criteria(in)
+		if len(crit) == 0 {
+			continue
+		}
+		records, skipped, err := m.block(ctx, crit)
+		if err != nil {
+			x.Err = fmt.Errorf("%s block: %v", b.Name, err)
+			return x
+		}
+		if skipped {
+			x.Skipped = append(x.Skipped, b.Name)
+			continue
+		}
+		for _, c := range records {
+			k := c.Get(m.Table.Name + "_KEY")
+			if !seen[k] {
+				seen[k] = true
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	for _, c := range candidates {
+		y := m.Score(in, c)
+		if y.Score >= m.MinScore {
+			x.Matches = append(x.Matches, y)
+		}
+	}
+	sort.SliceStable(x.Matches, func(i, j int) bool {
+		return x.Matches[i].Score > x.Matches[j].Score
+	})
+	if m.MaxMatches > 0 && len(x.Matches) > m.MaxMatches {
+		x.Matches = x.Matches[:m.MaxMatches]
+	}
+	return x
+}
+
+//Score scores a candidate against an input record with the rules.  The
+//score is zero if none of the identifiers apply.
+func (m *Matcher) Score(in, c *Record) Match {
+	x := Match{Record: c}
+	var sum, weights float64
+	applied := make(map[string]bool)
+	for _, r := range m.Rules {
+		if r.Weight <= 0 {
+			continue
+		}
+		s, reason, ok := r.Score(m, in, c)
+		if !ok {
+			continue
+		}
+		sum += s * r.Weight
+		weights += r.Weight
+		applied[r.Name] = true
+		x.Reasons = append(x.Reasons, reason)
+	}
+	if !m.identified(applied) {
+		x.Reasons = append(x.Reasons, "not enough to tell people apart")
+		return x
+	}
+	if weights > 0 {
+		x.Score = sum / weights
+	}
+	return x
+}
+
+//identified returns true if every rule in one of the identifiers
+//applied.
+func (m *Matcher) identified(applied map[string]bool) bool {
+	if len(m.Identifiers) == 0 {
+		return true
+	}
+	for _, a := range m.Identifiers {
+		ok := len(a) != 0
+		for _, n := range a {
+			ok = ok && applied[n]
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+//block returns the candidates for a block's criteria.  Each criteria is
+//read once.
+func (m *Matcher) block(ctx context.Context, crit string) ([]*Record, bool, error) {
+	m.mu.Lock()
+	if m.blocks == nil {
+		m.blocks = make(map[string]*block)
+	}
+	b, ok := m.blocks[crit]
+	if !ok {
+		b = &block{}
+		m.blocks[crit] = b
+	}
+	m.mu.Unlock()
+	b.once.Do(func() {
+		b.records, b.skipped, b.err = m.read(ctx, crit)
+	})
+	return b.records, b.skipped, b.err
+}
+
+//read reads the candidates for a block.  Blocks with more than MaxBlock
+//candidates are skipped without reading them.
+func (m *Matcher) read(ctx context.Context, crit string) ([]*Record, bool, error) {
+	if m.MaxBlock > 0 {
+		n, err := m.Table.CountRecords(crit)
+		if err != nil {
+			return nil, false, err
+		}
+		if n > m.MaxBlock {
+			return nil, true, nil
+		}
+	}
+	var a []*Record
+	q := Query{Criteria: crit, Include: m.Include}
+	err := m.Table.Scan(ctx, q, func(page []*Record) error {
+		a = append(a, page...)
+		return nil
+	})
+	return a, false, err
+}
+
+//nickname returns true if two normalized first names are nicknames for
+//each other.
+func (m *Matcher) nickname(a, b string) bool {
+	m.mu.Lock()
+	if m.nicks == nil {
+		m.nicks = nicknameIndex(m.Nicknames)
+	}
+	x := m.nicks
+	m.mu.Unlock()
+	for _, i := range x[a] {
+		for _, j := range x[b] {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//emailScore compares email addresses.
+func emailScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := NormalizeEmail(in.Get("Email")), NormalizeEmail(c.Get("Email"))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	if a == b {
+		return 1, "same email", true
+	}
+	i, j := strings.LastIndex(a, "@"), strings.LastIndex(b, "@")
+	if a[i:] == b[j:] {
+		s := JaroWinkler(a[:i], b[:j])
+		if s >= 0.9 {
+			return s, fmt.Sprintf("similar email %.2f", s), true
+		}
+	}
+	return 0, "different email", true
+}
+
+//firstNameScore compares first names.  Nicknames and initials count.
+func firstNameScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := NormalizeName(in.Get("First_Name")), NormalizeName(c.Get("First_Name"))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	switch {
+	case a == b:
+		return 1, "same first name", true
+	case m.nickname(a, b):
+		return 0.9, fmt.Sprintf("nickname %s=%s", a, b), true
+	case (len(a) == 1 || len(b) == 1) && a[0] == b[0]:
+		return 0.7, "first initial", true
+	}
+	s := JaroWinkler(a, b)
+	return s, fmt.Sprintf("first name %.2f", s), true
+}
+
+//lastNameScore compares last names.
+func lastNameScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := NormalizeName(in.Get("Last_Name")), NormalizeName(c.Get("Last_Name"))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	if a == b {
+		return 1, "same last name", true
+	}
+	s := JaroWinkler(a, b)
+	return s, fmt.Sprintf("last name %.2f", s), true
+}
+
+//phones returns a record's normalized phone numbers.
+func phones(r *Record) []string {
+	var a []string
+	for _, n := range []string{"Phone", "Cell_Phone", "Work_Phone"} {
+		if x := NormalizePhone(r.Get(n)); len(x) != 0 {
+			a = append(a, x)
+		}
+	}
+	return a
+}
+
+//phoneScore compares phone numbers.  Any of the supporter's numbers can
+//match.  Numbers without an area code match on the last seven digits.
+func phoneScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := phones(in), phones(c)
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	best, reason := 0.0, "different phone"
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case x == y:
+				return 1, "same phone", true
+			case x[len(x)-7:] == y[len(y)-7:] && (len(x) == 7 || len(y) == 7):
+				best, reason = 0.8, "same phone without area code"
+			}
+		}
+	}
+	return best, reason, true
+}
+
+//streetScore compares street addresses.
+func streetScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := NormalizeStreet(in.Get("Street")), NormalizeStreet(c.Get("Street"))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	if a == b {
+		return 1, "same street", true
+	}
+	// Different house numbers are different addresses.
+	x, y := strings.Fields(a), strings.Fields(b)
+	if x[0] != y[0] && strings.Trim(x[0], "0123456789") == "" && strings.Trim(y[0], "0123456789") == "" {
+		return 0, "different street number", true
+	}
+	s := JaroWinkler(a, b)
+	return s, fmt.Sprintf("street %.2f", s), true
+}
+
+//zipScore compares zip codes.
+func zipScore(m *Matcher, in, c *Record) (float64, string, bool) {
+	a, b := NormalizeZip(in.Get("Zip")), NormalizeZip(c.Get("Zip"))
+	if len(a) == 0 || len(b) == 0 {
+		return 0, "", false
+	}
+	if a == b {
+		return 1, "same zip", true
+	}
+	return 0, "different zip", true
+}
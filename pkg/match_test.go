@@ -0,0 +1,81 @@
+package godig
+
+import (
+	"math"
+	"testing"
+)
+
+//matchRecord returns a record with the fields in pairs of names and
+//values.
+func matchRecord(fields ...string) *Record {
+	r := NewRecord()
+	for i := 0; i+1 < len(fields); i += 2 {
+		r.Set(fields[i], fields[i+1])
+	}
+	return r
+}
+
+func TestMatcherScore(t *testing.T) {
+	m := NewMatcher(&Table{Name: "supporter"})
+	bob := matchRecord("First_Name", "Robert", "Last_Name", "Smith", "Email", "bob.smith@gmail.com",
+		"Phone", "555-123-4567", "Street", "123 N Main St", "Zip", "12345")
+	tests := []struct {
+		name string
+		in   *Record
+		want float64
+	}{
+		{"everything", matchRecord("First_Name", "Robert", "Last_Name", "Smith", "Email", "bobsmith+x@gmail.com",
+			"Phone", "1 (555) 123-4567", "Street", "123 North Main Street", "Zip", "12345-0001"), 1},
+		{"email only", matchRecord("Email", "BOB.SMITH@gmail.com"), 1},
+		{"phone only", matchRecord("Phone", "5551234567"), 1},
+		{"nickname", matchRecord("First_Name", "Bob", "Last_Name", "Smith"), (0.9*2 + 3) / 5},
+		{"different email", matchRecord("First_Name", "Robert", "Last_Name", "Smith", "Email", "rs@example.com"), 5.0 / 9},
+		{"zip only", matchRecord("Zip", "12345"), 0},
+		{"last name and zip", matchRecord("Last_Name", "Smith", "Zip", "12345"), 0},
+		{"last name, street and zip", matchRecord("Last_Name", "Smith", "Street", "123 Main St", "Zip", "12345"), 0},
+		{"nothing", matchRecord(), 0},
+	}
+	for _, x := range tests {
+		got := m.Score(x.in, bob)
+		if math.Abs(got.Score-x.want) > 0.001 {
+			t.Errorf("%s: score %.3f, want %.3f %v", x.name, got.Score, x.want, got.Reasons)
+		}
+	}
+}
+
+func TestMatcherScoreWeights(t *testing.T) {
+	m := NewMatcher(&Table{Name: "supporter"})
+	m.Identifiers = nil
+	for i := range m.Rules {
+		if m.Rules[i].Name == "zip" {
+			m.Rules[i].Weight = 0
+		}
+	}
+	in := matchRecord("Last_Name", "Smith", "Zip", "99999")
+	c := matchRecord("Last_Name", "Smith", "Zip", "12345")
+	got := m.Score(in, c)
+	if got.Score != 1 || len(got.Reasons) != 1 {
+		t.Errorf("score %.3f %v, want 1 with one reason", got.Score, got.Reasons)
+	}
+}
+
+func TestMatchBlocks(t *testing.T) {
+	in := matchRecord("Last_Name", "O'Brien", "Email", "bob+news@Example.com", "Zip", "12345-6789")
+	tests := []struct {
+		block MatchBlock
+		want  string
+	}{
+		{EmailDomainBlock(), "Email LIKE %@example.com"},
+		{LastNameBlock(3), "Last_Name LIKE O'B%"},
+		{ZipBlock(), "Zip LIKE 12345%"},
+	}
+	for _, x := range tests {
+		if got := x.block.Criteria(in); got != x.want {
+			t.Errorf("%s: %q, want %q", x.block.Name, got, x.want)
+		}
+	}
+	in = matchRecord("Last_Name", "A%")
+	if got := LastNameBlock(3).Criteria(in); got != "" {
+		t.Errorf("last_name with a wildcard: %q, want nothing", got)
+	}
+}
@@ -0,0 +1,522 @@
+package godig
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//MergePolicies are the ways that a merge can copy fields onto the
+//survivor.
+var MergePolicies = []string{"fill", "newest", "none"}
+
+//Merge is a survivor and the records that will be merged into it.
+type Merge struct {
+	Survivor string
+	Losers   []string
+}
+
+//MergeStep is a single change in a merge.  Ops are "move" to point a
+//child record at the survivor, "update" to copy a field onto the
+//survivor and "delete".
+type MergeStep struct {
+	Op    string `json:"op"`
+	Table string `json:"table"`
+	Key   string `json:"key"`
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+	//Why explains the step.
+	Why string `json:"why,omitempty"`
+}
+
+//String implements Stringer.
+func (s MergeStep) String() string {
+	x := fmt.Sprintf("%s %s %s", s.Op, s.Table, s.Key)
+	if len(s.Field) != 0 {
+		x = fmt.Sprintf("%s %s '%s' -> '%s'", x, s.Field, s.Old, s.New)
+	}
+	if len(s.Why) != 0 {
+		x = x + ", " + s.Why
+	}
+	return x
+}
+
+//MergePlan is every change that a merge makes, in order.  Child records
+//are moved (or deleted when the survivor already has one), then the
+//losers are deleted, then fields are copied onto the survivor.  Fields
+//are copied last because Salsa won't save an email address that another
+//supporter has.
+type MergePlan struct {
+	Table    string
+	Survivor string
+	Losers   []string
+	Steps    []MergeStep
+}
+
+//Write writes the plan and its totals.
+func (p *MergePlan) Write(w io.Writer) error {
+	var err error
+	out := func(f string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, f, a...)
+		}
+	}
+	out("Merge %s %s into %s\n", p.Table, strings.Join(p.Losers, ", "), p.Survivor)
+	m := make(map[string]int)
+	for _, s := range p.Steps {
+		out("    %v\n", s)
+		m[s.Op+" "+s.Table]++
+	}
+	var a []string
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	for _, k := range a {
+		out("%8d %s\n", m[k], k)
+	}
+	return err
+}
+
+//Merger merges duplicate records.  Plan works out the changes.  Execute
+//makes them and writes an undo log.  Nothing is changed until Execute is
+//called, so write the plan and have somebody read it first.
+type Merger struct {
+	API *API
+	//Table is the table with the duplicates.  The default is
+	//"supporter".
+	Table string
+	//Dependents are the child tables that are moved to the survivor.
+	//The default is the package's Dependents for the table.
+	Dependents []Dependent
+	//Policy is how fields are copied onto the survivor.  "fill" copies
+	//a loser's value into an empty field.  Losers are used in order.
+	//"newest" uses the value from the most recently modified record that
+	//has one.  "none" doesn't copy fields.  The default is "fill".
+	Policy string
+	//Fields limits the fields that are copied.  Empty copies every field
+	//except keys and the fields that Salsa maintains.
+	Fields []string
+	//UndoPath is the JSON Lines file that Execute appends to.  Use Undo
+	//to reverse a merge.
+	UndoPath string
+	//Progress, if set, counts the steps that are done.
+	Progress *Progress
+}
+
+//dependents returns the child tables that are moved to the survivor.
+func (m *Merger) dependents() []Dependent {
+	if m.Dependents == nil {
+		return Dependents[m.table()]
+	}
+	return m.Dependents
+}
+
+//table returns the name of the table with the duplicates.
+func (m *Merger) table() string {
+	if len(m.Table) == 0 {
+		return "supporter"
+	}
+	return m.Table
+}
+
+//mergeSkip contains fields that are never copied onto a survivor.
+var mergeSkip = map[string]bool{
+	"Date_Created":  true,
+	"Last_Modified": true,
+	"object":        true,
+	"key":           true,
+}
+
+//copyable returns true if a merge can copy a field.
+func (m *Merger) copyable(name string) bool {
+	if len(m.Fields) != 0 {
+		for _, f := range m.Fields {
+			if f == name {
+				return true
+			}
+		}
+		return false
+	}
+	return !mergeSkip[name] && !strings.HasSuffix(name, "_KEY") && !strings.HasPrefix(name, "READONLY_")
+}
+
+//Plan works out the changes for a merge.  Nothing is changed.
+//
+//Planning is traced as a "merge plan" stage.
+func (m *Merger) Plan(ctx context.Context, x Merge) (*MergePlan, error) {
+	ctx, span := Stage(ctx, "merge plan "+m.table(),
+		attribute.String("salsa.table", m.table()),
+		attribute.String("merge.survivor", x.Survivor),
+		attribute.StringSlice("merge.losers", x.Losers))
+	p, err := m.plan(ctx, x)
+	EndStage(span, err)
+	return p, err
+}
+
+//plan does the work for Plan.  Records and their children are read from
+//Salsa, not the cache, so the plan is built on what's there now.
+func (m *Merger) plan(ctx context.Context, x Merge) (*MergePlan, error) {
+	ctx = uncached(ctx)
+	name := m.table()
+	p := &MergePlan{Table: name, Survivor: strings.TrimSpace(x.Survivor)}
+	for _, k := range uniqueKeys(x.Losers) {
+		if k != p.Survivor {
+			p.Losers = append(p.Losers, k)
+		}
+	}
+	if len(p.Survivor) == 0 || len(p.Losers) == 0 {
+		return p, errors.New("merge: need a survivor and at least one other record")
+	}
+	t := m.API.NewTable(name)
+	pk := name + "_KEY"
+	records := make(map[string]*Record)
+	for _, k := range append([]string{p.Survivor}, p.Losers...) {
+		r, err := t.oneRecord(ctx, k)
+		if err != nil {
+			return p, fmt.Errorf("merge: reading %s %s: %v", name, k, err)
+		}
+		if r.Get(pk) != k {
+			return p, fmt.Errorf("merge: %s %s doesn't exist", name, k)
+		}
+		records[k] = r
+	}
+
+	for _, d := range m.dependents() {
+		steps, err := m.children(ctx, d, p)
+		if err != nil {
+			return p, err
+		}
+		p.Steps = append(p.Steps, steps...)
+	}
+	for _, k := range p.Losers {
+		p.Steps = append(p.Steps, MergeStep{Op: "delete", Table: name, Key: k, Why: "merged into " + p.Survivor})
+	}
+	steps, err := m.fields(p, records)
+	if err != nil {
+		return p, err
+	}
+	p.Steps = append(p.Steps, steps...)
+	return p, nil
+}
+
+//children returns the steps that move a dependent table's records from
+//the losers to the survivor.
+func (m *Merger) children(ctx context.Context, d Dependent, p *MergePlan) ([]MergeStep, error) {
+	t := m.API.NewTable(d.Table)
+	pk := d.Table + "_KEY"
+	include := []string{pk, d.ForeignKey}
+	if len(d.Unique) != 0 {
+		include = append(include, d.Unique)
+	}
+	f := Finder{Table: &t, Fields: []string{d.ForeignKey}, Include: include}
+	if len(d.DatabaseTableKey) != 0 {
+		f.Criteria = "database_table_KEY=" + d.DatabaseTableKey
+	}
+	var survivor, losers []*Record
+	for _, q := range f.Queries(append([]string{p.Survivor}, p.Losers...)) {
+		q.OrderBy = []string{pk}
+		err := t.Scan(ctx, q, func(page []*Record) error {
+			for _, r := range page {
+				if r.Get(d.ForeignKey) == p.Survivor {
+					survivor = append(survivor, r)
+				} else {
+					losers = append(losers, r)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("merge: reading %s: %v", d.Table, err)
+		}
+	}
+	has := make(map[string]bool)
+	for _, r := range survivor {
+		has[r.Get(d.Unique)] = true
+	}
+	var a []MergeStep
+	for _, r := range losers {
+		if len(d.Unique) != 0 {
+			u := r.Get(d.Unique)
+			if has[u] {
+				a = append(a, MergeStep{Op: "delete", Table: d.Table, Key: r.Get(pk),
+					Why: fmt.Sprintf("%s %s already has %s %s", p.Table, p.Survivor, d.Unique, u)})
+				continue
+			}
+			has[u] = true
+		}
+		a = append(a, MergeStep{Op: "move", Table: d.Table, Key: r.Get(pk), Field: d.ForeignKey,
+			Old: r.Get(d.ForeignKey), New: p.Survivor})
+	}
+	return a, nil
+}
+
+//fields returns the steps that copy fields onto the survivor.
+func (m *Merger) fields(p *MergePlan, records map[string]*Record) ([]MergeStep, error) {
+	s := records[p.Survivor]
+	var names []string
+	seen := make(map[string]bool)
+	for _, k := range append([]string{p.Survivor}, p.Losers...) {
+		for _, n := range records[k].Names() {
+			if !seen[n] && m.copyable(n) {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	// Newest first for the "newest" policy.  The survivor wins ties.
+	order := append([]string{p.Survivor}, p.Losers...)
+	modified := func(k string) time.Time {
+		t, _ := ParseTime(records[k].Get("Last_Modified"))
+		return t
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return modified(order[i]).After(modified(order[j]))
+	})
+
+	var a []MergeStep
+	for _, n := range names {
+		old := s.Get(n)
+		from, value := "", ""
+		switch m.Policy {
+		case "", "fill":
+			if len(strings.TrimSpace(old)) != 0 {
+				continue
+			}
+			for _, k := range p.Losers {
+				if v := records[k].Get(n); len(strings.TrimSpace(v)) != 0 {
+					from, value = k, v
+					break
+				}
+			}
+		case "newest":
+			for _, k := range order {
+				if v := records[k].Get(n); len(strings.TrimSpace(v)) != 0 {
+					from, value = k, v
+					break
+				}
+			}
+		case "none":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("merge: unknown policy '%s', use one of %s", m.Policy, strings.Join(MergePolicies, ", "))
+		}
+		if len(from) == 0 || from == p.Survivor || value == old {
+			continue
+		}
+		a = append(a, MergeStep{Op: "update", Table: p.Table, Key: p.Survivor, Field: n,
+			Old: old, New: value, Why: "from " + from})
+	}
+	return a, nil
+}
+
+//Execute makes the changes in a plan.  Each step is written to the undo
+//log before it's made, so the log always has enough to reverse what was
+//done.  Deleted records are saved in the log.  Execute stops at the first
+//error and returns the number of steps that were done.
+//
+//With the API in dry-run mode, changes are rehearsed and nothing is
+//written to the undo log.
+//
+//Merging is traced as a "merge" stage.
+func (m *Merger) Execute(ctx context.Context, p *MergePlan) (int, error) {
+	ctx, span := Stage(ctx, "merge "+p.Table,
+		attribute.String("salsa.table", p.Table),
+		attribute.String("merge.survivor", p.Survivor),
+		attribute.Int("merge.steps", len(p.Steps)))
+	n, err := m.execute(ctx, p)
+	EndStage(span, err)
+	return n, err
+}
+
+//execute does the work for Execute.
+func (m *Merger) execute(ctx context.Context, p *MergePlan) (int, error) {
+	var undo *UndoLog
+	if !m.API.DryRun {
+		if len(m.UndoPath) == 0 {
+			return 0, errors.New("merge: no undo log")
+		}
+		var err error
+		undo, err = OpenUndoLog(m.UndoPath)
+		if err != nil {
+			return 0, err
+		}
+		defer undo.Close()
+	}
+	m.Progress.Start("merge "+p.Survivor, len(p.Steps), 0)
+	defer m.Progress.Finish()
+	for i, s := range p.Steps {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		t := m.API.NewTable(s.Table)
+		e := UndoEntry{MergeStep: s, Survivor: p.Survivor}
+		if s.Op == "delete" && undo != nil {
+			// The log has to have what's deleted, not what was cached.
+			body, err := t.oneRaw(uncached(ctx), s.Key)
+			if err != nil {
+				return i, fmt.Errorf("merge: reading %s %s: %v", s.Table, s.Key, err)
+			}
+			e.Record = json.RawMessage(strings.TrimSpace(string(body)))
+			if !json.Valid(e.Record) {
+				return i, fmt.Errorf("merge: %s %s is not a valid record, '%s'", s.Table, s.Key, string(body))
+			}
+		}
+		if undo != nil {
+			err := undo.Append(e)
+			if err != nil {
+				return i, err
+			}
+		}
+		var err error
+		switch s.Op {
+		case "move", "update":
+			_, _, err = t.SaveRecord(ctx, s.Key, map[string]string{s.Field: s.New})
+		case "delete":
+			var ds DeleteStatus
+			err = t.Delete(s.Key, &ds)
+			if err == nil && ds.Result == "error" {
+				err = fmt.Errorf("delete %s key %s: %v", s.Table, s.Key, ds.Messages)
+			}
+		default:
+			err = fmt.Errorf("unknown op '%s'", s.Op)
+		}
+		if err != nil {
+			return i, fmt.Errorf("merge: %v: %v", s, err)
+		}
+		m.Progress.Add(1)
+	}
+	return len(p.Steps), nil
+}
+
+//UndoEntry is a line in an undo log.  Record is the record as Salsa
+//returned it before a delete.
+type UndoEntry struct {
+	MergeStep
+	Survivor string          `json:"survivor"`
+	Time     string          `json:"time"`
+	Record   json.RawMessage `json:"record,omitempty"`
+}
+
+//UndoLog is a JSON Lines file of merge steps.  It's safe to use from many
+//goroutines.
+type UndoLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+//OpenUndoLog opens an undo log for appending.  The file is created if it
+//does not exist.
+func OpenUndoLog(p string) (*UndoLog, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &UndoLog{f: f}, nil
+}
+
+//Append writes an entry to the log.  Entries are written immediately so
+//that a crash doesn't lose them.
+func (u *UndoLog) Append(e UndoEntry) error {
+	e.Time = time.Now().Format(time.RFC3339)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, err = u.f.Write(b)
+	return err
+}
+
+//Close closes the log.
+func (u *UndoLog) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.f.Close()
+}
+
+//ReadUndoLog reads all of the entries in an undo log.
+func ReadUndoLog(r io.Reader) ([]UndoEntry, error) {
+	var a []UndoEntry
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		b := strings.TrimSpace(s.Text())
+		if len(b) == 0 {
+			continue
+		}
+		var x UndoEntry
+		err := json.Unmarshal([]byte(b), &x)
+		if err != nil {
+			return a, fmt.Errorf("line %d: %v", line, err)
+		}
+		a = append(a, x)
+	}
+	return a, s.Err()
+}
+
+//Undo reverses the entries in an undo log, last first.  Deleted records
+//are restored with their original keys.  If Salsa gives a restored record
+//a new key, later steps use the new key, and so do the restored records
+//that point at it.  The callback, if any, sees every result.
+func (m *Merger) Undo(ctx context.Context, entries []UndoEntry, fn func(RestoreResult)) (ok int, failed int, err error) {
+	m.Progress.Start("undo", len(entries), 0)
+	defer m.Progress.Finish()
+	r := Restorer{API: m.API, PreserveKeys: true}
+	if m.Dependents != nil {
+		r.Dependents = map[string][]Dependent{m.table(): m.Dependents}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return ok, failed, ctx.Err()
+		}
+		e := entries[i]
+		var rr RestoreResult
+		switch e.Op {
+		case "delete":
+			rr = r.restore(ctx, ArchivedRecord{Object: e.Table, Key: e.Key, Record: e.Record})
+		case "move", "update":
+			old := e.Old
+			if e.Op == "move" {
+				old = r.newKey(m.table(), old)
+			}
+			rr = RestoreResult{Object: e.Table, OldKey: e.Key, NewKey: e.Key}
+			t := m.API.NewTable(e.Table)
+			_, sr, err := t.SaveRecord(ctx, e.Key, map[string]string{e.Field: old})
+			rr.Result, rr.Messages = sr.Result, sr.Messages
+			if err != nil {
+				rr.Result = "error"
+				if sr.Result != "error" {
+					rr.Messages = append(rr.Messages, err.Error())
+				}
+			}
+		default:
+			rr = RestoreResult{Object: e.Table, OldKey: e.Key, Result: "error", Messages: []string{"unknown op " + e.Op}}
+		}
+		if rr.Result == "success" {
+			ok++
+		} else {
+			failed++
+		}
+		if fn != nil {
+			fn(rr)
+		}
+		m.Progress.Add(1)
+	}
+	return ok, failed, nil
+}
@@ -0,0 +1,194 @@
+package godig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergerFields(t *testing.T) {
+	records := map[string]*Record{
+		"1": matchRecord("supporter_KEY", "1", "First_Name", "Robert", "Phone", "", "Zip", "12345",
+			"Last_Modified", "Mon Jan 02 2023 10:00:00 GMT-0500 (EST)"),
+		"2": matchRecord("supporter_KEY", "2", "First_Name", "Bob", "Phone", "555-1234", "Zip", "",
+			"Last_Modified", "Tue Jan 02 2024 10:00:00 GMT-0500 (EST)"),
+		"3": matchRecord("supporter_KEY", "3", "First_Name", "Rob", "Phone", "555-9999", "Zip", "54321",
+			"Last_Modified", "Sat Jan 02 2021 10:00:00 GMT-0500 (EST)"),
+	}
+	p := &MergePlan{Table: "supporter", Survivor: "1", Losers: []string{"3", "2"}}
+	tests := []struct {
+		policy string
+		fields []string
+		want   []string
+	}{
+		{"fill", nil, []string{"Phone 555-9999 from 3"}},
+		{"", nil, []string{"Phone 555-9999 from 3"}},
+		{"newest", nil, []string{"First_Name Bob from 2", "Phone 555-1234 from 2"}},
+		{"newest", []string{"Phone"}, []string{"Phone 555-1234 from 2"}},
+		{"fill", []string{"First_Name"}, nil},
+		{"none", nil, nil},
+	}
+	for _, x := range tests {
+		m := Merger{Policy: x.policy, Fields: x.fields}
+		steps, err := m.fields(p, records)
+		if err != nil {
+			t.Fatalf("%s: %v", x.policy, err)
+		}
+		var got []string
+		for _, s := range steps {
+			if s.Op != "update" || s.Key != "1" {
+				t.Errorf("%s: step %v", x.policy, s)
+			}
+			got = append(got, s.Field+" "+s.New+" "+s.Why)
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s %v: %q, want %q", x.policy, x.fields, got, x.want)
+		}
+	}
+	m := Merger{Policy: "oldest"}
+	if _, err := m.fields(p, records); err == nil {
+		t.Errorf("unknown policy isn't an error")
+	}
+}
+
+//mergeSalsa returns a fake Salsa with a survivor, 1, and a duplicate, 2.
+func mergeSalsa(t *testing.T) (*fakeSalsa, *API) {
+	f, a := newFakeSalsa(t)
+	f.put("supporter", "1", "Email", "bob@example.com", "First_Name", "")
+	f.put("supporter", "2", "Email", "", "First_Name", "Bob")
+	f.put("donation", "30", "supporter_KEY", "2")
+	f.put("supporter_groups", "10", "supporter_KEY", "1", "groups_KEY", "5")
+	f.put("supporter_groups", "11", "supporter_KEY", "2", "groups_KEY", "5")
+	f.put("supporter_groups", "12", "supporter_KEY", "2", "groups_KEY", "6")
+	f.put("tag_data", "20", "table_KEY", "2", "database_table_KEY", SupporterTableKey, "tag_KEY", "9")
+	f.put("tag_data", "21", "table_KEY", "2", "database_table_KEY", DonationTableKey, "tag_KEY", "9")
+	return f, a
+}
+
+func TestMergeAndUndo(t *testing.T) {
+	f, a := mergeSalsa(t)
+	ctx := context.Background()
+	m := Merger{API: a, UndoPath: filepath.Join(t.TempDir(), "undo.jsonl")}
+	p, err := m.Plan(ctx, Merge{Survivor: "1", Losers: []string{"2", "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range p.Steps {
+		got = append(got, s.Op+" "+s.Table+" "+s.Key+" "+s.Field+" "+s.New)
+	}
+	want := []string{
+		"move donation 30 supporter_KEY 1",
+		"delete supporter_groups 11  ",
+		"move supporter_groups 12 supporter_KEY 1",
+		"move tag_data 20 table_KEY 1",
+		"delete supporter 2  ",
+		"update supporter 1 First_Name Bob",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("plan %q, want %q", got, want)
+	}
+	n, err := m.Execute(ctx, p)
+	if err != nil || n != len(p.Steps) {
+		t.Fatalf("%d steps, %v", n, err)
+	}
+	checks := []struct {
+		table, key, field, want string
+	}{
+		{"donation", "30", "supporter_KEY", "1"},
+		{"supporter_groups", "12", "supporter_KEY", "1"},
+		{"tag_data", "20", "table_KEY", "1"},
+		{"tag_data", "21", "table_KEY", "2"},
+		{"supporter", "1", "First_Name", "Bob"},
+	}
+	for _, c := range checks {
+		if got := f.get(c.table, c.key)[c.field]; got != c.want {
+			t.Errorf("merged %s %s %s is %s, want %s", c.table, c.key, c.field, got, c.want)
+		}
+	}
+	if f.get("supporter", "2") != nil || f.get("supporter_groups", "11") != nil {
+		t.Errorf("losers weren't deleted")
+	}
+
+	// Salsa gives the restored records new keys.
+	f.newKeys = true
+	r, err := os.Open(m.UndoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entries, err := ReadUndoLog(r)
+	if err != nil || len(entries) != len(p.Steps) {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+	keys := make(map[string]string)
+	ok, failed, err := m.Undo(ctx, entries, func(rr RestoreResult) {
+		keys[rr.Object+" "+rr.OldKey] = rr.NewKey
+	})
+	if err != nil || failed != 0 || ok != len(entries) {
+		t.Fatalf("%d undone, %d failed, %v", ok, failed, err)
+	}
+	loser, group := keys["supporter 2"], keys["supporter_groups 11"]
+	if loser == "2" || group == "11" {
+		t.Fatalf("keys didn't change, %v", keys)
+	}
+	checks = []struct {
+		table, key, field, want string
+	}{
+		{"supporter", loser, "First_Name", "Bob"},
+		{"donation", "30", "supporter_KEY", loser},
+		{"supporter_groups", group, "supporter_KEY", loser},
+		{"supporter_groups", group, "groups_KEY", "5"},
+		{"supporter_groups", "12", "supporter_KEY", loser},
+		{"tag_data", "20", "table_KEY", loser},
+		{"tag_data", "21", "table_KEY", "2"},
+		{"supporter", "1", "First_Name", ""},
+	}
+	for _, c := range checks {
+		if got := f.get(c.table, c.key)[c.field]; got != c.want {
+			t.Errorf("undone %s %s %s is %s, want %s", c.table, c.key, c.field, got, c.want)
+		}
+	}
+}
+
+func TestMergePlanSkipsCache(t *testing.T) {
+	f, a := mergeSalsa(t)
+	a.Cache = NewCache("")
+	a.Cache.TTLs["supporter"] = time.Hour
+	a.Cache.TTLs["supporter_groups"] = time.Hour
+	tb := a.Supporter()
+	if _, err := tb.OneRecord("1"); err != nil {
+		t.Fatal(err)
+	}
+	m := Merger{API: a}
+	x := &MergePlan{Table: "supporter", Survivor: "1", Losers: []string{"2"}}
+	for _, d := range m.dependents() {
+		if d.Table == "supporter_groups" {
+			if _, err := m.children(context.Background(), d, x); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	f.put("supporter", "1", "Email", "bob@example.com", "First_Name", "Robert")
+	f.put("supporter_groups", "13", "supporter_KEY", "2", "groups_KEY", "7")
+	p, err := m.Plan(context.Background(), Merge{Survivor: "1", Losers: []string{"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var moves []string
+	for _, s := range p.Steps {
+		if s.Op == "update" {
+			t.Errorf("plan used a cached survivor: %v", s)
+		}
+		if s.Table == "supporter_groups" {
+			moves = append(moves, s.Op+" "+s.Key)
+		}
+	}
+	want := []string{"delete 11", "move 12", "move 13"}
+	if !reflect.DeepEqual(moves, want) {
+		t.Errorf("plan used cached supporter_groups, %q, want %q", moves, want)
+	}
+}
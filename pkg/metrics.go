@@ -0,0 +1,175 @@
+package godig
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//Metrics counts and times the requests that an API makes to Salsa.  Set
+//API.Metrics to collect them.  A nil Metrics collects nothing.
+//
+//Metrics can be served for Prometheus to scrape with Serve, or written
+//for the node exporter's textfile collector with WriteTextfile.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+	retries  *prometheus.CounterVec
+	records  *prometheus.CounterVec
+	cache    *prometheus.CounterVec
+	inFlight prometheus.Gauge
+}
+
+//NewMetrics creates the metrics and registers them in a new registry.
+func NewMetrics() *Metrics {
+	m := Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "godig_requests_total",
+			Help: "Requests to Salsa by endpoint, table and status class.",
+		}, []string{"host", "endpoint", "table", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "godig_request_duration_seconds",
+			Help:    "Time to get a complete response from Salsa.",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"host", "endpoint", "table"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "godig_bytes_total",
+			Help: "Bytes sent to and received from Salsa.",
+		}, []string{"host", "endpoint", "table", "direction"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "godig_retries_total",
+			Help: "Requests that were tried again after failing.",
+		}, []string{"endpoint", "table"}),
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "godig_records_decoded_total",
+			Help: "Records decoded from Salsa's responses.",
+		}, []string{"table"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "godig_cache_requests_total",
+			Help: "Reads that were, or weren't, found in the cache.",
+		}, []string{"table", "result"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "godig_requests_in_flight",
+			Help: "Requests waiting for a response from Salsa.",
+		}),
+	}
+	m.Registry.MustRegister(m.requests, m.duration, m.bytes, m.retries, m.records, m.cache, m.inFlight)
+	return &m
+}
+
+//endpoint returns the endpoint and table for a request.  The endpoint is
+//the last part of the path, like "getObjects.sjs" or "save".  The table
+//is the "object" parameter.
+func endpoint(u *url.URL) (string, string) {
+	return path.Base(u.Path), u.Query().Get("object")
+}
+
+//statusClass returns "2xx", "4xx" and so on, or "error" if there was no
+//response.
+func statusClass(resp *http.Response) string {
+	if resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+//begin is called before a request is sent.  The function that it returns
+//is called when the response has been read.
+func (m *Metrics) begin(req *http.Request, table string, sent int) func(resp *http.Response, received int) {
+	if m == nil {
+		return func(*http.Response, int) {}
+	}
+	start := time.Now()
+	m.inFlight.Inc()
+	e, t := endpoint(req.URL)
+	if len(table) != 0 {
+		t = table
+	}
+	h := req.URL.Host
+	return func(resp *http.Response, received int) {
+		m.inFlight.Dec()
+		m.duration.WithLabelValues(h, e, t).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(h, e, t, statusClass(resp)).Inc()
+		m.bytes.WithLabelValues(h, e, t, "sent").Add(float64(sent))
+		m.bytes.WithLabelValues(h, e, t, "received").Add(float64(received))
+	}
+}
+
+//Retry counts a request that is being tried again.
+func (m *Metrics) Retry(endpoint, table string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(endpoint, table).Inc()
+}
+
+//Decoded counts records decoded from a response.
+func (m *Metrics) Decoded(table string, n int) {
+	if m == nil {
+		return
+	}
+	m.records.WithLabelValues(table).Add(float64(n))
+}
+
+//Cached counts a read that was looked for in the cache.
+func (m *Metrics) Cached(table string, hit bool) {
+	if m == nil {
+		return
+	}
+	r := "miss"
+	if hit {
+		r = "hit"
+	}
+	m.cache.WithLabelValues(table, r).Inc()
+}
+
+//decodedSlice counts the records in a slice that a response was
+//unmarshalled into.
+func (m *Metrics) decodedSlice(table string, target interface{}) {
+	if m == nil {
+		return
+	}
+	v := reflect.ValueOf(target)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		m.Decoded(table, v.Len())
+	}
+}
+
+//Handler returns an HTTP handler that serves the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+//Serve serves the metrics at "/metrics" on addr, like ":9100".  The
+//server runs until the program exits.  Errors from the listener are
+//returned right away.
+func (m *Metrics) Serve(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	go http.Serve(l, mux)
+	return nil
+}
+
+//WriteTextfile writes the metrics to a file for the node exporter's
+//textfile collector.  The file is replaced in one step.
+func (m *Metrics) WriteTextfile(p string) error {
+	return prometheus.WriteToTextfile(p, m.Registry)
+}
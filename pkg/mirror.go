@@ -0,0 +1,507 @@
+package godig
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//WatermarkTable is the SQL table where Mirror records how far each
+//table has been synced.
+const WatermarkTable = "godig_sync"
+
+//Mirror copies Salsa tables into a SQL database.  Tables are created
+//from Describe.  Records are upserted on their primary key, so a mirror
+//can be run again to refresh it.
+//
+//Mirror does not import any SQL drivers.  Import the driver for your
+//database (github.com/mattn/go-sqlite3, github.com/go-sql-driver/mysql
+//or github.com/lib/pq) in your main package.
+type Mirror struct {
+	DB *sql.DB
+	//Driver is "sqlite3", "mysql" or "postgres".
+	Driver string
+	//Profile names the Salsa login that the data comes from.  Watermarks
+	//are kept for each profile and table, so one database can hold
+	//watermarks for more than one login.
+	Profile string
+}
+
+//Watermark records the last time that a table was synced.
+type Watermark struct {
+	Profile string
+	Table   string
+	//LastModified is the newest Last_Modified seen in the table.  It
+	//keeps Salsa's time zone so that it can be used in criteria.
+	LastModified time.Time
+	//Rows is the number of records written by the last sync.
+	Rows int
+	//Synced is when the last sync finished.
+	Synced time.Time
+	//Reconciled is when deleted records were last looked for.
+	Reconciled time.Time
+}
+
+//OpenMirror opens a SQL database for mirroring.  Driver names "sqlite",
+//"postgresql" and "pq" are accepted as aliases.
+func OpenMirror(driver, dsn string) (*Mirror, error) {
+	switch strings.ToLower(driver) {
+	case "sqlite", "sqlite3":
+		driver = "sqlite3"
+	case "postgres", "postgresql", "pq":
+		driver = "postgres"
+	case "mysql":
+		driver = "mysql"
+	default:
+		return nil, fmt.Errorf("unknown SQL driver '%s', use sqlite3, mysql or postgres", driver)
+	}
+	if len(dsn) == 0 {
+		return nil, fmt.Errorf("%s needs a DSN", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	m := &Mirror{DB: db, Driver: driver}
+	return m, m.createWatermarks()
+}
+
+//Close closes the database.
+func (m *Mirror) Close() error {
+	return m.DB.Close()
+}
+
+//column is a SQL column created from a Salsa field.
+type column struct {
+	name string
+	kind string
+}
+
+//Column kinds.  Values are converted from Salsa's strings to match.
+const (
+	kindText    = "text"
+	kindInt     = "int"
+	kindFloat   = "float"
+	kindBool    = "bool"
+	kindTime    = "time"
+	kindPrimary = "key"
+)
+
+//columnKind returns the column kind for a Salsa field type.
+func columnKind(t string) string {
+	t = strings.ToLower(t)
+	switch {
+	case strings.Contains(t, "bool"):
+		return kindBool
+	case strings.Contains(t, "int"):
+		return kindInt
+	case strings.Contains(t, "float"), strings.Contains(t, "double"),
+		strings.Contains(t, "decimal"), strings.Contains(t, "currency"):
+		return kindFloat
+	case strings.Contains(t, "date"), strings.Contains(t, "time"):
+		return kindTime
+	}
+	return kindText
+}
+
+//sqlType returns the SQL type for a column kind.
+func (m *Mirror) sqlType(kind string) string {
+	switch m.Driver {
+	case "postgres":
+		return map[string]string{
+			kindPrimary: "BIGINT PRIMARY KEY",
+			kindInt:     "BIGINT",
+			kindFloat:   "DOUBLE PRECISION",
+			kindBool:    "BOOLEAN",
+			kindTime:    "TIMESTAMP",
+		}[kind]
+	case "mysql":
+		return map[string]string{
+			kindPrimary: "BIGINT PRIMARY KEY",
+			kindInt:     "BIGINT",
+			kindFloat:   "DOUBLE",
+			kindBool:    "BOOLEAN",
+			kindTime:    "DATETIME",
+		}[kind]
+	}
+	// SQLite stores times as text, "2006-01-02 15:04:05".
+	return map[string]string{
+		kindPrimary: "INTEGER PRIMARY KEY",
+		kindInt:     "INTEGER",
+		kindFloat:   "REAL",
+		kindBool:    "BOOLEAN",
+		kindTime:    "TEXT",
+	}[kind]
+}
+
+//sqlTypeOf returns the SQL type for a column.  Text is the default.
+func (m *Mirror) sqlTypeOf(kind string) string {
+	if s := m.sqlType(kind); len(s) != 0 {
+		return s
+	}
+	return "TEXT"
+}
+
+//quote quotes a table or column name.
+func (m *Mirror) quote(s string) string {
+	if m.Driver == "mysql" {
+		return "`" + strings.Replace(s, "`", "``", -1) + "`"
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+//placeholder returns the nth (from one) statement parameter.
+func (m *Mirror) placeholder(n int) string {
+	if m.Driver == "postgres" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+//columns returns the columns for a table.  The primary key is first.
+func (m *Mirror) columns(t *Table, fields FieldList) []column {
+	pk := t.Name + "_KEY"
+	a := []column{{name: pk, kind: kindPrimary}}
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, pk) {
+			continue
+		}
+		a = append(a, column{name: f.Name, kind: columnKind(f.Type)})
+	}
+	return a
+}
+
+//CreateTable creates a SQL table for a Salsa table.  Fields that were
+//added in Salsa since the table was created are added as new columns.
+func (m *Mirror) CreateTable(ctx context.Context, t *Table, fields FieldList) error {
+	cols := m.columns(t, fields)
+	var defs []string
+	for _, c := range cols {
+		defs = append(defs, m.quote(c.name)+" "+m.sqlTypeOf(c.kind))
+	}
+	s := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", m.quote(t.Name), strings.Join(defs, ", "))
+	_, err := m.DB.ExecContext(ctx, s)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", t.Name, err)
+	}
+	have, err := m.existing(ctx, t.Name)
+	if err != nil {
+		return err
+	}
+	for _, c := range cols {
+		if have[strings.ToLower(c.name)] {
+			continue
+		}
+		s := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.quote(t.Name), m.quote(c.name), m.sqlTypeOf(c.kind))
+		_, err := m.DB.ExecContext(ctx, s)
+		if err != nil {
+			return fmt.Errorf("adding %s.%s: %v", t.Name, c.name, err)
+		}
+	}
+	return nil
+}
+
+//existing returns the lowercase names of the columns in a SQL table.
+func (m *Mirror) existing(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := m.DB.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE 1=0", m.quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	a, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool)
+	for _, c := range a {
+		have[strings.ToLower(c)] = true
+	}
+	return have, nil
+}
+
+//upsertSQL returns a statement that inserts a record, or updates it if
+//the primary key exists.  The first nkeys columns are the primary key.
+func (m *Mirror) upsertSQL(table string, cols []column, nkeys int) string {
+	var names, params, sets []string
+	for i, c := range cols {
+		q := m.quote(c.name)
+		names = append(names, q)
+		params = append(params, m.placeholder(i+1))
+		if i < nkeys {
+			continue
+		}
+		if m.Driver == "mysql" {
+			sets = append(sets, fmt.Sprintf("%s=VALUES(%s)", q, q))
+		} else {
+			sets = append(sets, fmt.Sprintf("%s=excluded.%s", q, q))
+		}
+	}
+	s := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.quote(table), strings.Join(names, ", "), strings.Join(params, ", "))
+	switch {
+	case len(sets) == 0 && m.Driver == "mysql":
+		return strings.Replace(s, "INSERT", "INSERT IGNORE", 1)
+	case len(sets) == 0:
+		return s + fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(names[:nkeys], ", "))
+	case m.Driver == "mysql":
+		return s + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+	return s + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(names[:nkeys], ", "), strings.Join(sets, ", "))
+}
+
+//value converts a Salsa string to a SQL value.  Empty and unreadable
+//values are NULL, except for text.
+func (m *Mirror) value(kind, s string) interface{} {
+	if kind == kindText {
+		return s
+	}
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil
+	}
+	switch kind {
+	case kindPrimary, kindInt:
+		if x, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return x
+		}
+	case kindFloat:
+		if x, err := strconv.ParseFloat(s, 64); err == nil {
+			return x
+		}
+	case kindBool:
+		if x, err := strconv.ParseBool(s); err == nil {
+			return x
+		}
+	case kindTime:
+		if x, err := ParseTime(s); err == nil {
+			x = x.UTC()
+			if m.Driver == "sqlite3" {
+				return x.Format("2006-01-02 15:04:05")
+			}
+			return x
+		}
+	}
+	return nil
+}
+
+//Upsert writes records to a table.  Records are written in a single
+//transaction.
+func (m *Mirror) Upsert(ctx context.Context, t *Table, fields FieldList, records []*Record) error {
+	ctx, span := Stage(ctx, "upsert "+t.Name,
+		attribute.String("salsa.table", t.Name),
+		attribute.Int("records", len(records)))
+	err := m.upsert(ctx, t, fields, records)
+	EndStage(span, err)
+	return err
+}
+
+//upsert does the work for Upsert.
+func (m *Mirror) upsert(ctx context.Context, t *Table, fields FieldList, records []*Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	cols := m.columns(t, fields)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, m.upsertSQL(t.Name, cols, 1))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	args := make([]interface{}, len(cols))
+	for _, r := range records {
+		for i, c := range cols {
+			args[i] = m.value(c.kind, r.Get(c.name))
+		}
+		if args[0] == nil {
+			tx.Rollback()
+			return fmt.Errorf("%s record without a primary key", t.Name)
+		}
+		_, err = stmt.ExecContext(ctx, args...)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s key %v: %v", t.Name, args[0], err)
+		}
+	}
+	return tx.Commit()
+}
+
+//Run mirrors the records in a table that match the query.  The table is
+//created or updated first.  Run records a watermark when it finishes.
+//The function, if not nil, sees the running total after each page.
+func (m *Mirror) Run(ctx context.Context, t *Table, q Query, fn func(rows int)) (int, error) {
+	fields, err := m.Prepare(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	w, err := m.Watermark(ctx, t.Name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := m.copy(ctx, t, fields, q, t.Scan, &w, fn)
+	if err != nil {
+		return n, err
+	}
+	return n, m.SetWatermark(ctx, w)
+}
+
+//Prepare describes a table and creates or updates its SQL table.
+func (m *Mirror) Prepare(ctx context.Context, t *Table) (FieldList, error) {
+	if t.IsJoin() {
+		return nil, fmt.Errorf("can't mirror a join, mirror each table instead")
+	}
+	fields, err := t.Describe()
+	if err != nil {
+		return fields, err
+	}
+	return fields, m.CreateTable(ctx, t, fields)
+}
+
+//scanFunc reads the records that match a query a page at a time, like
+//Table.Scan.
+type scanFunc func(ctx context.Context, q Query, fn func(page []*Record) error) error
+
+//copy upserts the records that the scan reads and updates the watermark.
+//The watermark is not saved.  A resumed copy counts the records that were
+//upserted before it was interrupted.
+func (m *Mirror) copy(ctx context.Context, t *Table, fields FieldList, q Query, scan scanFunc, w *Watermark, fn func(rows int)) (int, error) {
+	n := 0
+	if q.Checkpoint != nil {
+		n = int(q.Checkpoint.Offset)
+	}
+	err := scan(ctx, q, func(page []*Record) error {
+		err := m.Upsert(ctx, t, fields, page)
+		if err != nil {
+			return err
+		}
+		for _, r := range page {
+			if x, err := ParseTime(r.Get("Last_Modified")); err == nil && x.After(w.LastModified) {
+				w.LastModified = x
+			}
+		}
+		n += len(page)
+		if fn != nil {
+			fn(n)
+		}
+		return nil
+	})
+	if err == nil {
+		w.Rows = n
+		w.Synced = time.Now()
+	}
+	return n, err
+}
+
+//Count returns the number of rows in a SQL table.
+func (m *Mirror) Count(ctx context.Context, table string) (int, error) {
+	var n int
+	err := m.DB.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", m.quote(table))).Scan(&n)
+	return n, err
+}
+
+//Keys returns the primary keys in a SQL table.
+func (m *Mirror) Keys(ctx context.Context, table string) ([]string, error) {
+	s := fmt.Sprintf("SELECT %s FROM %s", m.quote(table+"_KEY"), m.quote(table))
+	rows, err := m.DB.QueryContext(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var a []string
+	for rows.Next() {
+		var k int64
+		err := rows.Scan(&k)
+		if err != nil {
+			return a, err
+		}
+		a = append(a, strconv.FormatInt(k, 10))
+	}
+	return a, rows.Err()
+}
+
+//DeleteKeys removes rows from a SQL table by primary key.
+func (m *Mirror) DeleteKeys(ctx context.Context, table string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	s := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", m.quote(table), m.quote(table+"_KEY"), m.placeholder(1))
+	for _, k := range keys {
+		_, err := tx.ExecContext(ctx, s, k)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//createWatermarks creates the watermark table.
+func (m *Mirror) createWatermarks() error {
+	cols := []string{
+		m.quote("profile") + " VARCHAR(128) NOT NULL",
+		m.quote("table_name") + " VARCHAR(64) NOT NULL",
+		m.quote("last_modified") + " VARCHAR(32)",
+		m.quote("row_count") + " " + m.sqlTypeOf(kindInt),
+		m.quote("synced") + " VARCHAR(32)",
+		m.quote("reconciled") + " VARCHAR(32)",
+		fmt.Sprintf("PRIMARY KEY (%s, %s)", m.quote("profile"), m.quote("table_name")),
+	}
+	s := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", m.quote(WatermarkTable), strings.Join(cols, ", "))
+	_, err := m.DB.Exec(s)
+	return err
+}
+
+//Watermark returns the watermark for a table and the mirror's profile.
+//Tables that haven't been synced have an empty watermark.
+func (m *Mirror) Watermark(ctx context.Context, table string) (Watermark, error) {
+	w := Watermark{Profile: m.Profile, Table: table}
+	s := fmt.Sprintf("SELECT %s, %s, %s, %s FROM %s WHERE %s = %s AND %s = %s",
+		m.quote("last_modified"), m.quote("row_count"), m.quote("synced"), m.quote("reconciled"),
+		m.quote(WatermarkTable), m.quote("profile"), m.placeholder(1), m.quote("table_name"), m.placeholder(2))
+	var lm, synced, rec string
+	err := m.DB.QueryRowContext(ctx, s, m.Profile, table).Scan(&lm, &w.Rows, &synced, &rec)
+	if err == sql.ErrNoRows {
+		return w, nil
+	}
+	if err != nil {
+		return w, err
+	}
+	w.LastModified, _ = time.Parse(time.RFC3339, lm)
+	w.Synced, _ = time.Parse(time.RFC3339, synced)
+	w.Reconciled, _ = time.Parse(time.RFC3339, rec)
+	return w, nil
+}
+
+//SetWatermark saves a watermark.
+func (m *Mirror) SetWatermark(ctx context.Context, w Watermark) error {
+	cols := []column{{name: "profile"}, {name: "table_name"}, {name: "last_modified"},
+		{name: "row_count"}, {name: "synced"}, {name: "reconciled"}}
+	_, err := m.DB.ExecContext(ctx, m.upsertSQL(WatermarkTable, cols, 2),
+		w.Profile, w.Table, formatWatermark(w.LastModified), w.Rows,
+		formatWatermark(w.Synced), formatWatermark(w.Reconciled))
+	return err
+}
+
+//formatWatermark formats a watermark time.  Zero is empty.
+func formatWatermark(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,129 @@
+package godig
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestMirrorSQL(t *testing.T) {
+	cols := []column{{name: "supporter_KEY", kind: kindPrimary}, {name: "First Name", kind: kindText}, {name: `a"b`, kind: kindInt}}
+	tests := []struct {
+		driver string
+		quote  string
+		upsert string
+	}{
+		{"sqlite3", `"a""b"`,
+			`INSERT INTO "supporter" ("supporter_KEY", "First Name", "a""b") VALUES (?, ?, ?)` +
+				` ON CONFLICT ("supporter_KEY") DO UPDATE SET "First Name"=excluded."First Name", "a""b"=excluded."a""b"`},
+		{"postgres", `"a""b"`,
+			`INSERT INTO "supporter" ("supporter_KEY", "First Name", "a""b") VALUES ($1, $2, $3)` +
+				` ON CONFLICT ("supporter_KEY") DO UPDATE SET "First Name"=excluded."First Name", "a""b"=excluded."a""b"`},
+		{"mysql", "`a\"b`",
+			"INSERT INTO `supporter` (`supporter_KEY`, `First Name`, `a\"b`) VALUES (?, ?, ?)" +
+				" ON DUPLICATE KEY UPDATE `First Name`=VALUES(`First Name`), `a\"b`=VALUES(`a\"b`)"},
+	}
+	for _, x := range tests {
+		m := Mirror{Driver: x.driver}
+		if got := m.quote(`a"b`); got != x.quote {
+			t.Errorf("%s: quote %s, want %s", x.driver, got, x.quote)
+		}
+		if got := m.upsertSQL("supporter", cols, 1); got != x.upsert {
+			t.Errorf("%s: upsert\n%s\nwant\n%s", x.driver, got, x.upsert)
+		}
+	}
+	m := Mirror{Driver: "mysql"}
+	if got := m.quote("a`b"); got != "`a``b`" {
+		t.Errorf("mysql quote %s", got)
+	}
+	if got := m.upsertSQL("t", cols[:1], 1); got != "INSERT IGNORE INTO `t` (`supporter_KEY`) VALUES (?)" {
+		t.Errorf("mysql keys only %s", got)
+	}
+	m = Mirror{Driver: "postgres"}
+	if got := m.upsertSQL("t", cols[:1], 1); got != `INSERT INTO "t" ("supporter_KEY") VALUES ($1) ON CONFLICT ("supporter_KEY") DO NOTHING` {
+		t.Errorf("postgres keys only %s", got)
+	}
+}
+
+//mirrorRows returns the rows in a mirrored supporter table by key.
+func mirrorRows(t *testing.T, db *sql.DB, columns string) map[string][]string {
+	rows, err := db.Query(`SELECT "supporter_KEY", ` + columns + ` FROM "supporter"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names, _ := rows.Columns()
+	m := make(map[string][]string)
+	for rows.Next() {
+		a := make([]sql.NullString, len(names))
+		p := make([]interface{}, len(a))
+		for i := range a {
+			p[i] = &a[i]
+		}
+		if err := rows.Scan(p...); err != nil {
+			t.Fatal(err)
+		}
+		var s []string
+		for _, x := range a[1:] {
+			v := x.String
+			if !x.Valid {
+				v = "NULL"
+			}
+			s = append(s, v)
+		}
+		m[a[0].String] = s
+	}
+	return m
+}
+
+func TestMirrorRun(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.fields["supporter"] = supporterFields
+	f.put("supporter", "1", "Email", "a@example.com", "Receive_Email", "1", "Last_Modified", modified(0))
+	f.put("supporter", "2", "Email", "b@example.com", "Receive_Email", "", "Last_Modified", modified(60))
+	m := openTestMirror(t)
+	tb := a.Supporter()
+	ctx := context.Background()
+	q := Query{OrderBy: []string{"supporter_KEY"}}
+	n, err := m.Run(ctx, &tb, q, nil)
+	if err != nil || n != 2 {
+		t.Fatalf("%d records, %v", n, err)
+	}
+	want := map[string][]string{
+		"1": {"a@example.com", "1", "2024-01-02 15:00:00"},
+		"2": {"b@example.com", "NULL", "2024-01-02 15:01:00"},
+	}
+	if got := mirrorRows(t, m.DB, `"Email", "Receive_Email", "Last_Modified"`); !reflect.DeepEqual(got, want) {
+		t.Errorf("first run %v, want %v", got, want)
+	}
+
+	// Salsa gets a new field, and a record changes.
+	f.fields["supporter"] = append(append(FieldList{}, supporterFields...), Field{Name: "Zip", Type: "varchar"})
+	f.put("supporter", "2", "Email", "new@example.com", "Receive_Email", "0", "Zip", "12345", "Last_Modified", modified(120))
+	n, err = m.Run(ctx, &tb, q, nil)
+	if err != nil || n != 2 {
+		t.Fatalf("%d records, %v", n, err)
+	}
+	want = map[string][]string{
+		"1": {"a@example.com", "1", "", "2024-01-02 15:00:00"},
+		"2": {"new@example.com", "0", "12345", "2024-01-02 15:02:00"},
+	}
+	if got := mirrorRows(t, m.DB, `"Email", "Receive_Email", "Zip", "Last_Modified"`); !reflect.DeepEqual(got, want) {
+		t.Errorf("second run %v, want %v", got, want)
+	}
+
+	var profile, table, last string
+	var rows int
+	err = m.DB.QueryRow(`SELECT "profile", "table_name", "last_modified", "row_count" FROM "godig_sync"`).Scan(&profile, &table, &last, &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile != "test" || table != "supporter" || last != "2024-01-02T10:02:00-05:00" || rows != 2 {
+		t.Errorf("watermark %s %s %s %d", profile, table, last, rows)
+	}
+	w, err := m.Watermark(ctx, "supporter")
+	if err != nil || w.LastModified.Format("2006-01-02 15:04:05") != "2024-01-02 10:02:00" || w.Synced.IsZero() {
+		t.Errorf("watermark %+v, %v", w, err)
+	}
+}
@@ -0,0 +1,275 @@
+package godig
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+//NormalizeName returns a name in lower case without accents, punctuation
+//or extra spaces.  "José  O'Brien" becomes "jose obrien".
+func NormalizeName(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	x, _, err := transform.String(t, s)
+	if err != nil {
+		x = s
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(x) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-':
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+//NormalizeEmail returns an email address in lower case without a "+tag".
+//Gmail ignores dots, so they're removed from Gmail addresses.  Returns
+//an empty string for things that aren't email addresses.
+func NormalizeEmail(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.LastIndex(s, "@")
+	if i < 1 || i == len(s)-1 {
+		return ""
+	}
+	local, domain := s[:i], s[i+1:]
+	if j := strings.Index(local, "+"); j > 0 {
+		local = local[:j]
+	}
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local = strings.Replace(local, ".", "", -1)
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+//EmailDomain returns the domain of an email address in lower case.
+func EmailDomain(s string) string {
+	s = NormalizeEmail(s)
+	return s[strings.LastIndex(s, "@")+1:]
+}
+
+//NormalizePhone returns the digits of a phone number.  The "1" in front
+//of an 11-digit North American number is removed.  Returns an empty
+//string for numbers with fewer than seven digits.
+func NormalizePhone(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	x := b.String()
+	if len(x) == 11 && x[0] == '1' {
+		x = x[1:]
+	}
+	if len(x) < 7 {
+		return ""
+	}
+	return x
+}
+
+//NormalizeZip returns the first five digits of a US zip code, or the
+//whole postal code in upper case without spaces for other countries.
+func NormalizeZip(s string) string {
+	s = strings.ToUpper(strings.Replace(strings.TrimSpace(s), " ", "", -1))
+	if len(s) >= 5 && strings.Trim(s[:5], "0123456789") == "" {
+		return s[:5]
+	}
+	return s
+}
+
+//streetWords are the USPS abbreviations for common words in street
+//addresses.
+var streetWords = map[string]string{
+	"north": "n", "south": "s", "east": "e", "west": "w",
+	"northeast": "ne", "northwest": "nw", "southeast": "se", "southwest": "sw",
+	"avenue": "ave", "av": "ave", "boulevard": "blvd", "circle": "cir",
+	"court": "ct", "drive": "dr", "highway": "hwy", "lane": "ln",
+	"parkway": "pkwy", "place": "pl", "road": "rd", "square": "sq",
+	"street": "st", "terrace": "ter", "trail": "trl", "way": "way",
+	"apartment": "apt", "suite": "ste", "floor": "fl", "building": "bldg",
+	"number": "#", "no": "#", "unit": "#",
+	"first": "1st", "second": "2nd", "third": "3rd", "fourth": "4th",
+	"fifth": "5th", "sixth": "6th", "seventh": "7th", "eighth": "8th",
+	"ninth": "9th", "tenth": "10th",
+}
+
+//NormalizeStreet returns a street address in lower case with USPS
+//abbreviations and without punctuation.  "123 North Main Street, Apt. 4"
+//becomes "123 n main st apt 4".
+func NormalizeStreet(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '#':
+			b.WriteRune(r)
+		case r == '.' || r == '\'':
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	a := strings.Fields(b.String())
+	for i, w := range a {
+		if x, ok := streetWords[w]; ok {
+			a[i] = x
+		}
+	}
+	return strings.Join(a, " ")
+}
+
+//JaroWinkler returns the Jaro-Winkler similarity of two strings, from 0
+//for nothing in common to 1 for the same string.  Strings with the same
+//first few letters score higher.  Normalize names before comparing them.
+func JaroWinkler(a, b string) float64 {
+	s, t := []rune(a), []rune(b)
+	if len(s) == 0 || len(t) == 0 {
+		if len(s) == len(t) {
+			return 1
+		}
+		return 0
+	}
+	window := len(s)
+	if len(t) > window {
+		window = len(t)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	sm := make([]bool, len(s))
+	tm := make([]bool, len(t))
+	matches := 0
+	for i := range s {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(t) {
+			hi = len(t)
+		}
+		for j := lo; j < hi; j++ {
+			if !tm[j] && s[i] == t[j] {
+				sm[i], tm[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	// Count the matches that are out of order.
+	half, j := 0, 0
+	for i := range s {
+		if !sm[i] {
+			continue
+		}
+		for !tm[j] {
+			j++
+		}
+		if s[i] != t[j] {
+			half++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(s)) + m/float64(len(t)) + (m-float64(half/2))/m) / 3
+	prefix := 0
+	for prefix < 4 && prefix < len(s) && prefix < len(t) && s[prefix] == t[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+//DefaultNicknames are groups of names that belong to the same person.  A
+//name can be in more than one group.  Names are normalized.
+var DefaultNicknames = [][]string{
+	{"abigail", "abby", "gail"},
+	{"albert", "al", "bert", "bertie"},
+	{"alexander", "alex", "al", "sandy", "xander"},
+	{"alexandra", "alex", "alexa", "sandra", "sandy", "lexi"},
+	{"alfred", "al", "alf", "fred", "freddie"},
+	{"andrew", "andy", "drew"},
+	{"anthony", "tony"},
+	{"barbara", "barb", "barbie", "babs"},
+	{"benjamin", "ben", "benji", "benny"},
+	{"catherine", "katherine", "kathryn", "cathy", "kathy", "kate", "katie", "kat", "kit", "trina"},
+	{"charles", "charlie", "chuck", "chas", "chaz"},
+	{"christina", "christine", "chris", "chrissy", "tina"},
+	{"christopher", "chris", "kit", "topher"},
+	{"cynthia", "cindy"},
+	{"daniel", "dan", "danny"},
+	{"david", "dave", "davey"},
+	{"deborah", "debra", "deb", "debbie"},
+	{"donald", "don", "donny"},
+	{"dorothy", "dot", "dottie", "dolly"},
+	{"edward", "ed", "eddie", "ted", "teddy", "ned"},
+	{"elizabeth", "liz", "lizzie", "beth", "betsy", "betty", "eliza", "libby", "liza", "bess"},
+	{"eugene", "gene"},
+	{"frances", "fran", "frannie"},
+	{"francis", "frank", "fran"},
+	{"frederick", "fred", "freddie", "fritz"},
+	{"gerald", "gerry", "jerry"},
+	{"gregory", "greg"},
+	{"harold", "hal", "harry"},
+	{"henry", "hank", "harry", "hal"},
+	{"jacob", "jake"},
+	{"james", "jim", "jimmy", "jamie"},
+	{"janet", "jan"},
+	{"jeffrey", "geoffrey", "jeff", "geoff"},
+	{"jennifer", "jen", "jenny", "jenn"},
+	{"jessica", "jess", "jessie"},
+	{"john", "jack", "johnny", "jon"},
+	{"jonathan", "jon", "jonny", "nathan"},
+	{"joseph", "joe", "joey"},
+	{"joshua", "josh"},
+	{"judith", "judy"},
+	{"katherine", "kate", "katie", "kathy", "kay"},
+	{"kenneth", "ken", "kenny"},
+	{"lawrence", "laurence", "larry", "lars"},
+	{"leonard", "leo", "len", "lenny"},
+	{"margaret", "maggie", "meg", "peggy", "marge", "margie", "greta", "madge"},
+	{"matthew", "matt", "matty"},
+	{"michael", "mike", "mikey", "mick", "mickey"},
+	{"nicholas", "nick", "nicky", "nico"},
+	{"pamela", "pam"},
+	{"patricia", "pat", "patty", "patsy", "tricia", "trish"},
+	{"patrick", "pat", "paddy", "rick"},
+	{"peter", "pete"},
+	{"philip", "phillip", "phil"},
+	{"raymond", "ray"},
+	{"rebecca", "becky", "becca", "reba"},
+	{"richard", "rick", "ricky", "rich", "richie", "dick"},
+	{"robert", "rob", "robbie", "bob", "bobby", "bert"},
+	{"ronald", "ron", "ronnie"},
+	{"samantha", "sam", "sammy"},
+	{"samuel", "sam", "sammy"},
+	{"sandra", "sandy"},
+	{"stephen", "steven", "steve", "stevie"},
+	{"susan", "sue", "susie", "suzy"},
+	{"theodore", "ted", "teddy", "theo"},
+	{"thomas", "tom", "tommy"},
+	{"timothy", "tim", "timmy"},
+	{"victoria", "vicky", "vicki", "tori"},
+	{"william", "will", "bill", "billy", "willy", "liam"},
+	{"zachary", "zach", "zack"},
+}
+
+//nicknameIndex returns the groups that each name is in.
+func nicknameIndex(groups [][]string) map[string][]int {
+	m := make(map[string][]int)
+	for i, g := range groups {
+		for _, n := range g {
+			n = NormalizeName(n)
+			m[n] = append(m[n], i)
+		}
+	}
+	return m
+}
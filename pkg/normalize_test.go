@@ -0,0 +1,95 @@
+package godig
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Bob@Example.com", "bob@example.com"},
+		{" bob+news@example.com ", "bob@example.com"},
+		{"bob.smith@example.com", "bob.smith@example.com"},
+		{"Bob.Smith+x@gmail.com", "bobsmith@gmail.com"},
+		{"b.o.b@googlemail.com", "bob@gmail.com"},
+		{"+news@example.com", "+news@example.com"},
+		{"bob", ""},
+		{"@example.com", ""},
+		{"bob@", ""},
+	}
+	for _, x := range tests {
+		if got := NormalizeEmail(x.in); got != x.want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", x.in, got, x.want)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"José", "jose"},
+		{"O'Brien", "obrien"},
+		{" Mary-Ann ", "mary ann"},
+		{"", ""},
+	}
+	for _, x := range tests {
+		if got := NormalizeName(x.in); got != x.want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", x.in, got, x.want)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(555) 123-4567", "5551234567"},
+		{"1 555 123 4567", "5551234567"},
+		{"123-4567", "1234567"},
+		{"12345", ""},
+	}
+	for _, x := range tests {
+		if got := NormalizePhone(x.in); got != x.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", x.in, got, x.want)
+		}
+	}
+}
+
+func TestNormalizeStreet(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"123 North Main Street", "123 n main st"},
+		{"123 N. Main St.", "123 n main st"},
+		{"45 Oak Avenue, Apt #2", "45 oak ave apt #2"},
+		{"", ""},
+	}
+	for _, x := range tests {
+		if got := NormalizeStreet(x.in); got != x.want {
+			t.Errorf("NormalizeStreet(%q) = %q, want %q", x.in, got, x.want)
+		}
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.84},
+		{"dixon", "dicksonx", 0.813},
+		{"smith", "smith", 1},
+		{"abc", "xyz", 0},
+		{"", "", 1},
+	}
+	for _, x := range tests {
+		got := JaroWinkler(x.a, x.b)
+		if math.Abs(got-x.want) > 0.001 {
+			t.Errorf("JaroWinkler(%q, %q) = %.3f, want %.3f", x.a, x.b, got, x.want)
+		}
+	}
+}
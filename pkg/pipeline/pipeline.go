@@ -0,0 +1,89 @@
+//Package pipeline connects sources, transforms and sinks with channels.
+//
+//Commands read records from Salsa, do something to them and write them
+//somewhere.  Each step runs in its own goroutine.  The pipeline starts
+//them, waits for them and stops all of them when one fails or when the
+//context is cancelled.  Wait returns the first error.
+//
+//	p := pipeline.New(ctx)
+//	a := pipeline.From(p, pipeline.Records(&t, godig.Query{Criteria: crit}))
+//	a = pipeline.Filter(p, a, func(r *godig.Record) bool { return len(r.Get("Email")) != 0 })
+//	pipeline.To(p, a, pipeline.WriteCSV(w))
+//	err := p.Wait()
+//
+//Every stage closes its output channel when it's done, so the stage
+//after it sees the end of the data.
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+//Buffer is the size of the channels between stages.
+const Buffer = 100
+
+//Pipeline runs the stages of a pipeline and collects their errors.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+//New returns a pipeline.  Cancelling the context stops every stage.
+func New(ctx context.Context) *Pipeline {
+	p := Pipeline{}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return &p
+}
+
+//Context returns the pipeline's context.  It's cancelled when a stage
+//fails.
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+//Go runs a function in a goroutine that the pipeline waits for.  The
+//first error stops the pipeline.
+func (p *Pipeline) Go(fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := fn(p.ctx)
+		if err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+//fail records the first error and stops the other stages.
+func (p *Pipeline) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+//Wait waits for every stage to finish.  Returns the first error, or the
+//context's error if it was cancelled before the pipeline finished.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.once.Do(func() {
+		p.err = p.ctx.Err()
+	})
+	p.cancel()
+	return p.err
+}
+
+//send puts a value on a channel.  Returns the context's error if the
+//pipeline stops first.
+func send[T any](ctx context.Context, c chan<- T, x T) error {
+	select {
+	case c <- x:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
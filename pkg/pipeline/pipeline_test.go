@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	godig "github.com/salsalabs/godig/pkg"
+)
+
+//wait returns the pipeline's error, or fails if it doesn't stop.
+func wait(t *testing.T, p *Pipeline) error {
+	t.Helper()
+	c := make(chan error, 1)
+	go func() { c <- p.Wait() }()
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline didn't stop")
+	}
+	return nil
+}
+
+//count emits numbers from zero until the pipeline stops.
+func count(ctx context.Context, emit func(int) error) error {
+	for i := 0; ; i++ {
+		err := emit(i)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestStageError(t *testing.T) {
+	bad := errors.New("bad value")
+	p := New(context.Background())
+	a := From(p, count)
+	b := Map(p, a, func(ctx context.Context, x int) (int, error) {
+		if x == 3 {
+			return 0, bad
+		}
+		return x, nil
+	})
+	var got []int
+	To(p, b, Each(func(ctx context.Context, x int) error {
+		got = append(got, x)
+		return nil
+	}))
+	if err := wait(t, p); err != bad {
+		t.Errorf("error %v, want %v", err, bad)
+	}
+	if !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("sink saw %v", got)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx)
+	a := From(p, count)
+	b := MapN(p, a, 4, func(ctx context.Context, x int) (int, error) {
+		return x, nil
+	})
+	// The sinks stop reading, so every send blocks.
+	ToN(p, b, 3, func(ctx context.Context, in <-chan int) error {
+		<-ctx.Done()
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := wait(t, p); err != context.Canceled {
+		t.Errorf("error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMapN(t *testing.T) {
+	for _, n := range []int{0, 1, 8} {
+		p := New(context.Background())
+		a := From(p, Slice([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+		b := MapN(p, a, n, func(ctx context.Context, x int) (int, error) {
+			return x * 10, nil
+		})
+		var got []int
+		To(p, b, Each(func(ctx context.Context, x int) error {
+			got = append(got, x)
+			return nil
+		}))
+		if err := wait(t, p); err != nil {
+			t.Fatalf("%d workers: %v", n, err)
+		}
+		sort.Ints(got)
+		want := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: %v", n, got)
+		}
+		// A second close would have panicked.  The channel stays closed.
+		if _, ok := <-b; ok {
+			t.Errorf("%d workers: output isn't closed", n)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		in   []int
+		size int
+		want [][]int
+	}{
+		{[]int{1, 2, 3, 4, 5, 6, 7}, 3, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{[]int{1, 2, 3, 4}, 2, [][]int{{1, 2}, {3, 4}}},
+		{[]int{1, 2}, 0, [][]int{{1}, {2}}},
+		{nil, 3, nil},
+	}
+	for _, x := range tests {
+		p := New(context.Background())
+		var got [][]int
+		To(p, Batch(p, From(p, Slice(x.in)), x.size), Each(func(ctx context.Context, a []int) error {
+			got = append(got, a)
+			return nil
+		}))
+		if err := wait(t, p); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("Batch(%v, %d) = %v, want %v", x.in, x.size, got, x.want)
+		}
+		var flat []int
+		p = New(context.Background())
+		To(p, Flatten(p, Batch(p, From(p, Slice(x.in)), x.size)), Each(func(ctx context.Context, y int) error {
+			flat = append(flat, y)
+			return nil
+		}))
+		if err := wait(t, p); err != nil || !reflect.DeepEqual(flat, x.in) {
+			t.Errorf("Flatten(Batch(%v)) = %v, %v", x.in, flat, err)
+		}
+	}
+}
+
+func TestTaggedCancel(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Salsa is slow.
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(s.Close)
+	t.Cleanup(func() { close(release) })
+	a := godig.NewAPI()
+	a.Client = s.Client()
+	a.Host = strings.TrimPrefix(s.URL, "https://")
+	tb := a.Supporter()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p := New(ctx)
+	To(p, From(p, Tagged[map[string]string](&tb, "", "volunteer")), Each(func(ctx context.Context, x map[string]string) error {
+		return nil
+	}))
+	if err := wait(t, p); err == nil {
+		t.Errorf("tagged read wasn't cancelled")
+	}
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	godig "github.com/salsalabs/godig/pkg"
+)
+
+//Sink consumes values until the channel is closed.  A sink that stops
+//early returns an error, which stops the pipeline.
+type Sink[T any] func(ctx context.Context, in <-chan T) error
+
+//To runs a sink on a channel.
+func To[T any](p *Pipeline, in <-chan T, sink Sink[T]) {
+	p.Go(func(ctx context.Context) error {
+		return sink(ctx, in)
+	})
+}
+
+//ToN runs n copies of a sink on a channel.  Use it for sinks that wait
+//for Salsa, like Save and Delete.
+func ToN[T any](p *Pipeline, in <-chan T, n int, sink Sink[T]) {
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		To(p, in, sink)
+	}
+}
+
+//Each calls a function with each value.
+func Each[T any](fn func(ctx context.Context, x T) error) Sink[T] {
+	return func(ctx context.Context, in <-chan T) error {
+		for x := range in {
+			err := fn(ctx, x)
+			if err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+//WriteCSV writes records to CSV.  The header is the first record's
+//fields.  Fields that first appear in later records are not written.
+func WriteCSV(w io.Writer) Sink[*godig.Record] {
+	return func(ctx context.Context, in <-chan *godig.Record) error {
+		c := godig.NewCSVWriter(w)
+		for r := range in {
+			err := c.Write(r)
+			if err != nil {
+				return err
+			}
+		}
+		err := c.Flush()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+}
+
+//WriteRows writes values to CSV after a header row.  The function
+//returns the row for each value, in header order.
+func WriteRows[T any](w io.Writer, headers []string, fn func(x T) []string) Sink[T] {
+	return func(ctx context.Context, in <-chan T) error {
+		c := csv.NewWriter(w)
+		err := c.Write(headers)
+		if err != nil {
+			return err
+		}
+		for x := range in {
+			err = c.Write(fn(x))
+			if err != nil {
+				return err
+			}
+		}
+		c.Flush()
+		err = c.Error()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+}
+
+//WriteJSONL writes each value as a line of JSON.
+func WriteJSONL[T any](w io.Writer) Sink[T] {
+	return func(ctx context.Context, in <-chan T) error {
+		e := json.NewEncoder(w)
+		for x := range in {
+			err := e.Encode(x)
+			if err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+//Exec runs a SQL statement for each value.  The function returns the
+//statement's arguments.
+func Exec[T any](db *sql.DB, query string, args func(x T) ([]interface{}, error)) Sink[T] {
+	return func(ctx context.Context, in <-chan T) error {
+		stmt, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for x := range in {
+			a, err := args(x)
+			if err != nil {
+				return err
+			}
+			_, err = stmt.ExecContext(ctx, a...)
+			if err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+//Upsert writes records to a mirrored table, size records to a
+//transaction.  Use Mirror.Prepare to create the table and get its
+//fields.
+func Upsert(m *godig.Mirror, t *godig.Table, fields godig.FieldList, size int) Sink[*godig.Record] {
+	if size < 1 {
+		size = godig.PageSize
+	}
+	return func(ctx context.Context, in <-chan *godig.Record) error {
+		var a []*godig.Record
+		for r := range in {
+			a = append(a, r)
+			if len(a) == size {
+				err := m.Upsert(ctx, t, fields, a)
+				if err != nil {
+					return err
+				}
+				a = nil
+			}
+		}
+		err := m.Upsert(ctx, t, fields, a)
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+}
+
+//Save saves each value to a table.  The value can be anything that
+//SaveRecord accepts.  The key function returns the value's key, or "0"
+//to insert it.
+func Save[T any](t *godig.Table, key func(x T) string) Sink[T] {
+	return Each(func(ctx context.Context, x T) error {
+		_, _, err := t.SaveRecord(ctx, key(x), x)
+		return err
+	})
+}
+
+//Delete deletes the records in a table with the keys that it receives.
+//Salsa's errors stop the pipeline.  Use BulkDelete to delete with an
+//audit trail instead.
+func Delete(t *godig.Table) Sink[string] {
+	return Each(func(ctx context.Context, key string) error {
+		var ds godig.DeleteStatus
+		err := t.Delete(key, &ds)
+		if err == nil && ds.Result == "error" {
+			err = fmt.Errorf("delete %s key %s: %v", t.Name, key, ds.Messages)
+		}
+		return err
+	})
+}
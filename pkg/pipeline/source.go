@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	godig "github.com/salsalabs/godig/pkg"
+)
+
+//Source produces values.  It calls emit with each one and returns at end
+//of data.  Return emit's error if emit fails.  That means the pipeline
+//is stopping.
+type Source[T any] func(ctx context.Context, emit func(T) error) error
+
+//From runs a source and returns the channel that it writes to.
+func From[T any](p *Pipeline, src Source[T]) <-chan T {
+	out := make(chan T, Buffer)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		return src(ctx, func(x T) error {
+			return send(ctx, out, x)
+		})
+	})
+	return out
+}
+
+//Slice emits the values in a slice.
+func Slice[T any](a []T) Source[T] {
+	return func(ctx context.Context, emit func(T) error) error {
+		for _, x := range a {
+			err := emit(x)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+//Offsets emits the offset of each page in a table with total records,
+//starting at start.  Use it with MapN and Page to read pages at the same
+//time.
+func Offsets(start int32, total int) Source[int32] {
+	return func(ctx context.Context, emit func(int32) error) error {
+		for i := start; int(i) < total; i += godig.PageSize {
+			err := emit(i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+//Page returns a function that reads the page of typed records at an
+//offset.  Joins are read with LeftJoin.  Use it with Offsets and MapN.
+func Page[T any](t *godig.Table, crit string) func(ctx context.Context, offset int32) ([]T, error) {
+	return page[T](t, crit, t.IsJoin())
+}
+
+//page returns a function that reads a page of a table or a join.
+func page[T any](t *godig.Table, crit string, join bool) func(ctx context.Context, offset int32) ([]T, error) {
+	return func(ctx context.Context, offset int32) ([]T, error) {
+		var a []T
+		var err error
+		if join {
+			err = t.LeftJoinContext(ctx, offset, godig.PageSize, crit, &a)
+		} else {
+			err = t.ManyContext(ctx, offset, godig.PageSize, crit, &a)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s offset %d: %v", t.Name, offset, err)
+		}
+		return a, err
+	}
+}
+
+//pages emits the records from a page reader until a page is empty.
+func pages[T any](read func(ctx context.Context, offset int32) ([]T, error)) Source[T] {
+	return func(ctx context.Context, emit func(T) error) error {
+		offset := int32(0)
+		for {
+			a, err := read(ctx, offset)
+			if err != nil {
+				return err
+			}
+			if len(a) == 0 {
+				return nil
+			}
+			for _, x := range a {
+				err = emit(x)
+				if err != nil {
+					return err
+				}
+			}
+			offset += int32(len(a))
+		}
+	}
+}
+
+//Many emits typed records from a table that match the criteria.
+func Many[T any](t *godig.Table, crit string) Source[T] {
+	return pages(page[T](t, crit, false))
+}
+
+//LeftJoin emits typed records from a join that match the criteria.  The
+//table's name is the join, like "supporter(supporter_KEY)donation".
+func LeftJoin[T any](t *godig.Table, crit string) Source[T] {
+	return pages(page[T](t, crit, true))
+}
+
+//Tagged emits typed records from a table that have a tag and match the
+//criteria.
+func Tagged[T any](t *godig.Table, crit string, tag string) Source[T] {
+	return pages(func(ctx context.Context, offset int32) ([]T, error) {
+		var a []T
+		err := t.ManyTaggedContext(ctx, offset, godig.PageSize, crit, tag, &a)
+		return a, err
+	})
+}
+
+//Records emits the records that match a query.  The query's checkpoint
+//and progress work the same way that they do for Scan.
+func Records(t *godig.Table, q godig.Query) Source[*godig.Record] {
+	return func(ctx context.Context, emit func(*godig.Record) error) error {
+		return t.Scan(ctx, q, func(page []*godig.Record) error {
+			for _, r := range page {
+				err := emit(r)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+//CSVFile emits the rows in a CSV file as records.  The options describe
+//the file.  See godig.CSVReader.
+func CSVFile(p string, o godig.CSVOptions) Source[*godig.Record] {
+	return func(ctx context.Context, emit func(*godig.Record) error) error {
+		c, err := godig.OpenCSV(p, o)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		return c.Each(emit)
+	}
+}
+
+//CSVReader emits the rows read from CSV as records.
+func CSVReader(r io.Reader, o godig.CSVOptions) Source[*godig.Record] {
+	return func(ctx context.Context, emit func(*godig.Record) error) error {
+		c, err := godig.NewCSVReader(r, o)
+		if err != nil {
+			return err
+		}
+		return c.Each(emit)
+	}
+}
@@ -0,0 +1,138 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+
+	godig "github.com/salsalabs/godig/pkg"
+)
+
+//Map applies a function to each value.  An error from the function stops
+//the pipeline.
+func Map[In, Out any](p *Pipeline, in <-chan In, fn func(ctx context.Context, x In) (Out, error)) <-chan Out {
+	return MapN(p, in, 1, fn)
+}
+
+//MapN is Map with n workers.  Values come out in the order that the
+//workers finish them, not the order that they went in.
+func MapN[In, Out any](p *Pipeline, in <-chan In, n int, fn func(ctx context.Context, x In) (Out, error)) <-chan Out {
+	out := make(chan Out, Buffer)
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		p.Go(func(ctx context.Context) error {
+			defer wg.Done()
+			for x := range in {
+				y, err := fn(ctx, x)
+				if err != nil {
+					return err
+				}
+				err = send(ctx, out, y)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	// The last worker to finish closes the output.
+	p.Go(func(ctx context.Context) error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+	return out
+}
+
+//Filter passes the values that the function accepts.
+func Filter[T any](p *Pipeline, in <-chan T, fn func(x T) bool) <-chan T {
+	out := make(chan T, Buffer)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for x := range in {
+			if !fn(x) {
+				continue
+			}
+			err := send(ctx, out, x)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+//Batch collects values into slices of up to size values.  The last
+//batch may be short.
+func Batch[T any](p *Pipeline, in <-chan T, size int) <-chan []T {
+	out := make(chan []T, 1)
+	if size < 1 {
+		size = 1
+	}
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		var a []T
+		for x := range in {
+			a = append(a, x)
+			if len(a) == size {
+				err := send(ctx, out, a)
+				if err != nil {
+					return err
+				}
+				a = nil
+			}
+		}
+		if len(a) != 0 {
+			return send(ctx, out, a)
+		}
+		return nil
+	})
+	return out
+}
+
+//Flatten sends the values in each slice one at a time.  It undoes Batch
+//and turns pages of records into records.
+func Flatten[T any](p *Pipeline, in <-chan []T) <-chan T {
+	out := make(chan T, Buffer)
+	p.Go(func(ctx context.Context) error {
+		defer close(out)
+		for a := range in {
+			for _, x := range a {
+				err := send(ctx, out, x)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return out
+}
+
+//Dedupe passes the first value for each key and drops the rest.  Every
+//key is remembered, so use it on keys, not on whole records.
+func Dedupe[T any, K comparable](p *Pipeline, in <-chan T, key func(x T) K) <-chan T {
+	seen := make(map[K]bool)
+	return Filter(p, in, func(x T) bool {
+		k := key(x)
+		if seen[k] {
+			return false
+		}
+		seen[k] = true
+		return true
+	})
+}
+
+//Enrich adds fields from another table to each record with a lookup.
+//Records are looked up in batches of up to size records.
+func Enrich(p *Pipeline, in <-chan *godig.Record, l *godig.Lookup, size int) <-chan *godig.Record {
+	out := make(chan *godig.Record, Buffer)
+	p.Go(func(ctx context.Context) error {
+		return l.Pipe(ctx, in, out, size)
+	})
+	return out
+}
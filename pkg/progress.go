@@ -0,0 +1,262 @@
+package godig
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultProgressInterval is how often progress is logged when the output
+//is not a terminal.
+const DefaultProgressInterval = 10 * time.Second
+
+//barWidth is the number of characters in the progress bar.
+const barWidth = 30
+
+//Progress reports how far along a long-running read or write is.  It
+//shows the number of records, records per second and, when the total is
+//known, the percent done and an estimate of the time remaining.
+//
+//A terminal gets a progress bar that is redrawn in place.  Anything else
+//gets a log line every Interval.  A nil Progress reports nothing, so
+//callers don't have to check.
+type Progress struct {
+	//Out receives the progress bar or log lines.
+	Out io.Writer
+	//TTY draws a progress bar instead of writing log lines.
+	TTY bool
+	//Interval is the time between log lines.
+	Interval time.Duration
+
+	mu      sync.Mutex
+	logger  *log.Logger
+	name    string
+	total   int
+	done    int
+	base    int
+	started time.Time
+	shown   time.Time
+}
+
+//NewProgress returns a Progress that writes to stderr.  It draws a bar
+//if stderr is a terminal.
+func NewProgress() *Progress {
+	return &Progress{
+		Out:      os.Stderr,
+		TTY:      isTerminal(os.Stderr),
+		Interval: DefaultProgressInterval,
+	}
+}
+
+//isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+//Start begins reporting on a new task.  Total is the number of records
+//to handle, or -1 if it's not known.  Done is the number of records that
+//were handled by an earlier run, for example when resuming.  They don't
+//count toward the rate.
+func (p *Progress) Start(name string, total int, done int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Out == nil {
+		p.Out = os.Stderr
+	}
+	if p.logger == nil {
+		p.logger = log.New(p.Out, "", log.LstdFlags)
+	}
+	p.name = name
+	p.total = total
+	p.done = done
+	p.base = done
+	p.started = time.Now()
+	p.shown = p.started
+	if p.TTY {
+		p.draw()
+	}
+}
+
+//StartTable begins reporting on the records in a table that match the
+//criteria.  Count provides the total.  The total is unknown for joins
+//and when Count fails.
+func (p *Progress) StartTable(t *Table, crit string, done int) {
+	if p == nil {
+		return
+	}
+	total := -1
+	if !t.IsJoin() {
+		n, err := t.CountRecords(crit)
+		if err != nil {
+			log.Printf("Progress: %s count, %v\n", t.Name, err)
+		} else {
+			total = n
+		}
+	}
+	p.Start(t.Name, total, done)
+}
+
+//Add records that n more records were handled.  It's safe to call Add
+//from more than one goroutine.
+func (p *Progress) Add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.started.IsZero() {
+		return
+	}
+	now := time.Now()
+	switch {
+	case p.TTY && now.Sub(p.shown) >= 250*time.Millisecond:
+		p.shown = now
+		p.draw()
+	case !p.TTY && now.Sub(p.shown) >= p.interval():
+		p.shown = now
+		p.logger.Println(p.line())
+	}
+}
+
+//Finish reports the final count.  Call it when a task is done, even if
+//it stopped on an error.
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started.IsZero() {
+		return
+	}
+	if p.TTY {
+		p.draw()
+		fmt.Fprintln(p.Out)
+		return
+	}
+	p.logger.Printf("%s elapsed=%s\n", p.line(), time.Since(p.started).Round(time.Second))
+}
+
+//Done returns the number of records handled so far.
+func (p *Progress) Done() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+//Rate returns the records handled per second since Start.
+func (p *Progress) Rate() float64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rate()
+}
+
+//Percent returns how much of the total has been handled, or -1 if the
+//total isn't known.
+func (p *Progress) Percent() float64 {
+	if p == nil {
+		return -1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.percent()
+}
+
+//ETA returns an estimate of the time remaining, or -1 if there's no way
+//to tell.
+func (p *Progress) ETA() time.Duration {
+	if p == nil {
+		return -1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.eta()
+}
+
+func (p *Progress) interval() time.Duration {
+	if p.Interval <= 0 {
+		return DefaultProgressInterval
+	}
+	return p.Interval
+}
+
+func (p *Progress) rate() float64 {
+	d := time.Since(p.started).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(p.done-p.base) / d
+}
+
+func (p *Progress) percent() float64 {
+	if p.total < 0 {
+		return -1
+	}
+	if p.total == 0 {
+		return 100
+	}
+	x := 100 * float64(p.done) / float64(p.total)
+	if x > 100 {
+		x = 100
+	}
+	return x
+}
+
+func (p *Progress) eta() time.Duration {
+	r := p.rate()
+	if p.total < 0 || r <= 0 {
+		return -1
+	}
+	left := p.total - p.done
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(left) / r * float64(time.Second)).Round(time.Second)
+}
+
+//line returns a log line of key=value pairs.
+func (p *Progress) line() string {
+	s := fmt.Sprintf("progress: name=%s done=%d", p.name, p.done)
+	if p.total >= 0 {
+		s = s + fmt.Sprintf(" total=%d percent=%.1f", p.total, p.percent())
+	}
+	s = s + fmt.Sprintf(" rate=%.1f/s", p.rate())
+	if eta := p.eta(); eta >= 0 {
+		s = s + fmt.Sprintf(" eta=%s", eta)
+	}
+	return s
+}
+
+//draw redraws the progress bar on the current line.
+func (p *Progress) draw() {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r%s ", p.name)
+	if x := p.percent(); x >= 0 {
+		n := int(x / 100 * barWidth)
+		fmt.Fprintf(&b, "[%s%s] %5.1f%% %d/%d", strings.Repeat("=", n), strings.Repeat(" ", barWidth-n), x, p.done, p.total)
+	} else {
+		fmt.Fprintf(&b, "%d", p.done)
+	}
+	fmt.Fprintf(&b, " %.0f/s", p.rate())
+	if eta := p.eta(); eta >= 0 {
+		fmt.Fprintf(&b, " ETA %s", eta)
+	}
+	// Clear whatever is left of a longer line.
+	b.WriteString("\x1b[K")
+	io.WriteString(p.Out, b.String())
+}
@@ -0,0 +1,207 @@
+package godig
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+//Record is a set of field names and values that remembers the order
+//of its fields.  Records read from Salsa keep the server's field order.
+//Fields added with Set go at the end.  Use NewRecord to create one.
+type Record struct {
+	names  []string
+	values map[string]string
+}
+
+//NewRecord returns an empty record.
+func NewRecord() *Record {
+	return &Record{values: make(map[string]string)}
+}
+
+//RecordFromMap returns a record with the contents of a map.  Maps don't
+//have an order, so the fields are sorted by name.
+func RecordFromMap(m map[string]string) *Record {
+	r := NewRecord()
+	var a []string
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	for _, k := range a {
+		r.Set(k, m[k])
+	}
+	return r
+}
+
+//recordFromGJson returns a record with the fields of a JSON object in
+//the order that they appear.
+func recordFromGJson(x gjson.Result) *Record {
+	r := NewRecord()
+	x.ForEach(func(key, value gjson.Result) bool {
+		r.Set(key.String(), value.String())
+		return true
+	})
+	return r
+}
+
+//Names returns the field names in order.  Don't modify the slice.
+func (r *Record) Names() []string {
+	return r.names
+}
+
+//Len returns the number of fields.
+func (r *Record) Len() int {
+	return len(r.names)
+}
+
+//Get returns the value of a field.  Missing fields are empty.
+func (r *Record) Get(name string) string {
+	return r.values[name]
+}
+
+//Lookup returns the value of a field and whether the field exists.
+func (r *Record) Lookup(name string) (string, bool) {
+	v, ok := r.values[name]
+	return v, ok
+}
+
+//Set changes the value of a field.  New fields are added at the end.
+func (r *Record) Set(name, value string) {
+	if _, ok := r.values[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.values[name] = value
+}
+
+//Delete removes a field.
+func (r *Record) Delete(name string) {
+	if _, ok := r.values[name]; !ok {
+		return
+	}
+	delete(r.values, name)
+	for i, n := range r.names {
+		if n == name {
+			r.names = append(r.names[:i:i], r.names[i+1:]...)
+			break
+		}
+	}
+}
+
+//Values returns the values for a list of fields.  Missing fields are
+//empty.
+func (r *Record) Values(names []string) []string {
+	a := make([]string, len(names))
+	for i, n := range names {
+		a[i] = r.values[n]
+	}
+	return a
+}
+
+//Map returns the fields as a map.  The map is a copy.
+func (r *Record) Map() map[string]string {
+	m := make(map[string]string, len(r.names))
+	for k, v := range r.values {
+		m[k] = v
+	}
+	return m
+}
+
+//MarshalJSON writes the record as a JSON object with fields in order.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, n := range r.names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		k, err := json.Marshal(n)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(r.values[n])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteString(":")
+		b.Write(v)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+//UnmarshalJSON reads a JSON object and keeps the order of its fields.
+//Values that aren't strings are stored as their JSON text.
+func (r *Record) UnmarshalJSON(b []byte) error {
+	x := gjson.ParseBytes(b)
+	if !x.IsObject() {
+		return fmt.Errorf("record: can't read %s", x.Type.String())
+	}
+	*r = *recordFromGJson(x)
+	return nil
+}
+
+//CSVWriter writes records to a CSV file with a stable column order.
+//Columns are the fields to write, in order.  If there are no columns,
+//then the fields in the first record are used in that record's order.
+//Renames changes the header for a field, for example from
+//"supporter_KEY" to "SupporterKey".
+type CSVWriter struct {
+	Columns []string
+	Renames map[string]string
+	w       *csv.Writer
+	started bool
+}
+
+//NewCSVWriter returns a CSVWriter that writes to w.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+//Header returns the header row.
+func (c *CSVWriter) Header() []string {
+	a := make([]string, len(c.Columns))
+	for i, n := range c.Columns {
+		a[i] = n
+		if x, ok := c.Renames[n]; ok {
+			a[i] = x
+		}
+	}
+	return a
+}
+
+//Write writes a record.  The header is written before the first record.
+func (c *CSVWriter) Write(r *Record) error {
+	if !c.started {
+		if len(c.Columns) == 0 {
+			c.Columns = append([]string{}, r.Names()...)
+		}
+		err := c.WriteHeader()
+		if err != nil {
+			return err
+		}
+	}
+	return c.w.Write(r.Values(c.Columns))
+}
+
+//WriteHeader writes the header if it hasn't been written.  Use it to
+//write a header when there may not be any records.
+func (c *CSVWriter) WriteHeader() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	return c.w.Write(c.Header())
+}
+
+//Flush writes buffered data and returns any error.
+func (c *CSVWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
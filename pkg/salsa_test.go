@@ -0,0 +1,238 @@
+package godig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//fakeSalsa is an in-memory Salsa for tests.  It reads records by key and
+//by comparisons and "IN" conditions, saves and deletes them.
+type fakeSalsa struct {
+	mu     sync.Mutex
+	tables map[string]map[string]map[string]string
+	//fields are what describe returns for each table.
+	fields map[string]FieldList
+	next   int
+	//newKeys makes saves of records that don't exist get new keys, the
+	//way Salsa sometimes does.
+	newKeys bool
+	//saves are the parameters of every save.
+	saves []url.Values
+	//gets counts the reads of each endpoint.
+	gets map[string]int
+	//longest is the length of the longest request URI.
+	longest int
+	//broken, if set, fails the requests that it returns true for.
+	broken func(r *http.Request) bool
+}
+
+//newFakeSalsa starts a fake Salsa and returns an API that uses it.
+func newFakeSalsa(t *testing.T) (*fakeSalsa, *API) {
+	f := &fakeSalsa{
+		tables: make(map[string]map[string]map[string]string),
+		fields: make(map[string]FieldList),
+		next:   1000,
+		gets:   make(map[string]int),
+	}
+	s := httptest.NewTLSServer(f)
+	t.Cleanup(s.Close)
+	a := NewAPI()
+	a.Client = s.Client()
+	a.Host = strings.TrimPrefix(s.URL, "https://")
+	return f, a
+}
+
+//put adds a record.  The fields are pairs of names and values.
+func (f *fakeSalsa) put(table, key string, fields ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := map[string]string{table + "_KEY": key}
+	for i := 0; i+1 < len(fields); i += 2 {
+		r[fields[i]] = fields[i+1]
+	}
+	if f.tables[table] == nil {
+		f.tables[table] = make(map[string]map[string]string)
+	}
+	f.tables[table][key] = r
+}
+
+//remove deletes a record.
+func (f *fakeSalsa) remove(table, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tables[table], key)
+}
+
+//get returns a copy of a record, or nil.
+func (f *fakeSalsa) get(table, key string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.tables[table][key]
+	if !ok {
+		return nil
+	}
+	x := make(map[string]string)
+	for k, v := range r {
+		x[k] = v
+	}
+	return x
+}
+
+//ServeHTTP implements http.Handler.
+func (f *fakeSalsa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	table := q.Get("object")
+	f.gets[r.URL.Path]++
+	if len(r.RequestURI) > f.longest {
+		f.longest = len(r.RequestURI)
+	}
+	if f.broken != nil && f.broken(r) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/getObject.sjs":
+		x, ok := f.tables[table][q.Get("key")]
+		if !ok {
+			x = map[string]string{}
+		}
+		json.NewEncoder(w).Encode(x)
+	case "/api/describe2.sjs":
+		json.NewEncoder(w).Encode(f.fields[table])
+	case "/api/getObjects.sjs":
+		a := f.find(table, q["condition"])
+		if len(q.Get("orderBy")) != 0 {
+			order := strings.Split(q.Get("orderBy"), ",")
+			sort.SliceStable(a, func(i, j int) bool {
+				for _, n := range order {
+					if c := fakeCompare(a[i][n], a[j][n]); c != 0 {
+						return c < 0
+					}
+				}
+				return false
+			})
+		}
+		var offset, count int
+		fmt.Sscanf(q.Get("limit"), "%d,%d", &offset, &count)
+		if offset > len(a) {
+			offset = len(a)
+		}
+		if offset+count < len(a) {
+			a = a[:offset+count]
+		}
+		json.NewEncoder(w).Encode(a[offset:])
+	case "/api/getCount.sjs":
+		fmt.Fprint(w, len(f.find(table, q["condition"])))
+	case "/save":
+		b, _ := ioutil.ReadAll(r.Body)
+		v, _ := url.ParseQuery(strings.TrimPrefix(string(b), "?json&"))
+		f.saves = append(f.saves, v)
+		table = v.Get("object")
+		key := v.Get("key")
+		x, ok := f.tables[table][key]
+		if !ok {
+			if key == "0" || f.newKeys {
+				f.next++
+				key = strconv.Itoa(f.next)
+			}
+			x = map[string]string{table + "_KEY": key}
+			if f.tables[table] == nil {
+				f.tables[table] = make(map[string]map[string]string)
+			}
+			f.tables[table][key] = x
+		}
+		for k := range v {
+			if k != "object" && k != "key" {
+				x[k] = v.Get(k)
+			}
+		}
+		fmt.Fprintf(w, `[{"object":"%s","key":"%s","result":"success","messages":[]}]`, table, key)
+	case "/delete":
+		delete(f.tables[table], q.Get("key"))
+		fmt.Fprintf(w, `{"Object":"%s","Key":"%s","Result":"success"}`, table, q.Get("key"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//find returns the records in a table that match every condition, in key
+//order.
+func (f *fakeSalsa) find(table string, conditions []string) []map[string]string {
+	var keys []string
+	for k, r := range f.tables[table] {
+		ok := true
+		for _, c := range conditions {
+			ok = ok && fakeMatch(r, c)
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+	a := []map[string]string{}
+	for _, k := range keys {
+		a = append(a, f.tables[table][k])
+	}
+	return a
+}
+
+//fakeMatch returns true if a record matches a comparison or an "IN"
+//condition.
+func fakeMatch(r map[string]string, c string) bool {
+	if i := strings.Index(c, " IN "); i != -1 {
+		for _, v := range strings.Split(c[i+4:], ",") {
+			if r[c[:i]] == v {
+				return true
+			}
+		}
+		return false
+	}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if i := strings.Index(c, op); i != -1 {
+			x := fakeCompare(r[c[:i]], c[i+len(op):])
+			switch op {
+			case ">=":
+				return x >= 0
+			case "<=":
+				return x <= 0
+			case ">":
+				return x > 0
+			case "<":
+				return x < 0
+			}
+			return x == 0
+		}
+	}
+	return false
+}
+
+//fakeCompare compares two values as numbers, as times in their own
+//zones, the way that Salsa does, or as strings.
+func fakeCompare(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return x - y
+	}
+	s, errA := ParseTime(a)
+	t, errB := ParseTime(b)
+	if errA == nil && errB == nil {
+		a, b = s.Format("2006-01-02 15:04:05"), t.Format("2006-01-02 15:04:05")
+	}
+	return strings.Compare(a, b)
+}
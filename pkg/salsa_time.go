@@ -64,3 +64,25 @@ var nilTime = (time.Time{}).UnixNano()
 func (ct *SalsaTimestamp) IsSet() bool {
 	return ct.UnixNano() != nilTime
 }
+
+//timeLayouts are the formats that ParseTime accepts.
+var timeLayouts = []string{
+	ClassicDateFormat,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02",
+}
+
+//ParseTime parses a time as Salsa returns it, for example
+//"Wed Aug 01 2018 11:30:51 GMT-0400 (EDT)".  MySQL and ISO formats
+//work too.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, x := range timeLayouts {
+		t, err := time.Parse(x, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse time '%s'", s)
+}
@@ -0,0 +1,247 @@
+package godig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//Link ties a saved record to a record in another table.  Salsa's /save
+//accepts these as "link=groups&linkKey=1234".
+type Link struct {
+	Table string
+	Key   string
+}
+
+//SaveOptions contains the optional parameters that Salsa's /save accepts.
+type SaveOptions struct {
+	//Links connect the saved record to other records.  For example,
+	//linking a supporter to groups adds the supporter to those groups.
+	Links []Link
+	//Tags are applied to the saved record.
+	Tags []string
+}
+
+//SaveRecord does a Salsa API /save using a typed record.  The record can
+//be a struct (or pointer to one), a map[string]string or a *Record.  Struct fields
+//are named using their JSON tags, just like the rest of the schemas in
+//this package.  Values are URL-encoded.
+//
+//Use a key of "0" (or an empty key) to insert a new record.  SaveRecord
+//returns the key of the saved record.  For inserts, that's the new key.
+func (t *Table) SaveRecord(ctx context.Context, key string, record interface{}) (string, Results, error) {
+	return t.SaveRecordWith(ctx, key, record, SaveOptions{})
+}
+
+//SaveRecordWith does the same thing as SaveRecord.  The options add links
+//and tags to the request.
+func (t *Table) SaveRecordWith(ctx context.Context, key string, record interface{}, opts SaveOptions) (string, Results, error) {
+	var r Results
+	v, err := t.saveValues(key, record, opts)
+	if err != nil {
+		return "", r, err
+	}
+	body, err := t.saveBulk(ctx, "&"+v.Encode())
+	if err != nil {
+		return "", r, err
+	}
+	r, err = ParseResults(body)
+	if err != nil {
+		return "", r, err
+	}
+	if r.Result == "error" {
+		m := fmt.Sprintf("save %s key %s: %s", t.Name, key, strings.Join(r.Messages, ", "))
+		return r.Key, r, errors.New(m)
+	}
+	return r.Key, r, nil
+}
+
+//ParseResults parses the body returned by /save or /delete.  Salsa returns
+//either a single result or an array of them.  The first one is returned.
+func ParseResults(body []byte) (Results, error) {
+	var r Results
+	s := strings.TrimSpace(string(body))
+	if len(s) == 0 {
+		return r, errors.New("empty response from Salsa")
+	}
+	if strings.HasPrefix(s, "[") {
+		var a []Results
+		err := json.Unmarshal([]byte(s), &a)
+		if err != nil {
+			return r, fmt.Errorf("%v parsing '%s'", err, s)
+		}
+		if len(a) == 0 {
+			return r, errors.New("empty results from Salsa")
+		}
+		return a[0], nil
+	}
+	err := json.Unmarshal([]byte(s), &r)
+	if err != nil {
+		err = fmt.Errorf("%v parsing '%s'", err, s)
+	}
+	return r, err
+}
+
+//saveValues builds the URL values for a /save request.
+func (t *Table) saveValues(key string, record interface{}, opts SaveOptions) (url.Values, error) {
+	if len(key) == 0 {
+		key = "0"
+	}
+	fields, err := RecordValues(record)
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("object", t.Name)
+	v.Set("key", key)
+	pk := t.Name + "_KEY"
+	for n, s := range fields {
+		// The key parameter identifies the record.  Don't send it twice.
+		if strings.EqualFold(n, pk) || n == "object" || n == "key" {
+			continue
+		}
+		v.Set(n, s)
+	}
+	for _, k := range opts.Links {
+		v.Add("link", k.Table)
+		v.Add("linkKey", k.Key)
+	}
+	for _, g := range opts.Tags {
+		v.Add("tag", g)
+	}
+	return v, nil
+}
+
+//RecordValues converts a struct, a map[string]string or a *Record to a
+//map of field names and string values.  Struct field names come from JSON tags.
+//Fields tagged "omitempty" are left out when empty.  Fields tagged "-"
+//and unexported fields are always left out.
+func RecordValues(record interface{}) (map[string]string, error) {
+	m := make(map[string]string)
+	switch r := record.(type) {
+	case nil:
+		return m, nil
+	case map[string]string:
+		for k, v := range r {
+			m[k] = v
+		}
+		return m, nil
+	case *map[string]string:
+		for k, v := range *r {
+			m[k] = v
+		}
+		return m, nil
+	case *Record:
+		return r.Map(), nil
+	}
+	rv := reflect.ValueOf(record)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return m, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot save a %v, need a struct or a map", rv.Kind())
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if len(sf.PkgPath) != 0 {
+			continue
+		}
+		name, omitEmpty := jsonName(sf)
+		if name == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		s, ok := fieldString(fv)
+		if !ok {
+			return nil, fmt.Errorf("cannot save field %v, type %v", sf.Name, sf.Type)
+		}
+		m[name] = s
+	}
+	return m, nil
+}
+
+//jsonName returns the field name from a struct field's JSON tag and
+//whether the field should be skipped when empty.
+func jsonName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if len(tag) == 0 {
+		return sf.Name, false
+	}
+	p := strings.Split(tag, ",")
+	name := p[0]
+	if len(name) == 0 {
+		name = sf.Name
+	}
+	omitEmpty := false
+	for _, o := range p[1:] {
+		// Some schemas in the wild use "omitEmpty".
+		if strings.EqualFold(o, "omitempty") {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+
+//fieldString formats a struct field value for /save.
+func fieldString(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", true
+		}
+	}
+	switch x := v.Interface().(type) {
+	case SalsaTimestamp:
+		return saveTime(&x), true
+	case *SalsaTimestamp:
+		return saveTime(x), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		if v.Bool() {
+			return "1", true
+		}
+		return "0", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			var a []string
+			for i := 0; i < v.Len(); i++ {
+				a = append(a, v.Index(i).String())
+			}
+			return strings.Join(a, ","), true
+		}
+	}
+	return "", false
+}
+
+//saveTime formats a timestamp the way that Salsa's /save wants it.
+func saveTime(ct *SalsaTimestamp) string {
+	if !ct.IsSet() {
+		return ""
+	}
+	return ct.Time.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,109 @@
+package godig
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//saveSupporter is a struct with the kinds of fields that SaveRecord
+//handles.
+type saveSupporter struct {
+	Key       string          `json:"supporter_KEY"`
+	Email     string          `json:"Email"`
+	Phone     string          `json:"Phone,omitempty"`
+	Receive   bool            `json:"Receive_Email"`
+	Amount    float64         `json:"Amount,omitEmpty"`
+	Count     int             `json:"Count"`
+	Zip       *string         `json:"Zip"`
+	Groups    []string        `json:"Groups,omitempty"`
+	Born      *SalsaTimestamp `json:"Date_Created,omitempty"`
+	Notes     string          `json:"-"`
+	Title     string
+	secret    string
+	Untouched int `json:",omitempty"`
+}
+
+func TestRecordValues(t *testing.T) {
+	born := &SalsaTimestamp{time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)}
+	tests := []struct {
+		name   string
+		record interface{}
+		want   map[string]string
+	}{
+		{"struct", saveSupporter{Key: "5", Email: "bob@example.com", Receive: true, Count: 3, Notes: "x", Title: "Mr", secret: "y"},
+			map[string]string{"supporter_KEY": "5", "Email": "bob@example.com", "Receive_Email": "1", "Count": "3", "Zip": "", "Title": "Mr"}},
+		{"pointer", &saveSupporter{Amount: 1.5, Groups: []string{"a", "b"}, Born: born},
+			map[string]string{"supporter_KEY": "", "Email": "", "Receive_Email": "0", "Amount": "1.5", "Count": "0", "Zip": "",
+				"Groups": "a,b", "Date_Created": "2024-01-02 10:30:00", "Title": ""}},
+		{"map", map[string]string{"Email": "bob@example.com"}, map[string]string{"Email": "bob@example.com"}},
+		{"record", matchRecord("Email", "bob@example.com", "Zip", "12345"), map[string]string{"Email": "bob@example.com", "Zip": "12345"}},
+		{"nil", nil, map[string]string{}},
+		{"nil pointer", (*saveSupporter)(nil), map[string]string{}},
+	}
+	for _, x := range tests {
+		got, err := RecordValues(x.record)
+		if err != nil {
+			t.Errorf("%s: %v", x.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: %v, want %v", x.name, got, x.want)
+		}
+	}
+	bad := []interface{}{
+		42,
+		struct{ M map[string]int }{},
+	}
+	for _, x := range bad {
+		if _, err := RecordValues(x); err == nil {
+			t.Errorf("%T isn't an error", x)
+		}
+	}
+}
+
+func TestSaveRecordWith(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.put("supporter", "5", "Email", "old@example.com")
+	tb := a.Supporter()
+	opts := SaveOptions{
+		Links: []Link{{Table: "groups", Key: "10"}, {Table: "groups", Key: "11"}, {Table: "chapter", Key: "3"}},
+		Tags:  []string{"volunteer", "board & staff"},
+	}
+	r := saveSupporter{Key: "5", Email: "bob+news@example.com", Receive: true}
+	k, res, err := tb.SaveRecordWith(context.Background(), "5", r, opts)
+	if err != nil || k != "5" || res.Result != "success" {
+		t.Fatalf("key %s, %v, %v", k, res, err)
+	}
+	want := url.Values{
+		"object":        {"supporter"},
+		"key":           {"5"},
+		"Email":         {"bob+news@example.com"},
+		"Receive_Email": {"1"},
+		"Count":         {"0"},
+		"Zip":           {""},
+		"Title":         {""},
+		"link":          {"groups", "groups", "chapter"},
+		"linkKey":       {"10", "11", "3"},
+		"tag":           {"volunteer", "board & staff"},
+	}
+	if len(f.saves) != 1 || !reflect.DeepEqual(f.saves[0], want) {
+		t.Errorf("saved %v, want %v", f.saves, want)
+	}
+	if got := f.get("supporter", "5")["Email"]; got != "bob+news@example.com" {
+		t.Errorf("Email is %s", got)
+	}
+
+	k, _, err = tb.SaveRecord(context.Background(), "", map[string]string{"Email": "new@example.com"})
+	if err != nil || k == "0" || len(k) == 0 {
+		t.Fatalf("insert key %s, %v", k, err)
+	}
+	if got := f.saves[1].Get("key"); got != "0" {
+		t.Errorf("insert sent key %s, want 0", got)
+	}
+	if got := f.get("supporter", k)["Email"]; got != "new@example.com" {
+		t.Errorf("inserted Email is %s", got)
+	}
+}
@@ -0,0 +1,118 @@
+package godig
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//PageSize is the largest number of records that Salsa returns for a read.
+const PageSize = 500
+
+//Query describes a paginated read from a table or a join.
+type Query struct {
+	//Criteria is a Salsa condition.  Separate multiple conditions
+	//with "&condition=".
+	Criteria string
+	//Offset is where reading starts.
+	Offset int32
+	//Include limits the fields that Salsa returns.  Empty returns all
+	//fields.  Use "table.Field" names for joins.
+	Include []string
+	//OrderBy sorts the records, for example "Date_Created" or
+	//"Date_Created DESC".  Reads are only repeatable if the order is
+	//stable, so include the primary key as a tie-breaker.
+	OrderBy []string
+	//Checkpoint, if set, is where Scan starts reading.  Scan saves it
+	//after each page.
+	Checkpoint *Checkpoint
+	//Progress, if set, reports how far along Scan is.  The total comes
+	//from Count with the same criteria.
+	Progress *Progress
+}
+
+//crit returns the query's criteria with the include and orderBy
+//parameters appended.  The read functions add the criteria to the URL
+//as-is, so the extra parameters go along with it.
+func (q Query) crit() string {
+	c := q.Criteria
+	if len(q.Include) != 0 {
+		c = c + "&include=" + strings.Join(q.Include, ",")
+	}
+	if len(q.OrderBy) != 0 {
+		c = c + "&orderBy=" + strings.Join(q.OrderBy, ",")
+	}
+	return c
+}
+
+//IsJoin returns true if the table name is a join expression like
+//"supporter(supporter_KEY)donation".  Joins are read with LeftJoin.
+func (t *Table) IsJoin() bool {
+	return strings.Contains(t.Name, "(")
+}
+
+//Page reads one page of records that match the query, starting at offset.
+//Fields are in the order that Salsa returned them.
+func (t *Table) Page(ctx context.Context, q Query, offset int32) ([]*Record, error) {
+	if t.IsJoin() {
+		return t.leftJoinRecords(ctx, offset, PageSize, q.crit())
+	}
+	return t.manyRecords(ctx, offset, PageSize, q.crit())
+}
+
+//Scan reads every record that matches the query, one page at a time.
+//The function sees each page.  Scan stops at end of data, when the
+//function returns an error or when the context is cancelled.
+//
+//If the query has a checkpoint, reading starts at the checkpoint's offset
+//and the checkpoint is saved after the function handles each page.
+//
+//The scan is traced as a "scan" stage.  Each page's request is a span
+//inside it.
+func (t *Table) Scan(ctx context.Context, q Query, fn func(page []*Record) error) error {
+	ctx, span := Stage(ctx, "scan "+t.Name,
+		attribute.String("salsa.table", t.Name),
+		attribute.String("salsa.criteria", q.Criteria))
+	err := t.scan(ctx, q, fn)
+	EndStage(span, err)
+	return err
+}
+
+//scan does the work for Scan.
+func (t *Table) scan(ctx context.Context, q Query, fn func(page []*Record) error) error {
+	offset := q.Offset
+	cp := q.Checkpoint
+	if cp != nil {
+		offset = cp.Offset
+	}
+	pk := t.Name + "_KEY"
+	q.Progress.StartTable(t, q.Criteria, int(offset))
+	defer q.Progress.Finish()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a, err := t.Page(ctx, q, offset)
+		if err != nil {
+			return err
+		}
+		if len(a) == 0 {
+			return nil
+		}
+		err = fn(a)
+		if err != nil {
+			return err
+		}
+		offset += int32(len(a))
+		q.Progress.Add(len(a))
+		if cp != nil {
+			cp.Offset = offset
+			cp.LastKey = a[len(a)-1].Get(pk)
+			err = cp.Save()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
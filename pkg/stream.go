@@ -0,0 +1,229 @@
+package godig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+//bufferPool holds the buffers that responses are read into.  Reading
+//into a buffer that has already grown saves allocating a bigger slice
+//each time ioutil.ReadAll runs out of room.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+//readBody reads a response body into a pooled buffer and returns a
+//copy that is exactly the right size.
+func readBody(r io.Reader) ([]byte, error) {
+	b := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(b)
+	b.Reset()
+	_, err := b.ReadFrom(r)
+	body := make([]byte, b.Len())
+	copy(body, b.Bytes())
+	return body, err
+}
+
+//countReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+//getStream reads a URL like GetContext, but hands the body to the
+//function as it arrives instead of reading all of it first.
+func (a *API) getStream(ctx context.Context, u string, fn func(r io.Reader) error) error {
+	if a.Verbose {
+		fmt.Printf("Get: %v\n", u)
+	}
+	if !skipCache(ctx) {
+		if body, ok := a.cached(u); ok {
+			return fn(bytes.NewReader(body))
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	// Salsa's API needs these cookies to verify authentication.
+	for _, c := range a.Cookies {
+		req.AddCookie(c)
+	}
+	done := a.Metrics.begin(req, "", 0)
+	span := startRequest(req, "")
+	req, timed := a.timing(req)
+	defer timed()
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		done(nil, 0)
+		endRequest(span, nil, 0, err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("invalid response code %v", resp.Status)
+		done(resp, 0)
+		endRequest(span, resp, 0, err)
+		return err
+	}
+	c := &countReader{r: resp.Body}
+	var r io.Reader = c
+	if a.Verbose {
+		fmt.Print("Get: ")
+		r = io.TeeReader(c, os.Stdout)
+	}
+	// A response that will be cached is copied as it's read.
+	var kept *bytes.Buffer
+	if a.caching(u) && !skipCache(ctx) {
+		kept = bufferPool.Get().(*bytes.Buffer)
+		defer bufferPool.Put(kept)
+		kept.Reset()
+		r = io.TeeReader(r, kept)
+	}
+	err = fn(r)
+	if a.Verbose {
+		fmt.Println()
+	}
+	if err == nil && kept != nil {
+		// Whatever the decoder didn't need is still part of the response.
+		_, err = io.Copy(ioutil.Discard, r)
+		if err == nil {
+			a.keep(u, append([]byte(nil), kept.Bytes()...))
+		}
+	}
+	done(resp, c.n)
+	endRequest(span, resp, c.n, err)
+	return err
+}
+
+//DecodeRecords reads a JSON array of objects and calls the function with
+//each record as soon as it has been parsed.  Fields are in the order that
+//they appear.  A single object is treated as an array of one.
+//
+//The decoder only finds where each record starts and ends.  The record is
+//parsed on its own, so only one record's worth of JSON is in memory at a
+//time instead of the whole page.
+func DecodeRecords(r io.Reader, fn func(*Record) error) error {
+	br := bufio.NewReader(r)
+	c, err := firstByte(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(br)
+	// raw is reused for each record.  Parsing copies what it needs.
+	var raw json.RawMessage
+	switch c {
+	case '{':
+		err = d.Decode(&raw)
+		if err != nil {
+			return err
+		}
+		return fn(recordFromGJson(gjson.ParseBytes(raw)))
+	case '[':
+		_, err = d.Token()
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("expected an array of records, found '%c'", c)
+	}
+	for d.More() {
+		err = d.Decode(&raw)
+		if err != nil {
+			return err
+		}
+		x := gjson.ParseBytes(raw)
+		if !x.IsObject() {
+			return fmt.Errorf("expected a record, found %.20s", raw)
+		}
+		err = fn(recordFromGJson(x))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = d.Token()
+	return err
+}
+
+//firstByte returns the first byte that isn't white space without
+//consuming it.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+//StreamPage reads one page of records that match the query, starting at
+//offset.  Each record goes to the function as soon as it's parsed.
+//Returns the number of records read.
+func (t *Table) StreamPage(ctx context.Context, q Query, offset int32, fn func(*Record) error) (int, error) {
+	u := t.manyURL(offset, PageSize, q.crit())
+	if t.IsJoin() {
+		u = t.leftJoinURL(offset, PageSize, q.crit())
+	}
+	return t.streamRecords(ctx, u, fn)
+}
+
+//streamRecords reads records from a URL and hands each one to the
+//function.
+func (t *Table) streamRecords(ctx context.Context, u string, fn func(*Record) error) (int, error) {
+	n := 0
+	err := t.getStream(ctx, u, func(r io.Reader) error {
+		return DecodeRecords(r, func(x *Record) error {
+			n++
+			return fn(x)
+		})
+	})
+	t.Metrics.Decoded(t.Name, n)
+	return n, err
+}
+
+//collectRecords reads records from a URL into a slice.
+func (t *Table) collectRecords(ctx context.Context, u string) ([]*Record, error) {
+	a := make([]*Record, 0)
+	_, err := t.streamRecords(ctx, u, func(x *Record) error {
+		a = append(a, x)
+		return nil
+	})
+	return a, err
+}
+
+//decodeInto reads a URL and unmarshals the response into the target as
+//it arrives.
+func (t *Table) decodeInto(ctx context.Context, u string, target interface{}) error {
+	err := t.getStream(ctx, u, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(target)
+	})
+	if err == nil {
+		t.Metrics.decodedSlice(t.Name, target)
+	}
+	return err
+}
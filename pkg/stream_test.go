@@ -0,0 +1,133 @@
+package godig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//benchPage returns a JSON array of records with the requested number of
+//fields in each.
+func benchPage(records, fields int) []byte {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i := 0; i < records; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"object":"supporter","key":"%d","supporter_KEY":"%d"`, i, i)
+		for j := 0; j < fields; j++ {
+			fmt.Fprintf(&b, `,"Field_%d":"value %d for supporter %d"`, j, j, i)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("]")
+	return b.Bytes()
+}
+
+func TestDecodeRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"page", benchPage(20, 5)},
+		{"empty", []byte("[]")},
+		{"values", []byte(`[{"a":"x","b":3,"c":null,"d":true,"e":"quote \" and é"}]`)},
+	}
+	for _, x := range tests {
+		var got []*Record
+		err := DecodeRecords(bytes.NewReader(x.body), func(r *Record) error {
+			got = append(got, r)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", x.name, err)
+		}
+		want := unpackGJsonArray(x.body)
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d records, want %d", x.name, len(got), len(want))
+		}
+		for i := range got {
+			if !reflect.DeepEqual(got[i].Names(), want[i].Names()) || !reflect.DeepEqual(got[i].Map(), want[i].Map()) {
+				t.Errorf("%s: record %d is %v, want %v", x.name, i, got[i].Map(), want[i].Map())
+			}
+		}
+	}
+}
+
+//BenchmarkUnpackGJsonArray reads a page the buffered way: the whole
+//response, then gjson.
+func BenchmarkUnpackGJsonArray(b *testing.B) {
+	body := benchPage(500, 100)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		x, err := readBody(bytes.NewReader(body))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(unpackGJsonArray(x)) != 500 {
+			b.Fatal("wrong number of records")
+		}
+	}
+}
+
+//BenchmarkDecodeRecords streams the same page one record at a time.
+func BenchmarkDecodeRecords(b *testing.B) {
+	body := benchPage(500, 100)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		err := DecodeRecords(bytes.NewReader(body), func(r *Record) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != 500 {
+			b.Fatal("wrong number of records")
+		}
+	}
+}
+
+func TestScanSkipsCache(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	a.Cache = NewCache("")
+	a.Cache.TTLs["supporter_groups"] = time.Hour
+	f.put("supporter_groups", "10", "supporter_KEY", "1", "groups_KEY", "5")
+	tb := a.NewTable("supporter_groups")
+	scan := func(ctx context.Context) []string {
+		var keys []string
+		err := tb.Scan(ctx, Query{Criteria: "supporter_KEY=1"}, func(page []*Record) error {
+			for _, r := range page {
+				keys = append(keys, r.Get("supporter_groups_KEY"))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	ctx := context.Background()
+	scan(ctx)
+	reads := f.gets["/api/getObjects.sjs"]
+	f.put("supporter_groups", "11", "supporter_KEY", "1", "groups_KEY", "6")
+	if got := scan(ctx); !reflect.DeepEqual(got, []string{"10"}) || f.gets["/api/getObjects.sjs"] != reads {
+		t.Fatalf("cached scan read %v from Salsa", got)
+	}
+	if got := scan(uncached(ctx)); !reflect.DeepEqual(got, []string{"10", "11"}) {
+		t.Errorf("uncached scan read %v, want [10 11]", got)
+	}
+	if f.gets["/api/getObjects.sjs"] == reads {
+		t.Errorf("uncached scan didn't read from Salsa")
+	}
+	if got := scan(ctx); !reflect.DeepEqual(got, []string{"10"}) {
+		t.Errorf("uncached scan replaced the cache, read %v", got)
+	}
+}
@@ -0,0 +1,274 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//DefaultOverlap is how far before the watermark an incremental sync
+//starts reading.  It covers clock differences and records that were
+//being saved while the last sync ran.
+const DefaultOverlap = time.Hour
+
+//Syncer keeps a mirror up to date by reading only the records that were
+//modified since the last sync.  The first sync of a table reads every
+//record.
+type Syncer struct {
+	Mirror *Mirror
+	//Criteria is added to the Last_Modified condition.
+	Criteria string
+	//Overlap is subtracted from the watermark.  Records in the overlap
+	//are read again and upserted, which is harmless.
+	Overlap time.Duration
+	//ReconcileEvery is how often Sync looks for records that were deleted
+	//from Salsa.  Zero never reconciles unless Reconcile is set.
+	ReconcileEvery time.Duration
+	//Reconcile looks for deleted records on every sync.
+	Reconcile bool
+	//CheckpointDir, if set, is where Sync keeps a checkpoint for each
+	//table, named like "sync-supporter.checkpoint.json".
+	CheckpointDir string
+	//Resume continues from the checkpoint left by an interrupted sync.
+	Resume bool
+	//Progress, if set, reports on reading modified records and keys.
+	Progress *Progress
+}
+
+//SyncResult describes a sync of one table.
+type SyncResult struct {
+	Table string
+	//Full is true when every record was read.
+	Full bool
+	//Since is the start of an incremental read.
+	Since time.Time
+	//Upserted is the number of records read and written.
+	Upserted int
+	//Reconciled is true if deleted records were looked for.
+	Reconciled bool
+	//Deleted is the number of records removed from the mirror because
+	//they are no longer in Salsa.
+	Deleted int
+}
+
+//Sync brings a table up to date.  The function, if not nil, sees the
+//running total after each page.
+func (s *Syncer) Sync(ctx context.Context, t *Table, fn func(rows int)) (SyncResult, error) {
+	ctx, span := Stage(ctx, "sync "+t.Name,
+		attribute.String("salsa.table", t.Name))
+	r, err := s.sync(ctx, t, fn)
+	span.SetAttributes(attribute.Bool("full", r.Full), attribute.Int("upserted", r.Upserted))
+	EndStage(span, err)
+	return r, err
+}
+
+//sync does the work for Sync.
+func (s *Syncer) sync(ctx context.Context, t *Table, fn func(rows int)) (SyncResult, error) {
+	r := SyncResult{Table: t.Name}
+	m := s.Mirror
+	fields, err := m.Prepare(ctx, t)
+	if err != nil {
+		return r, err
+	}
+	w, err := m.Watermark(ctx, t.Name)
+	if err != nil {
+		return r, err
+	}
+	pk := t.Name + "_KEY"
+	q := Query{Criteria: s.Criteria, OrderBy: []string{"Last_Modified", pk}, Progress: s.Progress}
+	if w.LastModified.IsZero() {
+		r.Full = true
+	} else {
+		overlap := s.Overlap
+		if overlap == 0 {
+			overlap = DefaultOverlap
+		}
+		r.Since = w.LastModified.Add(-overlap)
+		// Salsa compares times in its own zone.  The watermark keeps
+		// that zone.
+		c := "Last_Modified>=" + r.Since.Format("2006-01-02 15:04:05")
+		if len(q.Criteria) != 0 {
+			c = c + "&condition=" + q.Criteria
+		}
+		q.Criteria = c
+	}
+	if len(s.CheckpointDir) != 0 {
+		p := filepath.Join(s.CheckpointDir, "sync-"+t.Name+".checkpoint.json")
+		q.Checkpoint = NewCheckpoint(p, t, q)
+		if s.Resume {
+			q.Checkpoint, err = LoadCheckpoint(p, t, q)
+			if err != nil {
+				return r, err
+			}
+		}
+	}
+	scan := func(ctx context.Context, q Query, fn func(page []*Record) error) error {
+		return s.scan(ctx, t, q, fn)
+	}
+	r.Upserted, err = m.copy(ctx, t, fields, q, scan, &w, fn)
+	if err != nil {
+		return r, err
+	}
+	if q.Checkpoint != nil {
+		err = q.Checkpoint.Remove()
+		if err != nil {
+			return r, err
+		}
+	}
+	if s.Reconcile || s.ReconcileEvery > 0 && time.Since(w.Reconciled) >= s.ReconcileEvery {
+		r.Reconciled = true
+		r.Deleted, err = s.reconcile(ctx, t)
+		if err != nil {
+			return r, err
+		}
+		w.Reconciled = time.Now()
+	}
+	return r, m.SetWatermark(ctx, w)
+}
+
+//scan reads the records that match a query in order of Last_Modified and
+//primary key.  Each page starts after the last record read instead of at
+//an offset.  A record that's saved during the scan moves to the end of the
+//order.  With offsets, the records behind it would move up a place and
+//one of them would be skipped.
+//
+//If the query has a checkpoint, reading starts after the checkpoint's
+//record and the checkpoint is saved after the function handles each page.
+func (s *Syncer) scan(ctx context.Context, t *Table, q Query, fn func(page []*Record) error) error {
+	pk := t.Name + "_KEY"
+	var offset int32
+	var last, key string
+	cp := q.Checkpoint
+	if cp != nil {
+		offset, last, key = cp.Offset, cp.LastModified, cp.LastKey
+	}
+	q.Progress.StartTable(t, q.Criteria, int(offset))
+	defer q.Progress.Finish()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var a []*Record
+		var err error
+		if len(last) == 0 {
+			a, err = t.Page(ctx, q, 0)
+		} else {
+			// The rest of the records modified at the same time as the
+			// last one, then the records modified after it.
+			x := after(q, "Last_Modified="+last, pk+">"+key)
+			x.OrderBy = []string{pk}
+			a, err = t.Page(ctx, x, 0)
+			if err == nil && len(a) == 0 {
+				a, err = t.Page(ctx, after(q, "Last_Modified>"+last), 0)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if len(a) == 0 {
+			return nil
+		}
+		err = fn(a)
+		if err != nil {
+			return err
+		}
+		r := a[len(a)-1]
+		lm, err := ParseTime(r.Get("Last_Modified"))
+		if err != nil {
+			return fmt.Errorf("sync: %s %s: %v", t.Name, r.Get(pk), err)
+		}
+		// Salsa compares times in its own zone.
+		last, key = lm.Format("2006-01-02 15:04:05"), r.Get(pk)
+		offset += int32(len(a))
+		q.Progress.Add(len(a))
+		if cp != nil {
+			cp.Offset, cp.LastModified, cp.LastKey = offset, last, key
+			err = cp.Save()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//after returns a query with more conditions.
+func after(q Query, conditions ...string) Query {
+	for _, c := range conditions {
+		if len(q.Criteria) != 0 {
+			q.Criteria = q.Criteria + "&condition="
+		}
+		q.Criteria = q.Criteria + c
+	}
+	return q
+}
+
+//reconcile removes records from the mirror that are no longer in Salsa.
+//Every key that matches the criteria is read from Salsa and compared to
+//the mirror.  Counts can't be trusted to skip this.  A record deleted
+//from Salsa and one added since the sync read make equal counts.
+//
+//Records that are in the mirror but don't match the criteria are looked
+//up without the criteria.  A record that was changed so that it doesn't
+//match anymore is still in Salsa, so it stays in the mirror.
+func (s *Syncer) reconcile(ctx context.Context, t *Table) (int, error) {
+	ctx = uncached(ctx)
+	m := s.Mirror
+	pk := t.Name + "_KEY"
+	keep := make(map[string]bool)
+	q := Query{Criteria: s.Criteria, Include: []string{pk}, OrderBy: []string{pk}, Progress: s.Progress}
+	err := t.Scan(ctx, q, func(page []*Record) error {
+		for _, r := range page {
+			keep[r.Get(pk)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	keys, err := m.Keys(ctx, t.Name)
+	if err != nil {
+		return 0, err
+	}
+	var gone []string
+	for _, k := range keys {
+		if !keep[k] {
+			gone = append(gone, k)
+		}
+	}
+	if len(s.Criteria) != 0 && len(gone) != 0 {
+		gone, err = missing(ctx, t, gone)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(gone), m.DeleteKeys(ctx, t.Name, gone)
+}
+
+//missing returns the keys that aren't in a table.
+func missing(ctx context.Context, t *Table, keys []string) ([]string, error) {
+	pk := t.Name + "_KEY"
+	found := make(map[string]bool)
+	f := Finder{Table: t, Fields: []string{pk}, Include: []string{pk}}
+	for _, q := range f.Queries(keys) {
+		q.OrderBy = []string{pk}
+		err := t.Scan(ctx, q, func(page []*Record) error {
+			for _, r := range page {
+				found[r.Get(pk)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var a []string
+	for _, k := range keys {
+		if !found[k] {
+			a = append(a, k)
+		}
+	}
+	return a, nil
+}
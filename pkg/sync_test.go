@@ -0,0 +1,175 @@
+package godig
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//supporterFields is what the fake Salsa describes for supporters.
+var supporterFields = FieldList{
+	{Name: "supporter_KEY", Type: "int"},
+	{Name: "Email", Type: "varchar"},
+	{Name: "Receive_Email", Type: "int"},
+	{Name: "Last_Modified", Type: "datetime"},
+}
+
+//openTestMirror returns a mirror in a new SQLite database.
+func openTestMirror(t *testing.T) *Mirror {
+	m, err := OpenMirror("sqlite3", filepath.Join(t.TempDir(), "mirror.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	m.Profile = "test"
+	return m
+}
+
+//modified returns a Last_Modified the way Salsa formats it, some seconds
+//after a start time.
+func modified(seconds int) string {
+	x := time.Date(2024, 1, 2, 10, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	return x.Add(time.Duration(seconds) * time.Second).Format(ClassicDateFormat)
+}
+
+//mirrorKeys returns the sorted keys in a mirror's table.
+func mirrorKeys(t *testing.T, m *Mirror, table string) []string {
+	keys, err := m.Keys(context.Background(), table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fakeCompare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+func TestSyncDoesNotSkipSavedRecords(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.fields["supporter"] = supporterFields
+	n := 2*PageSize + 100
+	for i := 1; i <= n; i++ {
+		// Groups of records saved in the same second cross the pages.
+		f.put("supporter", fmt.Sprint(i), "Email", fmt.Sprintf("s%d@example.com", i), "Last_Modified", modified(i/300))
+	}
+	m := openTestMirror(t)
+	s := Syncer{Mirror: m}
+	tb := a.Supporter()
+	saved := false
+	r, err := s.Sync(context.Background(), &tb, func(rows int) {
+		// A record that's already been read is saved during the sync.
+		if !saved {
+			saved = true
+			f.put("supporter", "1", "Email", "new@example.com", "Last_Modified", modified(3600))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Full || r.Upserted != n+1 {
+		t.Errorf("full %v, upserted %d, want %d", r.Full, r.Upserted, n+1)
+	}
+	if keys := mirrorKeys(t, m, "supporter"); len(keys) != n {
+		t.Errorf("mirror has %d records, want %d", len(keys), n)
+	}
+	var email string
+	err = m.DB.QueryRow(`SELECT "Email" FROM "supporter" WHERE "supporter_KEY" = 1`).Scan(&email)
+	if err != nil || email != "new@example.com" {
+		t.Errorf("supporter 1 has %s, %v", email, err)
+	}
+	w, err := m.Watermark(context.Background(), "supporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.LastModified.Format("2006-01-02 15:04:05"); got != "2024-01-02 11:00:00" {
+		t.Errorf("watermark %s", got)
+	}
+}
+
+func TestSyncResume(t *testing.T) {
+	f, a := newFakeSalsa(t)
+	f.fields["supporter"] = supporterFields
+	n := PageSize + 10
+	for i := 1; i <= n; i++ {
+		f.put("supporter", fmt.Sprint(i), "Last_Modified", modified(0))
+	}
+	m := openTestMirror(t)
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := Syncer{Mirror: m, CheckpointDir: dir}
+	tb := a.Supporter()
+	_, err := s.Sync(ctx, &tb, func(rows int) { cancel() })
+	if err == nil {
+		t.Fatal("sync wasn't interrupted")
+	}
+	f.remove("supporter", "2")
+	s.Resume = true
+	r, err := s.Sync(context.Background(), &tb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Upserted != n {
+		t.Errorf("upserted %d, want %d", r.Upserted, n)
+	}
+	if keys := mirrorKeys(t, m, "supporter"); len(keys) != n {
+		t.Errorf("mirror has %d records, want %d", len(keys), n)
+	}
+}
+
+func TestSyncReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria string
+		change   func(f *fakeSalsa)
+		want     []string
+	}{
+		{"deleted", "", func(f *fakeSalsa) {
+			f.remove("supporter", "2")
+		}, []string{"1", "3"}},
+		{"deleted and added", "", func(f *fakeSalsa) {
+			// The new record is saved after the sync read, so the
+			// counts are the same.
+			f.remove("supporter", "2")
+		}, []string{"1", "3"}},
+		{"doesn't match", "Receive_Email=1", func(f *fakeSalsa) {
+			f.remove("supporter", "2")
+			f.put("supporter", "3", "Receive_Email", "0", "Last_Modified", modified(60))
+		}, []string{"1", "3"}},
+	}
+	for _, x := range tests {
+		f, a := newFakeSalsa(t)
+		f.fields["supporter"] = supporterFields
+		for _, k := range []string{"1", "2", "3"} {
+			f.put("supporter", k, "Receive_Email", "1", "Last_Modified", modified(0))
+		}
+		m := openTestMirror(t)
+		s := Syncer{Mirror: m, Criteria: x.criteria}
+		tb := a.Supporter()
+		ctx := context.Background()
+		if _, err := s.Sync(ctx, &tb, nil); err != nil {
+			t.Fatal(err)
+		}
+		x.change(f)
+		s.Reconcile = true
+		added := false
+		r, err := s.Sync(ctx, &tb, func(rows int) {
+			if x.name == "deleted and added" && !added {
+				added = true
+				f.put("supporter", "9", "Receive_Email", "1", "Last_Modified", modified(-60))
+			}
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", x.name, err)
+		}
+		if !r.Reconciled || r.Deleted != 1 {
+			t.Errorf("%s: reconciled %v, deleted %d", x.name, r.Reconciled, r.Deleted)
+		}
+		if got := mirrorKeys(t, m, "supporter"); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: mirror has %v, want %v", x.name, got, x.want)
+		}
+	}
+}
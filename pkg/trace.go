@@ -0,0 +1,109 @@
+package godig
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//TracerName identifies godig's spans.
+const TracerName = "github.com/salsalabs/godig"
+
+//tracer returns godig's tracer.  Spans go nowhere until a tracer
+//provider is installed, by StartTracing or by the application.
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+//StartTracing installs a tracer provider that writes spans as JSON to
+//the file p, or to stdout if p is "-".  Call the returned function
+//before the program exits.  It writes the spans that are still buffered.
+func StartTracing(p string) (func(context.Context) error, error) {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if p != "-" {
+		var err error
+		f, err = os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	r := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("godig"))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(r))
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if f != nil {
+			if x := f.Close(); err == nil {
+				err = x
+			}
+		}
+		return err
+	}, nil
+}
+
+//Stage starts a span for a pipeline stage, like "All", "Use" or "Store".
+//Pass the context to the work that the stage does and end the span when
+//the stage finishes.  A stage that stalls shows up as a span that takes
+//too long.
+func Stage(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+//EndStage ends a stage's span and records the error, if any.
+func EndStage(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//startRequest starts a span for a request to Salsa.  The span is tagged
+//with the endpoint, the table and the offset and count for reads.
+func startRequest(req *http.Request, table string) trace.Span {
+	e, t := endpoint(req.URL)
+	if len(table) != 0 {
+		t = table
+	}
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethodKey.String(req.Method),
+		attribute.String("salsa.endpoint", e),
+		attribute.String("salsa.table", t),
+	}
+	if x := strings.SplitN(req.URL.Query().Get("limit"), ",", 2); len(x) == 2 {
+		offset, _ := strconv.Atoi(x[0])
+		count, _ := strconv.Atoi(x[1])
+		attrs = append(attrs, attribute.Int("salsa.offset", offset), attribute.Int("salsa.count", count))
+	}
+	_, span := tracer().Start(req.Context(), "salsa "+e,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return span
+}
+
+//endRequest records the response's status and size and ends the span.
+func endRequest(span trace.Span, resp *http.Response, n int, err error) {
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	}
+	span.SetAttributes(attribute.Int("salsa.bytes", n))
+	EndStage(span, err)
+}